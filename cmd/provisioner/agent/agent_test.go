@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+//newTestAgent returns the client of the agent served in-process with the memory backend having /export root directory
+func newTestAgent(t *testing.T, token string) (*Client, *backend.Memory, func()) {
+	memory := backend.NewMemory()
+	if err := memory.MkdirAll("/export", 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer("/export", memory, "secret"))
+	return NewClient(server.URL, "/pv-store/class1", token, time.Second), memory, server.Close
+}
+
+func TestClient(t *testing.T) {
+	client, memory, stop := newTestAgent(t, "secret")
+	defer stop()
+
+	checkTestResults(t, "mkdir", nil, client.MkdirAll("/pv-store/class1/ns1-pvc1-vol", 0755))
+	exists, _ := memory.Exists("/export/ns1-pvc1-vol")
+	checkTestResults(t, "directory is created under the root", true, exists)
+
+	checkTestResults(t, "chown", nil, client.Chown("/pv-store/class1/ns1-pvc1-vol", 1000, 2000))
+	uid, gid, _ := memory.Owner("/export/ns1-pvc1-vol")
+	checkTestResults(t, "uid", 1000, uid)
+	checkTestResults(t, "gid", 2000, gid)
+
+	checkTestResults(t, "chmod", nil, client.Chmod("/pv-store/class1/ns1-pvc1-vol", 0700))
+	info, err := client.Stat("/pv-store/class1/ns1-pvc1-vol")
+	checkTestResults(t, "stat", nil, err)
+	checkTestResults(t, "name", "ns1-pvc1-vol", info.Name())
+	checkTestResults(t, "mode", os.ModeDir|0700, info.Mode())
+
+	memory.AddFile("/export/ns1-pvc1-vol/data", 42, 0644)
+	usage, err := client.Usage("/pv-store/class1/ns1-pvc1-vol")
+	checkTestResults(t, "usage error", nil, err)
+	checkTestResults(t, "usage", int64(42), usage)
+
+	_, _, err = client.Capacity("/pv-store/class1")
+	checkTestResults(t, "capacity is not set", true, err != nil)
+	memory.SetCapacity(1000)
+	total, available, err := client.Capacity("/pv-store/class1")
+	checkTestResults(t, "capacity error", nil, err)
+	checkTestResults(t, "total", int64(1000), total)
+	checkTestResults(t, "available", int64(958), available)
+	_, _, err = client.Capacity("/pv-store/class1/missing")
+	checkTestResults(t, "capacity of missing path", true, os.IsNotExist(err))
+
+	exists, err = client.Exists("/pv-store/class1")
+	checkTestResults(t, "root exists", true, exists)
+	exists, err = client.Exists("/pv-store/class1/missing")
+	checkTestResults(t, "missing path", false, exists)
+	checkTestResults(t, "missing path is not an error", nil, err)
+	checkTestResults(t, "chown of missing path", true, os.IsNotExist(client.Chown("/pv-store/class1/missing", 1, 1)))
+
+	checkTestResults(t, "remove", nil, client.RemoveAll("/pv-store/class1/ns1-pvc1-vol"))
+	exists, _ = memory.Exists("/export/ns1-pvc1-vol")
+	checkTestResults(t, "directory is removed", false, exists)
+	checkTestResults(t, "root is not removed", false, client.RemoveAll("/pv-store/class1") == nil)
+}
+
+func TestClientPaths(t *testing.T) {
+	client, _, stop := newTestAgent(t, "secret")
+	defer stop()
+
+	checkTestResults(t, "path out of prefix", false, client.MkdirAll("/pv-store/class2/asset", 0755) == nil)
+	checkTestResults(t, "path escaping prefix", false, client.MkdirAll("/pv-store/class1/../class2/asset", 0755) == nil)
+}
+
+func TestServerPaths(t *testing.T) {
+	memory := backend.NewMemory()
+	memory.MkdirAll("/export", 0755)
+	server := httptest.NewServer(NewServer("/export", memory, ""))
+	defer server.Close()
+
+	response, err := http.Post(server.URL+pathMkdir, "application/json", strings.NewReader(`{"path":"../../etc/asset","perm":493}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	checkTestResults(t, "request without token", http.StatusOK, response.StatusCode)
+	exists, _ := memory.Exists("/etc/asset")
+	checkTestResults(t, "directory out of the root", false, exists)
+	exists, _ = memory.Exists("/export/etc/asset")
+	checkTestResults(t, "directory is kept under the root", true, exists)
+
+	response, err = http.Get(server.URL + pathMkdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	checkTestResults(t, "GET method", http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	client, memory, stop := newTestAgent(t, "wrong")
+	defer stop()
+
+	err := client.MkdirAll("/pv-store/class1/asset", 0755)
+	checkTestResults(t, "request is refused", false, err == nil)
+	checkTestResults(t, "refusal is not missing path", false, os.IsNotExist(err))
+	exists, _ := memory.Exists("/export/asset")
+	checkTestResults(t, "directory is not created", false, exists)
+}
+
+func TestExports(t *testing.T) {
+	exportsDir, err := ioutil.TempDir("", "exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(exportsDir)
+
+	var commands []string
+	var failure error
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return []byte("exportfs: failed"), failure
+	}
+	defer func() { runCommand = defaultRunCommand }()
+
+	memory := backend.NewMemory()
+	memory.MkdirAll("/export/ns1-pvc1-vol", 0755)
+	server := NewServer("/export", memory, "")
+	server.EnableExports(exportsDir)
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "/pv-store/class1", "", time.Second)
+
+	checkTestResults(t, "export", nil, client.Export("ns1-pvc1-vol", "/pv-store/class1/ns1-pvc1-vol", []string{"10.0.0.0/24", "10.1.0.0/16"}, "rw,sync"))
+	content, _ := ioutil.ReadFile(filepath.Join(exportsDir, "ns1-pvc1-vol.exports"))
+	checkTestResults(t, "exports file", "# Managed by pv-provisioner agent\n/export/ns1-pvc1-vol 10.0.0.0/24(rw,sync) 10.1.0.0/16(rw,sync)\n", string(content))
+	checkTestResults(t, "exports are reloaded", "exportfs -ra", strings.Join(commands, ";"))
+
+	checkTestResults(t, "export of missing directory", true, os.IsNotExist(client.Export("ns1-pvc2-vol", "/pv-store/class1/ns1-pvc2-vol", []string{"10.0.0.0/24"}, "rw")))
+	checkTestResults(t, "wrong name", false, client.Export("../pvc1", "/pv-store/class1/ns1-pvc1-vol", []string{"10.0.0.0/24"}, "rw") == nil)
+	checkTestResults(t, "wrong client", false, client.Export("pvc1", "/pv-store/class1/ns1-pvc1-vol", []string{"*"}, "rw") == nil)
+	checkTestResults(t, "wrong options", false, client.Export("pvc1", "/pv-store/class1/ns1-pvc1-vol", []string{"10.0.0.0/24"}, "rw) *(rw") == nil)
+
+	failure = fmt.Errorf("exit status 1")
+	checkTestResults(t, "failed reloading", false, client.Export("pvc1", "/pv-store/class1/ns1-pvc1-vol", []string{"10.0.0.0/24"}, "rw") == nil)
+	_, err = os.Stat(filepath.Join(exportsDir, "pvc1.exports"))
+	checkTestResults(t, "exports file is removed after failed reloading", true, os.IsNotExist(err))
+	failure = nil
+
+	commands = nil
+	checkTestResults(t, "unexport", nil, client.Unexport("ns1-pvc1-vol", "/pv-store/class1/ns1-pvc1-vol"))
+	_, err = os.Stat(filepath.Join(exportsDir, "ns1-pvc1-vol.exports"))
+	checkTestResults(t, "exports file is removed", true, os.IsNotExist(err))
+	checkTestResults(t, "exports are reloaded after removal", 1, len(commands))
+	checkTestResults(t, "unexport of missing export", nil, client.Unexport("ns1-pvc1-vol", "/pv-store/class1/ns1-pvc1-vol"))
+	checkTestResults(t, "exports are not reloaded without change", 1, len(commands))
+}
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+/*Client is the backend.Backend passing the operations to the agent over HTTP. The paths under the prefix, as they are seen by
+the provisioner, are sent relative to it, so the prefix corresponds to the root directory of the agent*/
+type Client struct {
+	url        string
+	prefix     string
+	token      string
+	httpClient *http.Client
+}
+
+//NewClient is the func which is like a constructor, empty token means the requests are sent without authorization
+func NewClient(url, prefix, token string, timeout time.Duration) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(url, "/"),
+		prefix:     path.Clean(prefix),
+		token:      token,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+//relative returns the path relative to the prefix, the paths out of the prefix are not passed to the agent
+func (c *Client) relative(op, name string) (string, error) {
+	name = path.Clean(name)
+	if name == c.prefix {
+		return "", nil
+	}
+	if !strings.HasPrefix(name, c.prefix+"/") {
+		return "", &os.PathError{Op: op, Path: name, Err: fmt.Errorf("path is out of prefix: %v served by agent: %v", c.prefix, c.url)}
+	}
+	return strings.TrimPrefix(name, c.prefix+"/"), nil
+}
+
+//call sends the request to the endpoint and decodes the reply
+func (c *Client) call(op, endpoint, name string, req *request) (*response, error) {
+	relative, err := c.relative(op, name)
+	if err != nil {
+		return nil, err
+	}
+	req.Path = relative
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, c.url+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpResponse, err := c.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		failure := new(errorResponse)
+		json.NewDecoder(httpResponse.Body).Decode(failure)
+		return nil, errorOf(op, name, httpResponse.StatusCode, failure.Error)
+	}
+	result := new(response)
+	if err := json.NewDecoder(httpResponse.Body).Decode(result); err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return result, nil
+}
+
+//Stat is implementation of backend.Backend.Stat
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	result, err := c.call("stat", pathStat, name, &request{})
+	if err != nil {
+		return nil, err
+	}
+	if result.Stat == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: fmt.Errorf("agent replied without stat")}
+	}
+	result.Stat.FileName = path.Base(name)
+	return result.Stat, nil
+}
+
+//Exists is implementation of backend.Backend.Exists
+func (c *Client) Exists(name string) (bool, error) {
+	_, err := c.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//MkdirAll is implementation of backend.Backend.MkdirAll
+func (c *Client) MkdirAll(name string, perm os.FileMode) error {
+	_, err := c.call("mkdir", pathMkdir, name, &request{Perm: perm})
+	return err
+}
+
+//Chown is implementation of backend.Backend.Chown
+func (c *Client) Chown(name string, uid, gid int) error {
+	_, err := c.call("chown", pathChown, name, &request{UID: uid, GID: gid})
+	return err
+}
+
+//Chmod is implementation of backend.Backend.Chmod
+func (c *Client) Chmod(name string, perm os.FileMode) error {
+	_, err := c.call("chmod", pathChmod, name, &request{Perm: perm})
+	return err
+}
+
+//RemoveAll is implementation of backend.Backend.RemoveAll
+func (c *Client) RemoveAll(name string) error {
+	_, err := c.call("remove", pathRemove, name, &request{})
+	return err
+}
+
+//Usage is implementation of backend.Backend.Usage
+func (c *Client) Usage(name string) (int64, error) {
+	result, err := c.call("usage", pathUsage, name, &request{})
+	if err != nil {
+		return 0, err
+	}
+	return result.Usage, nil
+}
+
+//Capacity is implementation of backend.Backend.Capacity
+func (c *Client) Capacity(name string) (int64, int64, error) {
+	result, err := c.call("capacity", pathCapacity, name, &request{})
+	if err != nil {
+		return 0, 0, err
+	}
+	return result.Total, result.Available, nil
+}
+
+//Export is implementation of backend.Exporter.Export
+func (c *Client) Export(name, assetPath string, clients []string, options string) error {
+	_, err := c.call("export", pathExport, assetPath, &request{Name: name, Clients: clients, Options: options})
+	return err
+}
+
+//Unexport is implementation of backend.Exporter.Unexport
+func (c *Client) Unexport(name, assetPath string) error {
+	_, err := c.call("unexport", pathUnexport, assetPath, &request{Name: name})
+	return err
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//exportNamePattern is the pattern of names of the exports, the same as names of PVs
+var exportNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+//exportOptionsPattern is the pattern of options of the export which could not break the line of the exports file
+var exportOptionsPattern = regexp.MustCompile(`^[a-zA-Z0-9_=,.:/-]+$`)
+
+//runCommand runs the external command returning its combined output
+var runCommand = defaultRunCommand
+
+func defaultRunCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+//exports writes the files of the exports to the directory and reloads the table of exports of the kernel NFS server
+type exports struct {
+	sync.Mutex
+	dir string
+}
+
+func (e *exports) fileOf(name string) string {
+	return filepath.Join(e.dir, name+".exports")
+}
+
+//exportLine returns the line of the exports file, see exports(5)
+func exportLine(dir string, clients []string, options string) (string, error) {
+	if len(clients) == 0 {
+		return "", fmt.Errorf("Export of: %v must have at least one client", dir)
+	}
+	if !exportOptionsPattern.MatchString(options) {
+		return "", fmt.Errorf("Export of: %v has wrong options: %q", dir, options)
+	}
+	if strings.ContainsAny(dir, " \t\n\"\\") {
+		return "", fmt.Errorf("Path: %q could not be exported", dir)
+	}
+
+	items := []string{dir}
+	for _, client := range clients {
+		if _, _, err := net.ParseCIDR(client); err != nil {
+			return "", fmt.Errorf("Client of export: %v is not CIDR: %v", dir, client)
+		}
+		items = append(items, fmt.Sprintf("%v(%v)", client, options))
+	}
+	return strings.Join(items, " ") + "\n", nil
+}
+
+/*export writes the exports file named after the export and reloads the exports. The file is replaced atomically and is removed
+if the exports could not be reloaded with it*/
+func (e *exports) export(name, dir string, clients []string, options string) error {
+	if !exportNamePattern.MatchString(name) {
+		return fmt.Errorf("Wrong name of export: %q", name)
+	}
+	line, err := exportLine(dir, clients, options)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	temp, err := ioutil.TempFile(e.dir, ".export-")
+	if err != nil {
+		return err
+	}
+	_, err = temp.WriteString("# Managed by pv-provisioner agent\n" + line)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), e.fileOf(name))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	if err := e.reload(); err != nil {
+		os.Remove(e.fileOf(name))
+		e.reload()
+		return err
+	}
+	return nil
+}
+
+//unexport removes the exports file named after the export and reloads the exports
+func (e *exports) unexport(name string) error {
+	if !exportNamePattern.MatchString(name) {
+		return fmt.Errorf("Wrong name of export: %q", name)
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if err := os.Remove(e.fileOf(name)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return e.reload()
+}
+
+func (e *exports) reload() error {
+	if output, err := runCommand("exportfs", "-ra"); err != nil {
+		return fmt.Errorf("Reloading of exports failed: %v: %v", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+/*The endpoints of the agent API. Each of them accepts POST request with JSON request body and replies with JSON body, the paths
+in requests are relative to the root directory of the agent*/
+const (
+	pathStat   = "/v1/stat"
+	pathMkdir  = "/v1/mkdir"
+	pathChown  = "/v1/chown"
+	pathChmod  = "/v1/chmod"
+	pathRemove = "/v1/remove"
+	pathUsage  = "/v1/usage"
+	//pathCapacity replies with the size and the available space of the file system
+	pathCapacity = "/v1/capacity"
+	//pathExport and pathUnexport are served only if the agent manages the exports
+	pathExport   = "/v1/export"
+	pathUnexport = "/v1/unexport"
+	//pathHealth replies with 200 to GET request without authorization
+	pathHealth = "/healthz"
+)
+
+//request is the body of request of any endpoint, the fields which are not needed by the endpoint are ignored
+type request struct {
+	Path string      `json:"path"`
+	Perm os.FileMode `json:"perm,omitempty"`
+	UID  int         `json:"uid,omitempty"`
+	GID  int         `json:"gid,omitempty"`
+	//Name, Clients and Options describe the export of the path
+	Name    string   `json:"name,omitempty"`
+	Clients []string `json:"clients,omitempty"`
+	Options string   `json:"options,omitempty"`
+}
+
+//response is the body of successful reply, the fields which are not produced by the endpoint are omitted
+type response struct {
+	Stat  *fileInfo `json:"stat,omitempty"`
+	Usage int64     `json:"usage,omitempty"`
+	//Total and Available are the size and the available space of the file system in bytes
+	Total     int64 `json:"total,omitempty"`
+	Available int64 `json:"available,omitempty"`
+}
+
+//errorResponse is the body of reply with status other than 200
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+//fileInfo is the os.FileInfo transferred by the agent
+type fileInfo struct {
+	FileName    string      `json:"name"`
+	FileSize    int64       `json:"size"`
+	FileMode    os.FileMode `json:"mode"`
+	FileModTime time.Time   `json:"modTime"`
+}
+
+func newFileInfo(info os.FileInfo) *fileInfo {
+	return &fileInfo{FileName: info.Name(), FileSize: info.Size(), FileMode: info.Mode(), FileModTime: info.ModTime()}
+}
+
+func (i *fileInfo) Name() string       { return i.FileName }
+func (i *fileInfo) Size() int64        { return i.FileSize }
+func (i *fileInfo) Mode() os.FileMode  { return i.FileMode }
+func (i *fileInfo) ModTime() time.Time { return i.FileModTime }
+func (i *fileInfo) IsDir() bool        { return i.FileMode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+//statusOf returns the HTTP status which the error of file system operation is replied with
+func statusOf(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case os.IsExist(err):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+//errorOf returns the error of file system operation having the cause recognizable by os.IsNotExist and similar funcs
+func errorOf(op, name string, status int, message string) error {
+	var cause error
+	switch status {
+	case http.StatusNotFound:
+		cause = os.ErrNotExist
+	case http.StatusForbidden:
+		cause = os.ErrPermission
+	case http.StatusConflict:
+		cause = os.ErrExist
+	default:
+		return &os.PathError{Op: op, Path: name, Err: &remoteError{status: status, message: message}}
+	}
+	return &os.PathError{Op: op, Path: name, Err: cause}
+}
+
+//remoteError is the failure of the agent which is not related to the file system
+type remoteError struct {
+	status  int
+	message string
+}
+
+func (e *remoteError) Error() string {
+	return "agent replied with " + http.StatusText(e.status) + ": " + e.message
+}
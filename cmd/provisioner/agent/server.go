@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	"golang.org/x/time/rate"
+)
+
+/*Server is the http.Handler of the agent running on the file server. It performs the operations of the provisioner with the
+backend under the root directory, the paths could not escape it*/
+type Server struct {
+	root    string
+	backend backend.Backend
+	token   string
+	mux     *http.ServeMux
+	log     *logging.Logger
+	//walkLimiter throttles the walks of the storage assets measuring their usage
+	walkLimiter *rate.Limiter
+}
+
+/*NewServer is the func which is like a constructor. Empty token means the requests are not authorized, otherwise they must
+have "Authorization: Bearer <token>" header*/
+func NewServer(root string, backend backend.Backend, token string) *Server {
+	s := &Server{
+		root:    path.Clean(root),
+		backend: backend,
+		token:   token,
+		mux:     http.NewServeMux(),
+		log:     logging.New(logging.Fields{logging.KeyController: "Agent"}),
+	}
+
+	s.mux.HandleFunc(pathHealth, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	s.handle(pathStat, func(name string, req *request) (*response, error) {
+		info, err := s.backend.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return &response{Stat: newFileInfo(info)}, nil
+	})
+	s.handle(pathMkdir, func(name string, req *request) (*response, error) {
+		return nil, s.backend.MkdirAll(name, req.Perm)
+	})
+	s.handle(pathChown, func(name string, req *request) (*response, error) {
+		return nil, s.backend.Chown(name, req.UID, req.GID)
+	})
+	s.handle(pathChmod, func(name string, req *request) (*response, error) {
+		return nil, s.backend.Chmod(name, req.Perm)
+	})
+	s.handle(pathRemove, func(name string, req *request) (*response, error) {
+		//The root itself is never removed
+		if name == s.root {
+			return nil, fmt.Errorf("Root directory: %v could not be removed", s.root)
+		}
+		return nil, s.backend.RemoveAll(name)
+	})
+	s.handle(pathUsage, func(name string, req *request) (*response, error) {
+		usage, err := s.walkUsage(name)
+		if err != nil {
+			return nil, err
+		}
+		return &response{Usage: usage}, nil
+	})
+	s.handle(pathCapacity, func(name string, req *request) (*response, error) {
+		total, available, err := s.backend.Capacity(name)
+		if err != nil {
+			return nil, err
+		}
+		return &response{Total: total, Available: available}, nil
+	})
+
+	return s
+}
+
+/*ThrottleUsage is the method limiting the number of files and directories read per second by the walks of the usage endpoint*/
+func (s *Server) ThrottleUsage(qps float64) {
+	s.walkLimiter = backend.NewWalkLimiter(qps)
+}
+
+func (s *Server) walkUsage(name string) (int64, error) {
+	return backend.WalkUsage(s.backend, name, func() error {
+		if s.walkLimiter == nil {
+			return nil
+		}
+		return s.walkLimiter.Wait(context.Background())
+	})
+}
+
+/*EnableExports is the method serving the endpoints which export the directories by the kernel NFS server one by one. Each export
+is written to its own file in the directory, e.g. /etc/exports.d, followed by "exportfs -ra"*/
+func (s *Server) EnableExports(dir string) {
+	table := &exports{dir: dir}
+
+	s.handle(pathExport, func(name string, req *request) (*response, error) {
+		info, err := s.backend.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("Path: %v is not a directory", name)
+		}
+		return nil, table.export(req.Name, name, req.Clients, req.Options)
+	})
+	s.handle(pathUnexport, func(name string, req *request) (*response, error) {
+		return nil, table.unexport(req.Name)
+	})
+}
+
+/*handle is the method registering the handler of the endpoint. The handler gets the path of the request resolved under the root
+directory*/
+func (s *Server) handle(pattern string, handler func(name string, req *request) (*response, error)) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			s.reply(w, http.StatusMethodNotAllowed, errorResponse{Error: "only POST method is allowed"})
+			return
+		}
+		if !s.authorized(r) {
+			s.reply(w, http.StatusUnauthorized, errorResponse{Error: "missing or wrong bearer token"})
+			return
+		}
+
+		req := new(request)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.reply(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+		//Cleaning the path as absolute one drops all ".." elements leading out of the root
+		name := path.Join(s.root, path.Clean("/"+req.Path))
+		log := s.log.WithAssetPath(name)
+
+		result, err := handler(name, req)
+		if err != nil {
+			log.Warningf("Request: %v failed: %v", pattern, err)
+			s.reply(w, statusOf(err), errorResponse{Error: err.Error()})
+			return
+		}
+		log.V(logging.LevelChange).Infof("Request: %v succeeded", pattern)
+		if result == nil {
+			result = &response{}
+		}
+		s.reply(w, http.StatusOK, result)
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	expected := "Bearer " + s.token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+func (s *Server) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.Warningf("Could not write reply: %v", err)
+	}
+}
+
+//ServeHTTP is implementation of http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
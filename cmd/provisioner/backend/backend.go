@@ -0,0 +1,236 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	"golang.org/x/time/rate"
+)
+
+/*Backend is the set of file system operations the storage assets are created and deleted with. It might be swapped out e.g.
+for not touching the file system in the dry-run mode or in tests*/
+type Backend interface {
+	//Stat returns os.FileInfo describing the file or directory
+	Stat(path string) (os.FileInfo, error)
+	//Exists returns true if the file or directory exists
+	Exists(path string) (bool, error)
+	//MkdirAll creates the directory along with any necessary parents, it does nothing if the directory already exists
+	MkdirAll(path string, perm os.FileMode) error
+	//Chown changes the numeric uid and gid of the file or directory
+	Chown(path string, uid, gid int) error
+	//Chmod changes the permissions of the file or directory
+	Chmod(path string, perm os.FileMode) error
+	//RemoveAll removes the file or the directory and any children it contains
+	RemoveAll(path string) error
+	//Usage returns the overall size in bytes of the regular files under the path
+	Usage(path string) (int64, error)
+	//Capacity returns the size and the space available to unprivileged users in bytes of the file system containing the path
+	Capacity(path string) (total, available int64, err error)
+}
+
+/*Exporter is implemented by the backends which are able to export the storage assets by NFS one by one. The export is named
+e.g. after the PV and the clients are the networks in CIDR notation allowed to mount it with the options*/
+type Exporter interface {
+	//Export creates or replaces the export of the directory
+	Export(name, path string, clients []string, options string) error
+	//Unexport removes the export of the directory, it does nothing if the export does not exist
+	Unexport(name, path string) error
+}
+
+/*IsLocal is the func returning true if the operations on the path are passed to Local backend, possibly through Router or
+DryRun, i.e. the path might be read and written by os functions as well*/
+func IsLocal(backend Backend, path string) bool {
+	for {
+		switch value := backend.(type) {
+		case Local, *Local:
+			return true
+		case *Router:
+			backend = value.For(path)
+		case *DryRun:
+			backend = value.Backend
+		default:
+			return false
+		}
+	}
+}
+
+/*UsageWalker is implemented by the backends walking the files of Usage themselves. The walk calls wait before each file or
+directory and it is interrupted by the error of wait, so the walk of a huge tree might be throttled*/
+type UsageWalker interface {
+	//WalkUsage is Backend.Usage calling wait before each file or directory
+	WalkUsage(path string, wait func() error) (int64, error)
+}
+
+/*WalkUsage is the func returning the usage of the path, the walk is throttled by wait if the backend walks the files itself*/
+func WalkUsage(backend Backend, path string, wait func() error) (int64, error) {
+	if walker, ok := backend.(UsageWalker); ok {
+		return walker.WalkUsage(path, wait)
+	}
+	return backend.Usage(path)
+}
+
+/*NewWalkLimiter is the func returning the limiter of the files and directories read per second by the walks of UsageWalker, zero
+rate means no limit. Up to a second of tokens might be spent at once*/
+func NewWalkLimiter(qps float64) *rate.Limiter {
+	if qps <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	burst := int(qps)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(qps), burst)
+}
+
+func noWait() error {
+	return nil
+}
+
+/*Local is the Backend working with the local file system of the provisioner*/
+type Local struct{}
+
+//Stat is implementation of Backend.Stat
+func (Local) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+//Exists is implementation of Backend.Exists
+func (Local) Exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//MkdirAll is implementation of Backend.MkdirAll
+func (Local) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+//Chown is implementation of Backend.Chown
+func (Local) Chown(path string, uid, gid int) error {
+	return os.Chown(path, uid, gid)
+}
+
+//Chmod is implementation of Backend.Chmod
+func (Local) Chmod(path string, perm os.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+//RemoveAll is implementation of Backend.RemoveAll
+func (Local) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+//Usage is implementation of Backend.Usage
+func (l Local) Usage(path string) (int64, error) {
+	return l.WalkUsage(path, noWait)
+}
+
+//fileID identifies the file regardless of its hard links
+type fileID struct {
+	dev, ino uint64
+}
+
+/*WalkUsage is implementation of UsageWalker.WalkUsage. The blocks allocated to the regular files are summed up, so the holes of
+sparse files are not counted, and the file having several hard links is counted once. The symlinks are not followed*/
+func (Local) WalkUsage(path string, wait func() error) (int64, error) {
+	var usage int64
+	linked := make(map[fileID]bool)
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := wait(); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			usage += info.Size()
+			return nil
+		}
+		if stat.Nlink > 1 {
+			id := fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+			if linked[id] {
+				return nil
+			}
+			linked[id] = true
+		}
+		//The blocks are always counted in 512-byte units regardless of the block size of the file system
+		usage += int64(stat.Blocks) * 512
+		return nil
+	})
+	return usage, err
+}
+
+//Capacity is implementation of Backend.Capacity
+func (Local) Capacity(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+/*DryRun is the Backend which reads through the wrapped Backend but only logs the plan lines of the changes instead of making them*/
+type DryRun struct {
+	Backend
+	log *logging.Logger
+}
+
+//NewDryRun is the func returning DryRun wrapping the backend
+func NewDryRun(backend Backend) *DryRun {
+	return &DryRun{Backend: backend, log: logging.New(logging.Fields{logging.KeyController: "DryRun"})}
+}
+
+func (d *DryRun) plan(action, path string) *logging.Logger {
+	return d.log.With(logging.Fields{logging.KeyPlan: action, logging.KeyAssetPath: path})
+}
+
+//MkdirAll is implementation of Backend.MkdirAll
+func (d *DryRun) MkdirAll(path string, perm os.FileMode) error {
+	d.plan("mkdir", path).Infof("Directory: %v would be created with permissions: %v", path, perm)
+	return nil
+}
+
+//Chown is implementation of Backend.Chown
+func (d *DryRun) Chown(path string, uid, gid int) error {
+	d.plan("chown", path).Infof("Ownership of: %v would be set as %v:%v", path, uid, gid)
+	return nil
+}
+
+//Chmod is implementation of Backend.Chmod
+func (d *DryRun) Chmod(path string, perm os.FileMode) error {
+	d.plan("chmod", path).Infof("Permissions of: %v would be set as %v", path, perm)
+	return nil
+}
+
+//Export is implementation of Exporter.Export
+func (d *DryRun) Export(name, path string, clients []string, options string) error {
+	d.plan("export", path).Infof("Export: %v of: %v would be created for: %v with options: %v", name, path, clients, options)
+	return nil
+}
+
+//Unexport is implementation of Exporter.Unexport
+func (d *DryRun) Unexport(name, path string) error {
+	d.plan("unexport", path).Infof("Export: %v of: %v would be removed", name, path)
+	return nil
+}
+
+//WalkUsage is implementation of UsageWalker.WalkUsage
+func (d *DryRun) WalkUsage(path string, wait func() error) (int64, error) {
+	return WalkUsage(d.Backend, path, wait)
+}
+
+//RemoveAll is implementation of Backend.RemoveAll
+func (d *DryRun) RemoveAll(path string) error {
+	d.plan("remove", path).Infof("Storage asset: %v would be deleted", path)
+	return nil
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	memory := NewMemory()
+
+	checkTestResults(t, "directories are created", nil, memory.MkdirAll("/root/class/asset", 0750))
+	info, err := memory.Stat("/root/class")
+	checkTestResults(t, "parent is created", nil, err)
+	checkTestResults(t, "parent is directory", true, info.IsDir())
+	checkTestResults(t, "creating existing directory", nil, memory.MkdirAll("/root/class/asset", 0755))
+
+	checkTestResults(t, "file is created", nil, memory.AddFile("/root/class/asset/file1", 100, 0644))
+	checkTestResults(t, "file is created", nil, memory.AddFile("/root/class/asset/file2", 50, 0644))
+	checkTestResults(t, "file without parent", true, os.IsNotExist(memory.AddFile("/root/other/file", 10, 0644)))
+	checkTestResults(t, "directory under file", false, memory.MkdirAll("/root/class/asset/file1/dir", 0755) == nil)
+
+	usage, err := memory.Usage("/root/class")
+	checkTestResults(t, "usage", int64(150), usage)
+	_, err = memory.Usage("/root/other")
+	checkTestResults(t, "usage of missing path", true, os.IsNotExist(err))
+
+	checkTestResults(t, "chown", nil, memory.Chown("/root/class/asset", 1000, 2000))
+	uid, gid, _ := memory.Owner("/root/class/asset")
+	checkTestResults(t, "uid", 1000, uid)
+	checkTestResults(t, "gid", 2000, gid)
+	checkTestResults(t, "chown of missing path", true, os.IsNotExist(memory.Chown("/root/other", 1, 1)))
+
+	checkTestResults(t, "chmod", nil, memory.Chmod("/root/class/asset", 0700))
+	info, _ = memory.Stat("/root/class/asset")
+	checkTestResults(t, "permissions", os.ModeDir|0700, info.Mode())
+
+	checkTestResults(t, "removal", nil, memory.RemoveAll("/root/class/asset"))
+	exists, _ := memory.Exists("/root/class/asset/file1")
+	checkTestResults(t, "children are removed", false, exists)
+	exists, _ = memory.Exists("/root/class")
+	checkTestResults(t, "parent is kept", true, exists)
+	checkTestResults(t, "removal of missing path", nil, memory.RemoveAll("/root/other"))
+}
+
+func TestLocalUsage(t *testing.T) {
+	root, err := ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "file"), make([]byte, 10), 0644)
+	ioutil.WriteFile(filepath.Join(root, "dir", "file"), make([]byte, 20), 0644)
+	os.Symlink(filepath.Join(root, "file"), filepath.Join(root, "link"))
+	os.Link(filepath.Join(root, "dir", "file"), filepath.Join(root, "hardlink"))
+	sparse, _ := os.Create(filepath.Join(root, "sparse"))
+	sparse.WriteAt([]byte("data"), 8<<20)
+	sparse.Close()
+
+	var allocated int64
+	for _, name := range []string{"file", "dir/file", "sparse"} {
+		info, _ := os.Stat(filepath.Join(root, name))
+		allocated += info.Sys().(*syscall.Stat_t).Blocks * 512
+	}
+	usage, err := Local{}.Usage(root)
+	checkTestResults(t, "no error", nil, err)
+	checkTestResults(t, "usage counts allocated blocks once per file", allocated, usage)
+	checkTestResults(t, "holes are not counted", true, usage < 8<<20)
+
+	var walked int
+	_, err = Local{}.WalkUsage(root, func() error {
+		if walked++; walked > 2 {
+			return errors.New("interrupted")
+		}
+		return nil
+	})
+	checkTestResults(t, "walk is interrupted by wait", "interrupted", fmt.Sprint(err))
+
+	exists, err := Local{}.Exists(filepath.Join(root, "missing"))
+	checkTestResults(t, "missing path", false, exists)
+	checkTestResults(t, "no error for missing path", nil, err)
+}
+
+func TestCapacity(t *testing.T) {
+	memory := NewMemory()
+	memory.MkdirAll("/root/class/asset", 0755)
+	_, _, err := memory.Capacity("/root/class")
+	checkTestResults(t, "capacity is not set", true, err != nil)
+
+	memory.SetCapacity(100)
+	memory.AddFile("/root/class/asset/file1", 30, 0644)
+	memory.AddFile("/root/file2", 10, 0644)
+	total, available, err := memory.Capacity("/root/class")
+	checkTestResults(t, "no error", nil, err)
+	checkTestResults(t, "total", int64(100), total)
+	checkTestResults(t, "available", int64(60), available)
+	_, _, err = memory.Capacity("/root/missing")
+	checkTestResults(t, "missing path", true, os.IsNotExist(err))
+
+	memory.AddFile("/root/file3", 200, 0644)
+	_, available, _ = memory.Capacity("/root")
+	checkTestResults(t, "full file system", int64(0), available)
+
+	root, err := ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	total, available, err = Local{}.Capacity(root)
+	checkTestResults(t, "local capacity", nil, err)
+	checkTestResults(t, "local available space is within size", true, total > 0 && available >= 0 && available <= total)
+	_, _, err = Local{}.Capacity(filepath.Join(root, "missing"))
+	checkTestResults(t, "local missing path", true, os.IsNotExist(err))
+}
+
+func TestRouter(t *testing.T) {
+	fallback, class1 := NewMemory(), NewMemory()
+	router := NewRouter(fallback)
+	router.Add("/root/class1", class1)
+
+	checkTestResults(t, "routed operation", nil, router.MkdirAll("/root/class1/asset", 0755))
+	checkTestResults(t, "fallback operation", nil, router.MkdirAll("/root/class10/asset", 0755))
+
+	exists, _ := class1.Exists("/root/class1/asset")
+	checkTestResults(t, "asset of routed prefix", true, exists)
+	exists, _ = class1.Exists("/root/class10/asset")
+	checkTestResults(t, "prefix matches whole path elements", false, exists)
+	exists, _ = fallback.Exists("/root/class10/asset")
+	checkTestResults(t, "asset of fallback", true, exists)
+	checkTestResults(t, "prefix itself is routed", Backend(class1), router.For("/root/class1/"))
+}
+
+func TestIsLocal(t *testing.T) {
+	router := NewRouter(Local{})
+	router.Add("/root/class1", NewMemory())
+	dryRun := NewDryRun(router)
+
+	checkTestResults(t, "local backend", true, IsLocal(Local{}, "/root/class1/asset"))
+	checkTestResults(t, "memory backend", false, IsLocal(NewMemory(), "/root/class1/asset"))
+	checkTestResults(t, "routed to memory", false, IsLocal(dryRun, "/root/class1/asset"))
+	checkTestResults(t, "routed to fallback", true, IsLocal(dryRun, "/root/class2/asset"))
+}
@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//memoryEntry is a file or a directory kept by Memory backend
+type memoryEntry struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	uid     int
+	gid     int
+	modTime time.Time
+}
+
+func (e *memoryEntry) Name() string       { return e.name }
+func (e *memoryEntry) Size() int64        { return e.size }
+func (e *memoryEntry) Mode() os.FileMode  { return e.mode }
+func (e *memoryEntry) ModTime() time.Time { return e.modTime }
+func (e *memoryEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *memoryEntry) Sys() interface{}   { return nil }
+
+/*Memory is the Backend keeping the files and directories in memory. The root directory always exists. It is safe for concurrent
+use and is intended for tests*/
+type Memory struct {
+	locker  sync.Mutex
+	entries map[string]*memoryEntry
+	exports map[string]string
+	//capacity is the size of the file system set by SetCapacity
+	capacity int64
+}
+
+//NewMemory is the func returning empty Memory backend
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]*memoryEntry{"/": {name: "/", mode: os.ModeDir | 0755}}, exports: make(map[string]string)}
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+//AddFile is the method creating the regular file of the size, the parent directory must exist
+func (m *Memory) AddFile(name string, size int64, perm os.FileMode) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	name = path.Clean(name)
+	if parent, ok := m.entries[path.Dir(name)]; !ok || !parent.IsDir() {
+		return notExist("open", name)
+	}
+	m.entries[name] = &memoryEntry{name: path.Base(name), mode: perm, size: size, modTime: time.Now()}
+	return nil
+}
+
+//Paths is the method returning the sorted paths of all files and directories
+func (m *Memory) Paths() []string {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	result := make([]string, 0, len(m.entries))
+	for name := range m.entries {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+//Stat is implementation of Backend.Stat
+func (m *Memory) Stat(name string) (os.FileInfo, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	entry, ok := m.entries[path.Clean(name)]
+	if !ok {
+		return nil, notExist("stat", name)
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+//Exists is implementation of Backend.Exists
+func (m *Memory) Exists(name string) (bool, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	_, ok := m.entries[path.Clean(name)]
+	return ok, nil
+}
+
+//MkdirAll is implementation of Backend.MkdirAll
+func (m *Memory) MkdirAll(name string, perm os.FileMode) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	name = path.Clean(name)
+	missing := make([]string, 0)
+	for current := name; ; current = path.Dir(current) {
+		if entry, ok := m.entries[current]; ok {
+			if !entry.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: current, Err: os.ErrExist}
+			}
+			break
+		}
+		missing = append(missing, current)
+	}
+	for _, item := range missing {
+		m.entries[item] = &memoryEntry{name: path.Base(item), mode: os.ModeDir | perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+//Chown is implementation of Backend.Chown
+func (m *Memory) Chown(name string, uid, gid int) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	entry, ok := m.entries[path.Clean(name)]
+	if !ok {
+		return notExist("chown", name)
+	}
+	entry.uid, entry.gid = uid, gid
+	return nil
+}
+
+//Owner is the method returning uid and gid of the file or directory
+func (m *Memory) Owner(name string) (int, int, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	entry, ok := m.entries[path.Clean(name)]
+	if !ok {
+		return 0, 0, notExist("stat", name)
+	}
+	return entry.uid, entry.gid, nil
+}
+
+//Chmod is implementation of Backend.Chmod
+func (m *Memory) Chmod(name string, perm os.FileMode) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	entry, ok := m.entries[path.Clean(name)]
+	if !ok {
+		return notExist("chmod", name)
+	}
+	entry.mode = entry.mode&os.ModeType | perm.Perm()
+	return nil
+}
+
+//RemoveAll is implementation of Backend.RemoveAll
+func (m *Memory) RemoveAll(name string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	for _, item := range m.under(path.Clean(name)) {
+		delete(m.entries, item)
+	}
+	return nil
+}
+
+//Usage is implementation of Backend.Usage
+func (m *Memory) Usage(name string) (int64, error) {
+	return m.WalkUsage(name, noWait)
+}
+
+//WalkUsage is implementation of UsageWalker.WalkUsage
+func (m *Memory) WalkUsage(name string, wait func() error) (int64, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	name = path.Clean(name)
+	if _, ok := m.entries[name]; !ok {
+		return 0, notExist("lstat", name)
+	}
+	var usage int64
+	for _, item := range m.under(name) {
+		if err := wait(); err != nil {
+			return 0, err
+		}
+		if entry := m.entries[item]; entry.mode.IsRegular() {
+			usage += entry.size
+		}
+	}
+	return usage, nil
+}
+
+//SetCapacity is the method setting the size of the file system in bytes, the files take the space of their sizes
+func (m *Memory) SetCapacity(total int64) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.capacity = total
+}
+
+//Capacity is implementation of Backend.Capacity, it fails until the size of the file system is set
+func (m *Memory) Capacity(name string) (int64, int64, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	name = path.Clean(name)
+	if _, ok := m.entries[name]; !ok {
+		return 0, 0, notExist("statfs", name)
+	}
+	if m.capacity == 0 {
+		return 0, 0, fmt.Errorf("Capacity of the memory backend is not set")
+	}
+	available := m.capacity
+	for _, entry := range m.entries {
+		if entry.mode.IsRegular() {
+			available -= entry.size
+		}
+	}
+	if available < 0 {
+		available = 0
+	}
+	return m.capacity, available, nil
+}
+
+//under returns the path itself and the paths of all its children, the locker must be held
+func (m *Memory) under(name string) []string {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+	result := make([]string, 0)
+	for item := range m.entries {
+		if item == name || strings.HasPrefix(item, prefix) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+//Export is implementation of Exporter.Export, the directory must exist
+func (m *Memory) Export(name, dir string, clients []string, options string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	entry, ok := m.entries[path.Clean(dir)]
+	if !ok || !entry.IsDir() {
+		return notExist("export", dir)
+	}
+	m.exports[name] = path.Clean(dir)
+	return nil
+}
+
+//Unexport is implementation of Exporter.Unexport
+func (m *Memory) Unexport(name, dir string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	delete(m.exports, name)
+	return nil
+}
+
+//ExportOf is the method returning the path of the directory exported by the name or empty string if there is no such export
+func (m *Memory) ExportOf(name string) string {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	return m.exports[name]
+}
@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+type route struct {
+	prefix  string
+	backend Backend
+}
+
+/*Router is the Backend passing each operation to the Backend added for the longest prefix of the path, the operations on other
+paths are passed to the default Backend. It lets storage classes be served by different backends*/
+type Router struct {
+	routes   []route
+	fallback Backend
+}
+
+//NewRouter is the func returning Router passing the operations to the fallback Backend until any route is added
+func NewRouter(fallback Backend) *Router {
+	return &Router{fallback: fallback}
+}
+
+/*Add is the method routing the operations on the prefix directory and everything under it to the backend. It is not safe
+for concurrent use with the operations and should be called on start only*/
+func (r *Router) Add(prefix string, backend Backend) {
+	r.routes = append(r.routes, route{prefix: path.Clean(prefix), backend: backend})
+}
+
+//For is the method returning the Backend the operations on the path are passed to
+func (r *Router) For(name string) Backend {
+	name = path.Clean(name)
+	var result *route
+	for index, item := range r.routes {
+		if name == item.prefix || strings.HasPrefix(name, item.prefix+"/") {
+			if result == nil || len(item.prefix) > len(result.prefix) {
+				result = &r.routes[index]
+			}
+		}
+	}
+	if result == nil {
+		return r.fallback
+	}
+	return result.backend
+}
+
+//Stat is implementation of Backend.Stat
+func (r *Router) Stat(name string) (os.FileInfo, error) {
+	return r.For(name).Stat(name)
+}
+
+//Exists is implementation of Backend.Exists
+func (r *Router) Exists(name string) (bool, error) {
+	return r.For(name).Exists(name)
+}
+
+//MkdirAll is implementation of Backend.MkdirAll
+func (r *Router) MkdirAll(name string, perm os.FileMode) error {
+	return r.For(name).MkdirAll(name, perm)
+}
+
+//Chown is implementation of Backend.Chown
+func (r *Router) Chown(name string, uid, gid int) error {
+	return r.For(name).Chown(name, uid, gid)
+}
+
+//Chmod is implementation of Backend.Chmod
+func (r *Router) Chmod(name string, perm os.FileMode) error {
+	return r.For(name).Chmod(name, perm)
+}
+
+//RemoveAll is implementation of Backend.RemoveAll
+func (r *Router) RemoveAll(name string) error {
+	return r.For(name).RemoveAll(name)
+}
+
+//Usage is implementation of Backend.Usage
+func (r *Router) Usage(name string) (int64, error) {
+	return r.For(name).Usage(name)
+}
+
+//WalkUsage is implementation of UsageWalker.WalkUsage
+func (r *Router) WalkUsage(name string, wait func() error) (int64, error) {
+	return WalkUsage(r.For(name), name, wait)
+}
+
+//Capacity is implementation of Backend.Capacity
+func (r *Router) Capacity(name string) (int64, int64, error) {
+	return r.For(name).Capacity(name)
+}
+
+//Export is implementation of Exporter.Export, it fails if the Backend of the path is not Exporter
+func (r *Router) Export(name, assetPath string, clients []string, options string) error {
+	exporter, ok := r.For(assetPath).(Exporter)
+	if !ok {
+		return fmt.Errorf("Storage asset: %v could not be exported by its backend", assetPath)
+	}
+	return exporter.Export(name, assetPath, clients, options)
+}
+
+//Unexport is implementation of Exporter.Unexport, it fails if the Backend of the path is not Exporter
+func (r *Router) Unexport(name, assetPath string) error {
+	exporter, ok := r.For(assetPath).(Exporter)
+	if !ok {
+		return fmt.Errorf("Export of storage asset: %v could not be removed by its backend", assetPath)
+	}
+	return exporter.Unexport(name, assetPath)
+}
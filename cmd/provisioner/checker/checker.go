@@ -1,43 +1,121 @@
 package checker
 
-//Checker is the gatekeeper interface for PVC and PV to be processed by the provisioner
-type Checker interface {
-	PerformChecks()
-	IsAllOK() bool
-	checkList() map[int]func() bool
+import (
+	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"strings"
+)
+
+//Verdict is the decision about the object made according to the results of the checks
+type Verdict int
+
+const (
+	//Provision means all checks have been passed and the PVC should be provisioned or the PV should be removed
+	Provision Verdict = iota
+	//Skip means the object is not the one the provisioner should handle
+	Skip
+	//RetryLater means the object is not ready to be handled yet but it might become ready soon
+	RetryLater
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Provision:
+		return "provision"
+	case Skip:
+		return "skip"
+	case RetryLater:
+		return "retry-later"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(v))
+	}
 }
 
-//AbstractChecker is absctract checker which is implemented a couple methods
-type AbstractChecker struct {
-	Checker
-	Results map[int]bool
+//Result is the outcome of the single check
+type Result struct {
+	Name   string
+	Passed bool
+	//Reason explains why the check has not been passed
+	Reason string
 }
 
-//PerformChecks is the entry point to checking porcess for client code
-func (ch *AbstractChecker) PerformChecks() {
+//Report is the outcome of the checks performed in their declared order. The checks after the failed one are not performed
+type Report struct {
+	Verdict Verdict
+	Results []Result
+}
 
-	checks := ch.checkList()
+//Failed is method returning the result of the check which has not been passed or nil if all checks have been passed
+func (r Report) Failed() *Result {
+	for index := range r.Results {
+		if !r.Results[index].Passed {
+			return &r.Results[index]
+		}
+	}
+	return nil
+}
 
-	ch.Results = make(map[int]bool)
-	for chName := range checks {
-		ch.Results[chName] = false
+//Passed is method returning true if the check with the name has been performed and passed
+func (r Report) Passed(name string) bool {
+	for _, result := range r.Results {
+		if result.Name == name {
+			return result.Passed
+		}
 	}
+	return false
+}
 
-	for chName, chFn := range checks {
-		if result := chFn(); result {
-			ch.Results[chName] = result
+//String is method returning the human readable report, e.g. "notBound: ok, properStorageClassName: failed (some reason)"
+func (r Report) String() string {
+	items := make([]string, len(r.Results))
+	for index, result := range r.Results {
+		if result.Passed {
+			items[index] = fmt.Sprintf("%v: ok", result.Name)
 		} else {
-			return
+			items[index] = fmt.Sprintf("%v: failed (%v)", result.Name, result.Reason)
 		}
 	}
+	return strings.Join(items, ", ")
 }
 
-//IsAllOK is method that return true is all checks were passed successfully otherwise false
-func (ch AbstractChecker) IsAllOK() bool {
-	for _, ok := range ch.Results {
-		if !ok {
-			return false
+//check is the single step of the checking process. The fn returns empty string if the check is passed or the reason of failure
+type check struct {
+	name      string
+	onFailure Verdict
+	fn        func() string
+}
+
+//Checker is the gatekeeper interface for PVC and PV to be processed by the provisioner
+type Checker interface {
+	PerformChecks() Report
+	checkList() []check
+}
+
+//AbstractChecker is absctract checker which is implemented a couple methods
+type AbstractChecker struct {
+	Checker
+	log *logging.Logger
+}
+
+//SetLogger is the method setting up the logger which the report of the checks is written with
+func (ch *AbstractChecker) SetLogger(log *logging.Logger) {
+	ch.log = log
+}
+
+//PerformChecks is the entry point to checking porcess for client code. The checks are performed in their declared order until
+//the first failure which verdict becomes the verdict of the report
+func (ch *AbstractChecker) PerformChecks() Report {
+	report := Report{Verdict: Provision}
+
+	for _, item := range ch.checkList() {
+		reason := item.fn()
+		report.Results = append(report.Results, Result{Name: item.name, Passed: reason == "", Reason: reason})
+		if reason != "" {
+			report.Verdict = item.onFailure
+			break
 		}
 	}
-	return true
+
+	ch.log.V(logging.LevelDecision).Infof("Checks: %v, verdict: %v", report, report.Verdict)
+	return report
 }
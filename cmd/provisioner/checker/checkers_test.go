@@ -1,12 +1,19 @@
 package checker
 
 import (
+	"errors"
+	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+	"strings"
 	"testing"
 
 	core_v1 "k8s.io/api/core/v1"
 	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 var _appConfig *config.AppConfig
@@ -70,6 +77,23 @@ func initAppConfig() {
 	_appConfig.ParseStorageClass(sc1)
 	_appConfig.ParseStorageClass(sc2)
 	_appConfig.ParseStorageClass(sc3)
+
+	sc4 := new(storage_v1.StorageClass)
+	sc4.Name = "storageClassPolicy"
+	sc4.Provisioner = "some-vendor/some-provisioner1"
+	sc4.ReclaimPolicy = &deletePolicy
+	sc4.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "nfs-server:/some/path",
+		"allowedNamespaces":    "team1, team2",
+		"deniedNamespaces":     "team2",
+		"namespaceSelector":    "storage=flash",
+		"maxRequestSize":       "10Gi",
+		"allowedAccessModes":   "ReadWriteOnce",
+		"requiredClaimLabels":  "cost-center",
+	}
+	_appConfig.ParseStorageClass(sc4)
 }
 
 func init() {
@@ -81,10 +105,10 @@ func TestPVC_check_notBoundCheck(t *testing.T) {
 	pvc2 := getPvcForTests(nil, nil, "", "test-pv")
 
 	ch := NewPvcChecker(pvc1)
-	checkTestResults(t, true, ch.notBound())
+	checkTestResults(t, true, ch.notBound() == "")
 
 	ch = NewPvcChecker(pvc2)
-	checkTestResults(t, false, ch.notBound())
+	checkTestResults(t, false, ch.notBound() == "")
 }
 
 func TestPVC_check_properStorageClassName(t *testing.T) {
@@ -92,10 +116,10 @@ func TestPVC_check_properStorageClassName(t *testing.T) {
 	pvc2 := getPvcForTests(nil, nil, "storageClass1", "test-pv")
 
 	ch := NewPvcChecker(pvc1)
-	checkTestResults(t, false, ch.properStorageClassName())
+	checkTestResults(t, false, ch.properStorageClassName() == "")
 
 	ch = NewPvcChecker(pvc2)
-	checkTestResults(t, true, ch.properStorageClassName())
+	checkTestResults(t, true, ch.properStorageClassName() == "")
 }
 
 func TestPVC_check_selectorsListEmpty(t *testing.T) {
@@ -104,10 +128,10 @@ func TestPVC_check_selectorsListEmpty(t *testing.T) {
 	pvc2 := getPvcForTests(nil, nil, "", "")
 
 	ch := NewPvcChecker(pvc1)
-	checkTestResults(t, false, ch.selectorsListEmpty())
+	checkTestResults(t, false, ch.selectorsListEmpty() == "")
 
 	ch = NewPvcChecker(pvc2)
-	checkTestResults(t, true, ch.selectorsListEmpty())
+	checkTestResults(t, true, ch.selectorsListEmpty() == "")
 }
 
 func TestPVC_check_properProvisionerAnnotation(t *testing.T) {
@@ -119,10 +143,10 @@ func TestPVC_check_properProvisionerAnnotation(t *testing.T) {
 	pvc2 := getPvcForTests(annotations, nil, "storageClass2", "")
 
 	ch := NewPvcChecker(pvc1)
-	checkTestResults(t, false, ch.properProvisionerAnnotation())
+	checkTestResults(t, false, ch.properProvisionerAnnotation() == "")
 
 	ch = NewPvcChecker(pvc2)
-	checkTestResults(t, true, ch.properProvisionerAnnotation())
+	checkTestResults(t, true, ch.properProvisionerAnnotation() == "")
 
 }
 
@@ -134,12 +158,10 @@ func TestPVC_IsAllOK(t *testing.T) {
 	pvc2 := getPvcForTests(annotations, nil, "storageClass1", "some-pv")
 
 	ch := NewPvcChecker(pvc1)
-	ch.PerformChecks()
-	checkTestResults(t, true, ch.IsAllOK())
+	checkTestResults(t, true, ch.PerformChecks().Verdict == Provision)
 
 	ch = NewPvcChecker(pvc2)
-	ch.PerformChecks()
-	checkTestResults(t, false, ch.IsAllOK())
+	checkTestResults(t, false, ch.PerformChecks().Verdict == Provision)
 }
 
 func TestPV_check_properReclaimPolicy(t *testing.T) {
@@ -148,13 +170,13 @@ func TestPV_check_properReclaimPolicy(t *testing.T) {
 	pv3 := getPvForTests(nil, core_v1.PersistentVolumeReclaimDelete, "", "", "")
 
 	ch := NewPvChecker(pv1)
-	checkTestResults(t, false, ch.properReclaimPolicy())
+	checkTestResults(t, false, ch.properReclaimPolicy() == "")
 
 	ch = NewPvChecker(pv2)
-	checkTestResults(t, false, ch.properReclaimPolicy())
+	checkTestResults(t, false, ch.properReclaimPolicy() == "")
 
 	ch = NewPvChecker(pv3)
-	checkTestResults(t, true, ch.properReclaimPolicy())
+	checkTestResults(t, true, ch.properReclaimPolicy() == "")
 }
 
 func TestPV_check_properClassName(t *testing.T) {
@@ -162,10 +184,10 @@ func TestPV_check_properClassName(t *testing.T) {
 	pv2 := getPvForTests(nil, "", "storageClass1", "", "")
 
 	ch := NewPvChecker(pv1)
-	checkTestResults(t, false, ch.properClassName())
+	checkTestResults(t, false, ch.properClassName() == "")
 
 	ch = NewPvChecker(pv2)
-	checkTestResults(t, true, ch.properClassName())
+	checkTestResults(t, true, ch.properClassName() == "")
 }
 
 func TestPV_check_properAnnotations(t *testing.T) {
@@ -185,13 +207,13 @@ func TestPV_check_properAnnotations(t *testing.T) {
 	pv3 := getPvForTests(annotations, "", "storageClass4", "", "")
 
 	ch := NewPvChecker(pv1)
-	checkTestResults(t, true, ch.properAnnotations())
+	checkTestResults(t, true, ch.properAnnotations() == "")
 
 	ch = NewPvChecker(pv2)
-	checkTestResults(t, false, ch.properAnnotations())
+	checkTestResults(t, false, ch.properAnnotations() == "")
 
 	ch = NewPvChecker(pv3)
-	checkTestResults(t, false, ch.properAnnotations())
+	checkTestResults(t, false, ch.properAnnotations() == "")
 }
 
 func TestPV_check_released(t *testing.T) {
@@ -199,10 +221,10 @@ func TestPV_check_released(t *testing.T) {
 	pv2 := getPvForTests(nil, "", "", "", core_v1.VolumeReleased)
 
 	ch := NewPvChecker(pv1)
-	checkTestResults(t, false, ch.released())
+	checkTestResults(t, false, ch.released() == "")
 
 	ch = NewPvChecker(pv2)
-	checkTestResults(t, true, ch.released())
+	checkTestResults(t, true, ch.released() == "")
 }
 
 func TestPV_check_IsAllOk(t *testing.T) {
@@ -215,11 +237,333 @@ func TestPV_check_IsAllOk(t *testing.T) {
 
 	pv1 := getPvForTests(annotations, deletePolicy, "storageClass1", "", releasedPhase)
 	ch := NewPvChecker(pv1)
-	ch.PerformChecks()
-	checkTestResults(t, true, ch.IsAllOK())
+	checkTestResults(t, true, ch.PerformChecks().Verdict == Provision)
 
 	pv2 := getPvForTests(annotations, retainPolicy, "storageClass1", "", releasedPhase)
 	ch = NewPvChecker(pv2)
-	ch.PerformChecks()
-	checkTestResults(t, false, ch.IsAllOK())
+	checkTestResults(t, false, ch.PerformChecks().Verdict == Provision)
+}
+
+func TestPVC_PerformChecks_Verdict(t *testing.T) {
+	annotations := map[string]string{
+		"volume.beta.kubernetes.io/storage-provisioner": "some-vendor/some-provisioner1",
+	}
+	selector := &meta_v1.LabelSelector{MatchLabels: map[string]string{"key1": "value1"}}
+
+	report := NewPvcChecker(getPvcForTests(annotations, selector, "storageClass1", "")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Skip)
+	checkTestResults(t, true, report.Failed().Name == SelectorsListEmpty)
+	checkTestResults(t, true, report.Passed(ProperStorageClassName) && report.Passed(NotBound))
+
+	report = NewPvcChecker(getPvcForTests(nil, selector, "storageClass1", "")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater)
+	checkTestResults(t, true, report.Failed().Name == ProperProvisionerAnnotation)
+	checkTestResults(t, false, report.Passed(SelectorsListEmpty))
+
+	report = NewPvcChecker(getPvcForTests(annotations, nil, "storageClass0", "")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Skip)
+	checkTestResults(t, true, len(report.Results) == 1)
+
+	pvc := getPvcForTests(nil, nil, "", "")
+	pvc.Spec.StorageClassName = nil
+	report = NewPvcChecker(pvc).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Skip)
+
+	report = NewPvcChecker(getPvcForTests(annotations, nil, "storageClass1", "")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	checkTestResults(t, true, report.Failed() == nil)
+	checkTestResults(t, true, strings.HasPrefix(report.String(), "properStorageClassName: ok, notBound: ok, properProvisionerAnnotation: ok, selectorsListEmpty: ok"))
+}
+
+func TestPV_PerformChecks_Verdict(t *testing.T) {
+	annotations := map[string]string{
+		"pv.kubernetes.io/provisioned-by": "some-vendor/some-provisioner2",
+	}
+
+	report := NewPvChecker(getPvForTests(annotations, core_v1.PersistentVolumeReclaimDelete, "storageClass1", "", core_v1.VolumeBound)).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Skip)
+	checkTestResults(t, true, report.Failed().Name == ProperAnnotation)
+	checkTestResults(t, false, report.Passed(Released))
+}
+
+func TestPVC_PerformChecks_Policy(t *testing.T) {
+	annotations := map[string]string{
+		"volume.beta.kubernetes.io/storage-provisioner": "some-vendor/some-provisioner1",
+	}
+	namespaceLabels := map[string]string{"storage": "flash"}
+	getNamespace = func(name string) (*core_v1.Namespace, error) {
+		if namespaceLabels == nil {
+			return nil, errors.New("not available")
+		}
+		return &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: namespaceLabels}}, nil
+	}
+
+	newPvc := func(namespace, size string, mode core_v1.PersistentVolumeAccessMode, claimLabels map[string]string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(annotations, nil, "storageClassPolicy", "")
+		pvc.Namespace = namespace
+		pvc.Labels = claimLabels
+		pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{mode}
+		pvc.Spec.Resources.Requests = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(size)}
+		return pvc
+	}
+	claimLabels := map[string]string{"cost-center": "42"}
+
+	cases := []struct {
+		description string
+		pvc         *core_v1.PersistentVolumeClaim
+		verdict     Verdict
+		failed      string
+	}{
+		{"all policy checks are passed", newPvc("team1", "10Gi", core_v1.ReadWriteOnce, claimLabels), Provision, ""},
+		{"namespace is not allowed", newPvc("team3", "1Gi", core_v1.ReadWriteOnce, claimLabels), Skip, AllowedNamespace},
+		{"namespace is denied", newPvc("team2", "1Gi", core_v1.ReadWriteOnce, claimLabels), Skip, AllowedNamespace},
+		{"size is too big", newPvc("team1", "11Gi", core_v1.ReadWriteOnce, claimLabels), Skip, AllowedSize},
+		{"access mode is not allowed", newPvc("team1", "1Gi", core_v1.ReadWriteMany, claimLabels), Skip, AllowedAccessModes},
+		{"required label is absent", newPvc("team1", "1Gi", core_v1.ReadWriteOnce, nil), Skip, RequiredClaimLabels},
+	}
+	for _, item := range cases {
+		report := NewPvcChecker(item.pvc).PerformChecks()
+		if report.Verdict != item.verdict || (item.failed != "" && report.Failed().Name != item.failed) {
+			t.Errorf("Description: '%v', unexpected verdict: %v, report: %v", item.description, report.Verdict, report)
+		}
+	}
+
+	namespaceLabels = map[string]string{"storage": "spinning"}
+	report := NewPvcChecker(newPvc("team1", "1Gi", core_v1.ReadWriteOnce, claimLabels)).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Skip && report.Failed().Name == SelectedNamespace)
+
+	namespaceLabels = nil
+	report = NewPvcChecker(newPvc("team1", "1Gi", core_v1.ReadWriteOnce, claimLabels)).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == NamespaceAvailable)
+}
+
+func TestPVC_PerformChecks_Budget(t *testing.T) {
+	annotations := map[string]string{
+		"volume.beta.kubernetes.io/storage-provisioner": "some-vendor/some-provisioner1",
+	}
+	getNamespace = func(name string) (*core_v1.Namespace, error) {
+		namespace := &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name}}
+		namespace.Annotations = map[string]string{config.AnnotationStorageBudgetPrefix + "storageClass1": "10Gi"}
+		return namespace, nil
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		config.IndexByClaimNamespace: func(obj interface{}) ([]string, error) {
+			return []string{obj.(*core_v1.PersistentVolume).Spec.ClaimRef.Namespace}, nil
+		},
+	})
+	for index, item := range []struct {
+		namespace, className, provisioner, size string
+	}{
+		{"ns1", "storageClass1", "some-vendor/some-provisioner1", "6Gi"},
+		{"ns1", "storageClass2", "some-vendor/some-provisioner2", "100Gi"},
+		{"ns1", "storageClass1", "another-vendor/another-provisioner", "100Gi"},
+		{"ns2", "storageClass1", "some-vendor/some-provisioner1", "100Gi"},
+	} {
+		pv := getPvForTests(map[string]string{config.AnnotationProvisionedBy: item.provisioner}, "", item.className, fmt.Sprintf("pv%v", index), core_v1.VolumeBound)
+		pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: item.namespace}
+		pv.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(item.size)}
+		indexer.Add(pv)
+	}
+	_appConfig.PersistentVolumes = indexer
+	defer func() {
+		_appConfig.PersistentVolumes = nil
+	}()
+
+	newPvc := func(size string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(annotations, nil, "storageClass1", "")
+		pvc.Namespace = "ns1"
+		pvc.Spec.Resources.Requests = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(size)}
+		return pvc
+	}
+
+	report := NewPvcChecker(newPvc("4Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+
+	report = NewPvcChecker(newPvc("5Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == WithinBudget)
+
+	//The storage admitted for the PVC counts until its PV is in the cache, so the PVCs handled simultaneously do not exceed the budget
+	report = NewPvcChecker(newPvc("2Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	other := newPvc("2Gi")
+	other.Name = "other-pvc"
+	report = NewPvcChecker(other).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	defer storage.ReleaseStorage("ns1/other-pvc")
+	report = NewPvcChecker(newPvc("3Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == WithinBudget)
+
+	storage.ReleaseStorage("ns1/other-pvc")
+	report = NewPvcChecker(newPvc("3Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+
+	//The reservation is dropped once the PV of the PVC is in the cache, the PV itself counts from now on
+	pv := getPvForTests(map[string]string{config.AnnotationProvisionedBy: "some-vendor/some-provisioner1"}, "", "storageClass1", "pv-of-test-pvc", core_v1.VolumeBound)
+	pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "test-pvc"}
+	pv.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("3Gi")}
+	indexer.Add(pv)
+	report = NewPvcChecker(other).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == WithinBudget)
+	indexer.Delete(pv)
+	storage.ReleaseStorage("ns1/test-pvc")
+}
+
+func TestFetchNamespace(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns1"}})
+	_appConfig.Namespaces = indexer
+	_appConfig.Clientset = fake.NewSimpleClientset(&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "ns2"}})
+	defer func() {
+		_appConfig.Namespaces, _appConfig.Clientset = nil, nil
+	}()
+
+	namespace, err := fetchNamespace("ns1")
+	checkTestResults(t, true, err == nil && namespace.Name == "ns1")
+	namespace, err = fetchNamespace("ns2")
+	checkTestResults(t, true, err == nil && namespace.Name == "ns2")
+	_, err = fetchNamespace("ns3")
+	checkTestResults(t, true, err != nil)
+}
+
+func TestPVC_PerformChecks_Capacity(t *testing.T) {
+	annotations := map[string]string{
+		"volume.beta.kubernetes.io/storage-provisioner": "some-vendor/some-provisioner1",
+	}
+	getNamespace = func(name string) (*core_v1.Namespace, error) {
+		return &core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: name}}, nil
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		config.IndexByClaimNamespace: func(obj interface{}) ([]string, error) {
+			return []string{obj.(*core_v1.PersistentVolume).Spec.ClaimRef.Namespace}, nil
+		},
+	})
+	_appConfig.PersistentVolumes = indexer
+	class := _appConfig.StorageClasses["storageClass1"]
+	capacityClass := class
+	capacityClass.Capacity.Enabled = true
+	_appConfig.StorageClasses["storageClass1"] = capacityClass
+	defer func(original func(string) (int64, int64, error)) {
+		getCapacity = original
+		_appConfig.PersistentVolumes = nil
+		_appConfig.StorageClasses["storageClass1"] = class
+	}(getCapacity)
+
+	newPvc := func(name, size string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(annotations, nil, "storageClass1", "")
+		pvc.Name = name
+		pvc.Namespace = "ns1"
+		pvc.Spec.Resources.Requests = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(size)}
+		return pvc
+	}
+
+	//The errors getting the capacity are not mistaken for the lack of space
+	getCapacity = func(className string) (int64, int64, error) { return 0, 0, errors.New("agent is not reachable") }
+	report := NewPvcChecker(newPvc("test-pvc", "1Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == CapacityAvailable)
+	checkTestResults(t, true, report.Failed().Reason == "agent is not reachable")
+
+	getCapacity = func(className string) (int64, int64, error) { return 10 << 30, 10 << 30, nil }
+	report = NewPvcChecker(newPvc("test-pvc", "11Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == FitsCapacity)
+
+	//The storage admitted for the PVCs handled simultaneously is not allocatable, though the file system is still empty
+	report = NewPvcChecker(newPvc("test-pvc", "6Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	defer storage.ReleaseStorage("ns1/test-pvc")
+	report = NewPvcChecker(newPvc("other-pvc", "6Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == FitsCapacity)
+
+	//The PV of the PVC replaces its reservation, the PVs of other storage classes are not counted
+	pv := getPvForTests(map[string]string{config.AnnotationProvisionedBy: "some-vendor/some-provisioner1"}, "", "storageClass1", "pv-of-test-pvc", core_v1.VolumeBound)
+	pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "test-pvc"}
+	pv.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("6Gi")}
+	indexer.Add(pv)
+	other := getPvForTests(map[string]string{config.AnnotationProvisionedBy: "some-vendor/some-provisioner2"}, "", "storageClass2", "pv-of-another-pvc", core_v1.VolumeBound)
+	other.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "another-pvc"}
+	other.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("100Gi")}
+	indexer.Add(other)
+	report = NewPvcChecker(newPvc("other-pvc", "5Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == RetryLater && report.Failed().Name == FitsCapacity)
+	report = NewPvcChecker(newPvc("other-pvc", "4Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	storage.ReleaseStorage("ns1/other-pvc")
+
+	//The released storage is allocatable again
+	indexer.Delete(pv)
+	report = NewPvcChecker(newPvc("other-pvc", "10Gi")).PerformChecks()
+	checkTestResults(t, true, report.Verdict == Provision)
+	storage.ReleaseStorage("ns1/other-pvc")
+}
+
+func TestPVC_check_supportedAccessModes(t *testing.T) {
+	pvc := getPvcForTests(nil, nil, "storageClass1", "")
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteOnce}
+	checkTestResults(t, true, NewPvcChecker(pvc).supportedAccessModes() == "")
+
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteOnce, core_v1.ReadWriteMany}
+	checkTestResults(t, true, NewPvcChecker(pvc).supportedAccessModes() == "")
+
+	//The image is mounted by a single node only
+	var deletePolicy core_v1.PersistentVolumeReclaimPolicy = "Delete"
+	imageClass := new(storage_v1.StorageClass)
+	imageClass.Name = "storageClassImage"
+	imageClass.Provisioner = "some-vendor/some-provisioner1"
+	imageClass.ReclaimPolicy = &deletePolicy
+	imageClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/some/path",
+		"assetType":            config.AssetTypeImage}
+	_appConfig.ParseStorageClass(imageClass)
+	defer delete(_appConfig.StorageClasses, imageClass.Name)
+	pvc = getPvcForTests(nil, nil, imageClass.Name, "")
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteOnce}
+	checkTestResults(t, true, NewPvcChecker(pvc).supportedAccessModes() == "")
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteMany}
+	checkTestResults(t, false, NewPvcChecker(pvc).supportedAccessModes() == "")
+
+	pvc = getPvcForTests(nil, nil, "storageClassPolicy", "")
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteMany}
+	checkTestResults(t, true, NewPvcChecker(pvc).supportedAccessModes() == "")
+}
+
+func TestPVC_check_filesystemVolumeMode(t *testing.T) {
+	pvc := getPvcForTests(nil, nil, "storageClass1", "")
+	checkTestResults(t, true, NewPvcChecker(pvc).filesystemVolumeMode() == "")
+
+	filesystemMode := core_v1.PersistentVolumeFilesystem
+	pvc.Spec.VolumeMode = &filesystemMode
+	checkTestResults(t, true, NewPvcChecker(pvc).filesystemVolumeMode() == "")
+
+	blockMode := core_v1.PersistentVolumeBlock
+	pvc.Spec.VolumeMode = &blockMode
+	checkTestResults(t, false, NewPvcChecker(pvc).filesystemVolumeMode() == "")
+}
+
+func TestPV_check_properInstance(t *testing.T) {
+	defer func() {
+		_appConfig.InstanceID = ""
+		_appConfig.AdoptedInstanceIDs = nil
+		_appConfig.AdoptUnidentified = false
+	}()
+
+	unidentified := getPvForTests(nil, "", "storageClass1", "pv1", "")
+	identified := getPvForTests(map[string]string{config.AnnotationInstanceID: "filer1"}, "", "storageClass1", "pv2", "")
+
+	checkTestResults(t, true, NewPvChecker(unidentified).properInstance() == "")
+	checkTestResults(t, false, NewPvChecker(identified).properInstance() == "")
+
+	_appConfig.InstanceID = "filer1"
+	checkTestResults(t, false, NewPvChecker(unidentified).properInstance() == "")
+	checkTestResults(t, true, NewPvChecker(identified).properInstance() == "")
+
+	_appConfig.InstanceID = "filer2"
+	checkTestResults(t, false, NewPvChecker(identified).properInstance() == "")
+
+	_appConfig.AdoptedInstanceIDs = []string{"filer1"}
+	_appConfig.AdoptUnidentified = true
+	checkTestResults(t, true, NewPvChecker(identified).properInstance() == "")
+	checkTestResults(t, true, NewPvChecker(unidentified).properInstance() == "")
+	checkTestResults(t, false, _appConfig.Adopts("filer2"))
 }
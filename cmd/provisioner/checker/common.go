@@ -6,12 +6,25 @@ import (
 
 var appConfig = config.GetInstance()
 
+//The names of the checks as they are seen in the reports
 const (
-	notBound = iota
-	properStorageClassName
-	properProvisionerAnnotation
-	selectorsListEmpty
-	released
-	properAnnotation
-	properReclaimPolicy
+	NotBound                    = "notBound"
+	ProperStorageClassName      = "properStorageClassName"
+	ProperProvisionerAnnotation = "properProvisionerAnnotation"
+	SelectorsListEmpty          = "selectorsListEmpty"
+	Released                    = "released"
+	ProperAnnotation            = "properAnnotation"
+	ProperReclaimPolicy         = "properReclaimPolicy"
+	ProperInstance              = "properInstance"
+	FilesystemVolumeMode        = "filesystemVolumeMode"
+	SupportedAccessModes        = "supportedAccessModes"
+	AllowedNamespace            = "allowedNamespace"
+	NamespaceAvailable          = "namespaceAvailable"
+	SelectedNamespace           = "selectedNamespace"
+	AllowedSize                 = "allowedSize"
+	AllowedAccessModes          = "allowedAccessModes"
+	RequiredClaimLabels         = "requiredClaimLabels"
+	WithinBudget                = "withinBudget"
+	CapacityAvailable           = "capacityAvailable"
+	FitsCapacity                = "fitsCapacity"
 )
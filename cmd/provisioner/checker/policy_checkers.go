@@ -0,0 +1,216 @@
+package checker
+
+import (
+	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+//getNamespace is the func fetching the namespace of the PVC when the policy of the storage class or the budget check needs it
+var getNamespace = fetchNamespace
+
+/*fetchNamespace returns the namespace from the cache of namespaces, the namespace which is not there yet is fetched from the API*/
+func fetchNamespace(name string) (*core_v1.Namespace, error) {
+	if appConfig.Namespaces != nil {
+		if obj, exists, err := appConfig.Namespaces.GetByKey(name); err == nil && exists {
+			return obj.(*core_v1.Namespace), nil
+		}
+	}
+	return appConfig.Clientset.CoreV1().Namespaces().Get(name, meta_v1.GetOptions{})
+}
+
+//getCapacity is the func getting the size and the available space of the file system of the storage class
+var getCapacity = storage.FileSystemCapacity
+
+func (ch *PvcChecker) policy() config.ClassPolicy {
+	return appConfig.StorageClasses[*ch.pvc.Spec.StorageClassName].Policy
+}
+
+func (ch *PvcChecker) allowedNamespace() string {
+	policy := ch.policy()
+	if contains(policy.DeniedNamespaces, ch.pvc.Namespace) {
+		return fmt.Sprintf("Namespace: %v is denied for storage class: %v", ch.pvc.Namespace, *ch.pvc.Spec.StorageClassName)
+	}
+	if len(policy.AllowedNamespaces) > 0 && !contains(policy.AllowedNamespaces, ch.pvc.Namespace) {
+		return fmt.Sprintf("Namespace: %v is not allowed for storage class: %v", ch.pvc.Namespace, *ch.pvc.Spec.StorageClassName)
+	}
+	return ""
+}
+
+func (ch *PvcChecker) namespaceAvailable() string {
+	if ch.policy().NamespaceSelector == nil && appConfig.PersistentVolumes == nil {
+		return ""
+	}
+
+	namespace, err := getNamespace(ch.pvc.Namespace)
+	if err != nil {
+		return fmt.Sprintf("Could not get namespace: %v: %v", ch.pvc.Namespace, err)
+	}
+	ch.namespace = namespace
+	return ""
+}
+
+func (ch *PvcChecker) selectedNamespace() string {
+	selector := ch.policy().NamespaceSelector
+	if selector == nil || selector.Matches(labels.Set(ch.namespace.Labels)) {
+		return ""
+	}
+	return fmt.Sprintf("Namespace: %v does not match selector: %v of storage class: %v", ch.pvc.Namespace, selector, *ch.pvc.Spec.StorageClassName)
+}
+
+func (ch *PvcChecker) allowedSize() string {
+	maxSize := ch.policy().MaxRequestSize
+	if maxSize == nil {
+		return ""
+	}
+
+	size, ok := ch.pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+	if !ok {
+		return fmt.Sprintf("PersistentVolumeClaim: %v does not request storage size while storage class: %v allows up to: %v", ch.pvc.Name, *ch.pvc.Spec.StorageClassName, maxSize.String())
+	}
+	if size.Cmp(*maxSize) > 0 {
+		return fmt.Sprintf("PersistentVolumeClaim: %v requests: %v which is more than: %v allowed by storage class: %v", ch.pvc.Name, size.String(), maxSize.String(), *ch.pvc.Spec.StorageClassName)
+	}
+	return ""
+}
+
+func (ch *PvcChecker) allowedAccessModes() string {
+	allowed := ch.policy().AllowedAccessModes
+	if len(allowed) == 0 {
+		return ""
+	}
+
+	if mode, ok := unlistedAccessMode(ch.pvc.Spec.AccessModes, allowed); !ok {
+		return fmt.Sprintf("PersistentVolumeClaim: %v requests access mode: %v which is not allowed by storage class: %v", ch.pvc.Name, mode, *ch.pvc.Spec.StorageClassName)
+	}
+	return ""
+}
+
+/*unlistedAccessMode returns the first of requested access modes which is absent in the list and false, or true if all of
+them are in the list*/
+func unlistedAccessMode(requested, list []core_v1.PersistentVolumeAccessMode) (core_v1.PersistentVolumeAccessMode, bool) {
+	for _, mode := range requested {
+		found := false
+		for _, item := range list {
+			if mode == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return mode, false
+		}
+	}
+	return "", true
+}
+
+func (ch *PvcChecker) requiredClaimLabels() string {
+	selector := ch.policy().RequiredClaimLabels
+	if selector == nil || selector.Matches(labels.Set(ch.pvc.Labels)) {
+		return ""
+	}
+	return fmt.Sprintf("PersistentVolumeClaim: %v does not have labels: %v required by storage class: %v", ch.pvc.Name, selector, *ch.pvc.Spec.StorageClassName)
+}
+
+/*withinBudget checks whether the storage requested by the PVC fits into the budget of its namespace for the storage class.
+The budget is the annotation of the namespace, the PVs provisioned for the namespace are found in the cache of PV controller. The
+storage admitted for the other PVCs which PVs are not in the cache yet is counted as well, the storage of the PVC is reserved in
+the same way if it fits*/
+func (ch *PvcChecker) withinBudget() string {
+	if ch.namespace == nil || appConfig.PersistentVolumes == nil {
+		return ""
+	}
+
+	className := *ch.pvc.Spec.StorageClassName
+	value, ok := ch.namespace.Annotations[config.AnnotationStorageBudgetPrefix+className]
+	if !ok {
+		return ""
+	}
+
+	budget, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Sprintf("Namespace: %v has malformed budget: %v of storage class: %v: %v", ch.pvc.Namespace, value, className, err)
+	}
+
+	err = storage.AdmitStorage(ch.pvc, func(reserved func(namespace string) int64) error {
+		used, err := namespaceUsage(ch.pvc.Namespace, className)
+		if err != nil {
+			return fmt.Errorf("Could not calculate storage used by namespace: %v: %v", ch.pvc.Namespace, err)
+		}
+		used.Add(*resource.NewQuantity(reserved(ch.pvc.Namespace), resource.BinarySI))
+
+		requested := ch.pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+		total := used.DeepCopy()
+		total.Add(requested)
+		if total.Cmp(budget) > 0 {
+			return fmt.Errorf("PersistentVolumeClaim: %v requests: %v while namespace: %v has already used: %v of budget: %v of storage class: %v", ch.pvc.Name, requested.String(), ch.pvc.Namespace, used.String(), budget.String(), className)
+		}
+		return nil
+	})
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+/*capacityAvailable gets the capacity of the file system of the storage class if the storage class has capacityAdmission parameter.
+It is done before the admission, so no reservation waits for the file system or the agent*/
+func (ch *PvcChecker) capacityAvailable() string {
+	if !storage.NeedsCapacity(ch.pvc) {
+		return ""
+	}
+
+	total, available, err := getCapacity(*ch.pvc.Spec.StorageClassName)
+	if err != nil {
+		return err.Error()
+	}
+	ch.fileSystem = &fileSystemCapacity{total: total, available: available}
+	return ""
+}
+
+/*fitsCapacity checks whether the storage requested by the PVC fits into the allocatable capacity of the file system of its
+storage class. The storage committed by the PVs of the storage class and reserved for the other PVCs is not allocatable, the
+storage of the PVC is reserved in the same way if it fits*/
+func (ch *PvcChecker) fitsCapacity() string {
+	if ch.fileSystem == nil {
+		return ""
+	}
+	if err := storage.AdmitCapacity(ch.pvc, ch.fileSystem.total, ch.fileSystem.available); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+/*namespaceUsage returns total capacity of the PVs of the storage class provisioned for the namespace*/
+func namespaceUsage(namespace, className string) (resource.Quantity, error) {
+	total := resource.Quantity{}
+
+	objects, err := appConfig.PersistentVolumes.ByIndex(config.IndexByClaimNamespace, namespace)
+	if err != nil {
+		return total, err
+	}
+
+	provisioner := appConfig.StorageClasses[className].Provisioner
+	for _, obj := range objects {
+		pv := obj.(*core_v1.PersistentVolume)
+		if pv.Spec.StorageClassName != className || pv.Annotations[config.AnnotationProvisionedBy] != provisioner {
+			continue
+		}
+		total.Add(pv.Spec.Capacity[core_v1.ResourceStorage])
+	}
+	return total, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
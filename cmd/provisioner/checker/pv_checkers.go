@@ -1,10 +1,10 @@
 package checker
 
 import (
+	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/config"
 
 	core_v1 "k8s.io/api/core/v1"
-	"k8s.io/klog"
 )
 
 /*PvChecker is a gatekeeper through which a PV should pass to be deleted*/
@@ -13,61 +13,47 @@ type PvChecker struct {
 	pv *core_v1.PersistentVolume
 }
 
-func (ch PvChecker) released() bool {
+func (ch PvChecker) released() string {
 	if ch.pv.Status.Phase == core_v1.VolumeReleased {
-		return true
+		return ""
 	}
-
-	klog.V(2).Infof("PersistentVolume: %v is not released yet", ch.pv.Name)
-	return false
+	return fmt.Sprintf("PersistentVolume: %v is not released yet", ch.pv.Name)
 }
 
-func (ch PvChecker) properAnnotations() bool {
+func (ch PvChecker) properAnnotations() string {
 	storageClassName := ch.pv.Spec.StorageClassName
 	currentStorageClass := appConfig.StorageClasses[storageClassName]
 
 	value, ok := ch.pv.Annotations[config.AnnotationProvisionedBy]
 	if ok && value == currentStorageClass.Provisioner {
-		return true
+		return ""
 	}
+	return fmt.Sprintf("PersistentVolume: %v does not have right annotation: %v", ch.pv.Name, currentStorageClass.Provisioner)
+}
 
-	klog.V(2).Infof("PersistentVolume: %v does not have right annotation: %v", ch.pv.Name, currentStorageClass.Provisioner)
-	return false
+/*properInstance checks the PV has been provisioned by the current instance of the provisioner or by the one taken over, in
+order to not delete the data of another instance serving the storage class of the same name*/
+func (ch PvChecker) properInstance() string {
+	instanceID := ch.pv.Annotations[config.AnnotationInstanceID]
+	if appConfig.Owns(instanceID) {
+		return ""
+	}
+	return fmt.Sprintf("PersistentVolume: %v has been provisioned by another instance: %q of the provisioner", ch.pv.Name, instanceID)
 }
 
-func (ch PvChecker) properReclaimPolicy() bool {
+func (ch PvChecker) properReclaimPolicy() string {
 	if ch.pv.Spec.PersistentVolumeReclaimPolicy == core_v1.PersistentVolumeReclaimDelete {
-		return true
+		return ""
 	}
-
-	klog.V(2).Infof("PersistentVolume: %v does not have right reclaimPolicy", ch.pv.Name)
-	return false
-
+	return fmt.Sprintf("PersistentVolume: %v does not have right reclaimPolicy: %v", ch.pv.Name, ch.pv.Spec.PersistentVolumeReclaimPolicy)
 }
 
-func (ch PvChecker) properClassName() bool {
+func (ch PvChecker) properClassName() string {
 	storageClassName := ch.pv.Spec.StorageClassName
 	if _, ok := appConfig.StorageClasses[storageClassName]; ok {
-		return true
+		return ""
 	}
-
-	klog.V(2).Infof("StorageClass: %v of PersistentVolume: %v is not served by current provioner", storageClassName, ch.pv.Name)
-	return false
-}
-
-//IsReleased is method returning the result of whether the PV released or not in order to be deleted by the provisioner
-func (ch PvChecker) IsReleased() bool {
-	return ch.Results[released]
-}
-
-//HasProperClassName is method returning whether PV has proper storage class to be deleted by the provisioner
-func (ch PvChecker) HasProperClassName() bool {
-	return ch.Results[properStorageClassName]
-}
-
-//HasProperReclaimPolicy is method returning whether PV has proper reclaim to be deleted by the provisioner
-func (ch PvChecker) HasProperReclaimPolicy() bool {
-	return ch.Results[properReclaimPolicy]
+	return fmt.Sprintf("StorageClass: %v of PersistentVolume: %v is not served by current provioner", storageClassName, ch.pv.Name)
 }
 
 //NewPvChecker is the factory function for creation PvChecker
@@ -78,11 +64,14 @@ func NewPvChecker(pv *core_v1.PersistentVolume) *PvChecker {
 	return ch
 }
 
-func (ch PvChecker) checkList() map[int]func() bool {
-	return map[int]func() bool{
-		properStorageClassName: ch.properClassName,
-		properAnnotation:       ch.properAnnotations,
-		released:               ch.released,
-		properReclaimPolicy:    ch.properReclaimPolicy,
+/*checkList declares the checks in order they are performed. The PV which is not released yet will get an update once it is
+released therefore there is no need to retry it*/
+func (ch PvChecker) checkList() []check {
+	return []check{
+		{ProperStorageClassName, Skip, ch.properClassName},
+		{ProperAnnotation, Skip, ch.properAnnotations},
+		{ProperInstance, Skip, ch.properInstance},
+		{Released, Skip, ch.released},
+		{ProperReclaimPolicy, Skip, ch.properReclaimPolicy},
 	}
 }
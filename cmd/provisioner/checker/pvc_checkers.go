@@ -3,71 +3,82 @@ package checker
 import (
 	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"sort"
 	"strings"
 
 	core_v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/klog"
 )
 
 /*PvcChecker is a gatekeeper through which a PVC should pass to reach to PV provision*/
 type PvcChecker struct {
 	AbstractChecker
 	pvc *core_v1.PersistentVolumeClaim
+	//namespace is fetched only if the policy of the storage class or the budget check needs it
+	namespace *core_v1.Namespace
+	//fileSystem is the capacity of the file system of the storage class, it is got only if the capacity admission needs it
+	fileSystem *fileSystemCapacity
 }
 
-func (ch PvcChecker) notBound() bool {
+//fileSystemCapacity is the size and the available space in bytes of the file system
+type fileSystemCapacity struct {
+	total     int64
+	available int64
+}
+
+func (ch *PvcChecker) notBound() string {
 	if ch.pvc.Spec.VolumeName == "" {
-		return true
+		return ""
 	}
-
-	klog.V(2).Infof("PersistentVolumeClaim: %v already had been bound to the volume according to Spec.VolumeName", ch.pvc.Name)
-	return false
+	return fmt.Sprintf("PersistentVolumeClaim: %v already had been bound to the volume: %v", ch.pvc.Name, ch.pvc.Spec.VolumeName)
 }
 
-func (ch PvcChecker) properStorageClassName() bool {
-	if _, ok := appConfig.StorageClasses[*ch.pvc.Spec.StorageClassName]; ok {
-		return true
+func (ch *PvcChecker) properStorageClassName() string {
+	if ch.pvc.Spec.StorageClassName != nil {
+		if _, ok := appConfig.StorageClasses[*ch.pvc.Spec.StorageClassName]; ok {
+			return ""
+		}
 	}
 
-	classNames := make([]string, len(appConfig.StorageClasses))
-	index := 0
+	classNames := make([]string, 0, len(appConfig.StorageClasses))
 	for key := range appConfig.StorageClasses {
-		classNames[index] = key
-		index++
+		classNames = append(classNames, key)
 	}
+	sort.Strings(classNames)
 
-	klog.V(2).Infof("PersistentVolumeClaim: %v should be provisioned by another storageClass rather than: %v", ch.pvc.Name, strings.Join(classNames, ", "))
-	return false
+	return fmt.Sprintf("PersistentVolumeClaim: %v should be provisioned by another storageClass rather than: %v", ch.pvc.Name, strings.Join(classNames, ", "))
 }
 
-func (ch PvcChecker) selectorsListEmpty() bool {
+func (ch *PvcChecker) selectorsListEmpty() string {
 	if ch.pvc.Spec.Selector != nil {
-		runtime.HandleError(fmt.Errorf("PersistentVolumeClaim: %v must not have selectors in order to be provisioned", ch.pvc.Name))
-		return false
+		return fmt.Sprintf("PersistentVolumeClaim: %v must not have selectors in order to be provisioned", ch.pvc.Name)
 	}
-	return true
+	return ""
 }
 
-func (ch PvcChecker) properProvisionerAnnotation() bool {
+func (ch *PvcChecker) properProvisionerAnnotation() string {
 	sc := appConfig.StorageClasses[*ch.pvc.Spec.StorageClassName]
 	value, ok := ch.pvc.Annotations[config.AnnotationStorageProvisioner]
 	if ok && value == sc.Provisioner {
-		return true
+		return ""
 	}
-
-	klog.V(2).Infof("PersistentVolumeClaim: %v does not have needed annotation: %v", ch.pvc.Name, config.AnnotationStorageProvisioner)
-	return false
+	return fmt.Sprintf("PersistentVolumeClaim: %v does not have needed annotation: %v", ch.pvc.Name, config.AnnotationStorageProvisioner)
 }
 
-//IsNotBound is method returning true if the PVC is not bound yet or false otherwise
-func (ch PvcChecker) IsNotBound() bool {
-	return ch.AbstractChecker.Results[notBound]
+/*filesystemVolumeMode checks the PVC does not request the raw block device, because the volumes provided by the provisioner
+are directories or image files which kubelet is not able to attach as block devices*/
+func (ch *PvcChecker) filesystemVolumeMode() string {
+	if ch.pvc.Spec.VolumeMode == nil || *ch.pvc.Spec.VolumeMode == core_v1.PersistentVolumeFilesystem {
+		return ""
+	}
+	return fmt.Sprintf("PersistentVolumeClaim: %v requests volumeMode: %v while only %v is supported", ch.pvc.Name, *ch.pvc.Spec.VolumeMode, core_v1.PersistentVolumeFilesystem)
 }
 
-//HasProperStorageClassName is method returning whether PVC has proper storage class to be served by the provisioner
-func (ch PvcChecker) HasProperStorageClassName() bool {
-	return ch.AbstractChecker.Results[properStorageClassName]
+func (ch *PvcChecker) supportedAccessModes() string {
+	supported := appConfig.StorageClasses[*ch.pvc.Spec.StorageClassName].SupportedAccessModes
+	if mode, ok := unlistedAccessMode(ch.pvc.Spec.AccessModes, supported); !ok {
+		return fmt.Sprintf("PersistentVolumeClaim: %v requests access mode: %v which is not supported by storage class: %v", ch.pvc.Name, mode, *ch.pvc.Spec.StorageClassName)
+	}
+	return ""
 }
 
 //NewPvcChecker is the factory function for creation PvcChecker
@@ -78,11 +89,25 @@ func NewPvcChecker(pvc *core_v1.PersistentVolumeClaim) *PvcChecker {
 	return ch
 }
 
-func (ch PvcChecker) checkList() map[int]func() bool {
-	return map[int]func() bool{
-		properStorageClassName:      ch.properStorageClassName,
-		properProvisionerAnnotation: ch.properProvisionerAnnotation,
-		notBound:                    ch.notBound,
-		selectorsListEmpty:          ch.selectorsListEmpty,
+/*checkList declares the checks in order they are performed. The annotation of the provisioner is set by kube-controller-manager
+a bit later than the PVC is created therefore the PVC is retried until it has the annotation. The checks of the policy of the
+storage class are performed last*/
+func (ch *PvcChecker) checkList() []check {
+	return []check{
+		{ProperStorageClassName, Skip, ch.properStorageClassName},
+		{NotBound, Skip, ch.notBound},
+		{ProperProvisionerAnnotation, RetryLater, ch.properProvisionerAnnotation},
+		{SelectorsListEmpty, Skip, ch.selectorsListEmpty},
+		{FilesystemVolumeMode, Skip, ch.filesystemVolumeMode},
+		{SupportedAccessModes, Skip, ch.supportedAccessModes},
+		{AllowedNamespace, Skip, ch.allowedNamespace},
+		{NamespaceAvailable, RetryLater, ch.namespaceAvailable},
+		{SelectedNamespace, Skip, ch.selectedNamespace},
+		{AllowedSize, Skip, ch.allowedSize},
+		{AllowedAccessModes, Skip, ch.allowedAccessModes},
+		{RequiredClaimLabels, Skip, ch.requiredClaimLabels},
+		{WithinBudget, RetryLater, ch.withinBudget},
+		{CapacityAvailable, RetryLater, ch.capacityAvailable},
+		{FitsCapacity, RetryLater, ch.fitsCapacity},
 	}
 }
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"k8s-pv-provisioner/cmd/provisioner/agent"
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//agentShutdownTimeout is how long the requests in progress are waited for on shutdown of the agent
+const agentShutdownTimeout = 30 * time.Second
+
+var (
+	/*agentListenAddress is the address which the agent API is served on*/
+	agentListenAddress string
+	/*agentRoot is the directory on the file server which the agent creates and deletes the storage assets under*/
+	agentRoot string
+	/*agentServerTokenFile is the file containing the bearer token the requests to the agent must have*/
+	agentServerTokenFile string
+	/*agentTLSCertFile and agentTLSKeyFile enable HTTPS for the agent API*/
+	agentTLSCertFile string
+	agentTLSKeyFile  string
+	/*agentExportsDir is the directory which the per-volume exports are written to, empty value disables them*/
+	agentExportsDir string
+	/*agentInsecureNoAuth lets the agent serve the requests without the bearer token*/
+	agentInsecureNoAuth bool
+	/*agentUsageWalkQPS is maximal number of files read per second by the walks measuring usage*/
+	agentUsageWalkQPS float64
+)
+
+func init() {
+	var agentCmd = &cobra.Command{
+		Use:   "agent",
+		Short: "starts the agent on the file server creating and deleting storage assets on requests of the provisioner",
+	}
+
+	agentCmd.Flags().StringVar(&agentListenAddress, "listen-address", ":8090", "address which the agent API is served on")
+	agentCmd.Flags().StringVar(&agentRoot, "root", "", "directory under which the storage assets are created and deleted (requred)")
+	agentCmd.Flags().StringVar(&agentServerTokenFile, "token-file", "", "file containing the bearer token the requests must have (required unless --insecure-no-auth is set)")
+	agentCmd.Flags().StringVar(&agentTLSCertFile, "tls-cert-file", "", "certificate file for serving HTTPS")
+	agentCmd.Flags().StringVar(&agentTLSKeyFile, "tls-key-file", "", "private key file for serving HTTPS")
+	agentCmd.Flags().StringVar(&agentExportsDir, "exports-dir", "", "directory of the kernel NFS server exports which the per-volume exports are written to, e.g. /etc/exports.d (empty value disables them)")
+	agentCmd.Flags().BoolVar(&agentInsecureNoAuth, "insecure-no-auth", false, "serves the requests without authorization, which is acceptable on trusted networks only")
+	agentCmd.Flags().Float64Var(&agentUsageWalkQPS, "usage-walk-qps", 1000, "maximal number of files and directories read per second by the walks measuring usage of storage assets (0 disables the limit)")
+	agentCmd.MarkFlagRequired("root")
+	agentCmd.Run = runAgent
+
+	rootCmd.AddCommand(agentCmd)
+}
+
+//readToken returns the trimmed content of the token file, empty file name means there is no token
+func readToken(fileName string) (string, error) {
+	if fileName == "" {
+		return "", nil
+	}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+/*agentToken returns the token the requests to the agent must have. The agent is not started without it, unless serving without
+authorization is allowed explicitly*/
+func agentToken(fileName string, insecureNoAuth bool) (string, error) {
+	token, err := readToken(fileName)
+	if err != nil {
+		return "", err
+	}
+	if token == "" && !insecureNoAuth {
+		return "", fmt.Errorf("Agent requires non-empty --token-file, use --insecure-no-auth to serve the requests without authorization")
+	}
+	return token, nil
+}
+
+func runAgent(cmd *cobra.Command, args []string) {
+	if info, err := os.Stat(agentRoot); err != nil || !info.IsDir() {
+		log.Fatalf("Root directory: %v is not available: %v", agentRoot, err)
+	}
+	token, err := agentToken(agentServerTokenFile, agentInsecureNoAuth)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if token == "" {
+		log.Warningf("Agent API is served without authorization")
+	}
+
+	handler := agent.NewServer(agentRoot, backend.Local{}, token)
+	handler.ThrottleUsage(agentUsageWalkQPS)
+	if agentExportsDir != "" {
+		if info, err := os.Stat(agentExportsDir); err != nil || !info.IsDir() {
+			log.Fatalf("Exports directory: %v is not available: %v", agentExportsDir, err)
+		}
+		handler.EnableExports(agentExportsDir)
+		log.Infof("Per-volume exports are written to: %v", agentExportsDir)
+	}
+	server := &http.Server{Addr: agentListenAddress, Handler: handler}
+	go func() {
+		var err error
+		log.Infof("Starting agent on: %v serving root directory: %v", agentListenAddress, agentRoot)
+		if agentTLSCertFile != "" || agentTLSKeyFile != "" {
+			err = server.ListenAndServeTLS(agentTLSCertFile, agentTLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			log.Fatalf("Agent failed: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Infof("Received signal: %v, shutting down", <-signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warningf("Agent has not been shut down gracefully: %v", err)
+	}
+}
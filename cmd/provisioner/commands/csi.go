@@ -0,0 +1,96 @@
+package commands
+
+import (
+	appConfig "k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/driver"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+//Version is the version of the provisioner reported by the CSI driver, it is set by -ldflags on build
+var Version = "dev"
+
+var (
+	/*csiEndpoint is the endpoint which the CSI services are served on*/
+	csiEndpoint string
+	/*csiOptions are the name of the driver, the node and the enabled services*/
+	csiOptions driver.Options
+)
+
+func init() {
+	var csiCmd = &cobra.Command{
+		Use:   "csi",
+		Short: "starts the CSI driver provisioning storage assets of the storage classes and mounting them on the node",
+	}
+
+	csiCmd.Flags().StringVar(&csiEndpoint, "endpoint", "unix:///csi/csi.sock", "endpoint which the CSI services are served on")
+	csiCmd.Flags().StringVar(&csiOptions.Name, "driver-name", "csi.pv.provisioner", "name of the CSI driver which the storage classes have as provisioner")
+	csiCmd.Flags().StringVar(&csiOptions.NodeID, "node-id", os.Getenv("NODE_NAME"), "name of the node which the node service runs on (NODE_NAME env by default)")
+	csiCmd.Flags().BoolVar(&csiOptions.Controller, "controller", false, "enables the controller service creating and deleting storage assets")
+	csiCmd.Flags().BoolVar(&csiOptions.Node, "node", false, "enables the node service mounting the volumes")
+	csiCmd.Flags().StringVar(&storageClassNames, "storage-classes", "", "comma separated list of storage class names served by the controller service")
+	csiCmd.Flags().StringVar(&storageAssetRoot, "storage-asset-root", "", "directory where assets will be created by the controller service")
+	csiCmd.Flags().StringVar(&agentTokenFile, "agent-token-file", "", "file containing the bearer token sent to the agents serving storage classes with agentUrl parameter")
+	csiCmd.Flags().DurationVar(&agentTimeout, "agent-timeout", 30*time.Second, "timeout of requests to the agents")
+	csiCmd.Flags().StringVar(&instanceID, "instance-id", "", "identity of the driver instance added to the IDs of the created volumes, only volumes having the same identity are deleted")
+	csiCmd.Flags().StringSliceVar(&adoptedInstanceIDs, "adopt-instance-ids", nil, "comma separated identities of other instances which volumes are taken over")
+	csiCmd.Flags().BoolVar(&adoptUnidentified, "adopt-unidentified", false, "takes over the volumes without identity if --instance-id is specified")
+	csiCmd.Run = runCSI
+
+	rootCmd.AddCommand(csiCmd)
+}
+
+func runCSI(cmd *cobra.Command, args []string) {
+	if !csiOptions.Controller && !csiOptions.Node {
+		log.Fatalf("At least one of --controller and --node flags must be specified")
+	}
+	if csiOptions.Node && csiOptions.NodeID == "" {
+		log.Fatalf("Name of the node must be specified by --node-id flag or NODE_NAME env")
+	}
+	csiOptions.Version = Version
+
+	var classes []storage_v1.StorageClass
+	if csiOptions.Controller {
+		if storageClassNames == "" || storageAssetRoot == "" {
+			log.Fatalf("Flags --storage-classes and --storage-asset-root are required for the controller service")
+		}
+		config, err := restConfig()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		appConfig := appConfig.GetInstance()
+		appConfig.StorageAssetRoot = storageAssetRoot
+		appConfig.Clientset = clientset
+		appConfig.InstanceID = instanceID
+		appConfig.AdoptedInstanceIDs = adoptedInstanceIDs
+		appConfig.AdoptUnidentified = adoptUnidentified
+		classes = loadStorageClasses(clientset)
+		for _, class := range classes {
+			if class.Provisioner != csiOptions.Name {
+				log.Warningf("Storage class: %v has provisioner: %v instead of: %v, its volumes are not created by the driver", class.Name, class.Provisioner, csiOptions.Name)
+			}
+		}
+	}
+
+	csiDriver := driver.NewDriver(csiOptions, classes)
+	go func() {
+		if err := csiDriver.Run(csiEndpoint); err != nil {
+			log.Fatalf("CSI driver failed: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Infof("Received signal: %v, shutting down", <-signals)
+	csiDriver.Stop()
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typed_core_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport"
+)
+
+/*dryRunClientset is the clientset reading through the wrapped one but only logging the plan lines instead of writing PVs and PVCs*/
+type dryRunClientset struct {
+	kubernetes.Interface
+	log *logging.Logger
+}
+
+func newDryRunClientset(clientset kubernetes.Interface) kubernetes.Interface {
+	return &dryRunClientset{Interface: clientset, log: logging.New(logging.Fields{logging.KeyController: "DryRun"})}
+}
+
+func (c *dryRunClientset) CoreV1() typed_core_v1.CoreV1Interface {
+	return &dryRunCoreV1{CoreV1Interface: c.Interface.CoreV1(), log: c.log}
+}
+
+type dryRunCoreV1 struct {
+	typed_core_v1.CoreV1Interface
+	log *logging.Logger
+}
+
+func (c *dryRunCoreV1) PersistentVolumes() typed_core_v1.PersistentVolumeInterface {
+	return &dryRunPersistentVolumes{PersistentVolumeInterface: c.CoreV1Interface.PersistentVolumes(), log: c.log}
+}
+
+func (c *dryRunCoreV1) PersistentVolumeClaims(namespace string) typed_core_v1.PersistentVolumeClaimInterface {
+	return &dryRunPersistentVolumeClaims{PersistentVolumeClaimInterface: c.CoreV1Interface.PersistentVolumeClaims(namespace), log: c.log}
+}
+
+type dryRunPersistentVolumes struct {
+	typed_core_v1.PersistentVolumeInterface
+	log *logging.Logger
+}
+
+func (c *dryRunPersistentVolumes) Create(pv *core_v1.PersistentVolume) (*core_v1.PersistentVolume, error) {
+	c.log.With(logging.Fields{logging.KeyPlan: "createPV"}).WithPV(pv).Infof("PersistentVolume: %v would be created", pv.Name)
+	return pv, nil
+}
+
+func (c *dryRunPersistentVolumes) Update(pv *core_v1.PersistentVolume) (*core_v1.PersistentVolume, error) {
+	c.log.With(logging.Fields{logging.KeyPlan: "updatePV"}).WithPV(pv).Infof("PersistentVolume: %v would be updated", pv.Name)
+	return pv, nil
+}
+
+func (c *dryRunPersistentVolumes) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*core_v1.PersistentVolume, error) {
+	c.log.With(logging.Fields{logging.KeyPlan: "patchPV", logging.KeyPV: name}).Infof("PersistentVolume: %v would be patched: %s", name, data)
+	return c.PersistentVolumeInterface.Get(name, meta_v1.GetOptions{})
+}
+
+func (c *dryRunPersistentVolumes) Delete(name string, options *meta_v1.DeleteOptions) error {
+	c.log.With(logging.Fields{logging.KeyPlan: "deletePV", logging.KeyPV: name}).Infof("PersistentVolume: %v would be deleted", name)
+	return nil
+}
+
+type dryRunPersistentVolumeClaims struct {
+	typed_core_v1.PersistentVolumeClaimInterface
+	log *logging.Logger
+}
+
+func (c *dryRunPersistentVolumeClaims) Update(pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolumeClaim, error) {
+	c.log.With(logging.Fields{logging.KeyPlan: "updatePVC"}).WithPVC(pvc).Infof("PersistentVolumeClaim: %v would be updated", pvc.Name)
+	return pvc, nil
+}
+
+/*dryRunTransport passes the reading requests through and refuses the others, so the writes not going through dryRunClientset, e.g. leases or the events of the recorder, do not reach the cluster either*/
+type dryRunTransport struct {
+	http.RoundTripper
+	log *logging.Logger
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.RoundTripper.RoundTrip(req)
+	}
+	t.log.With(logging.Fields{logging.KeyPlan: "request"}).Infof("Request: %v %v would be sent", req.Method, req.URL.Path)
+	return nil, fmt.Errorf("request %v %v is refused in the dry-run mode", req.Method, req.URL.Path)
+}
+
+/*blockDryRunWrites makes the clients created from the config refuse all the requests changing the cluster*/
+func blockDryRunWrites(config *rest.Config) {
+	log := logging.New(logging.Fields{logging.KeyController: "DryRun"})
+	config.WrapTransport = transport.Wrappers(config.WrapTransport, func(rt http.RoundTripper) http.RoundTripper {
+		return &dryRunTransport{RoundTripper: rt, log: log}
+	})
+}
+
+/*logDryRunEvent logs the event emitted by the recorder as the plan line instead of sending it to the cluster*/
+func logDryRunEvent(event *core_v1.Event) {
+	object := event.InvolvedObject
+	log := logging.New(logging.Fields{logging.KeyPlan: "event", logging.KeyController: "DryRun"})
+	log.With(logging.ObjectFields(object.Kind, object.Namespace, object.Name)).Infof("%v event: %v would be emitted: %v", event.Type, event.Reason, event.Message)
+}
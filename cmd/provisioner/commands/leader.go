@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"time"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+	//leaseExpirationTolerance is how long the lease might stay not renewed by the leader before the liveness check fails
+	leaseExpirationTolerance = 20 * time.Second
+)
+
+/*newLeaderElector is the func returning the elector which calls onStartedLeading once the current instance becomes the leader.
+The app exits when the leadership is lost unless shuttingDown returns true, in order to not have 2 instances working simultaneously.
+The lease is released when the context of the elector is cancelled*/
+func newLeaderElector(clientset *kubernetes.Clientset, recorder record.EventRecorder, namespace, name string, onStartedLeading func(), shuttingDown func() bool) (*leaderelection.LeaderElector, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: meta_v1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: recorder,
+		},
+	}
+
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		//It lets another instance to take over without waiting for the lease expiration on graceful shutdown
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("Became the leader: %v", identity)
+				onStartedLeading()
+			},
+			OnStoppedLeading: func() {
+				if shuttingDown() {
+					log.Infof("Leadership is released: %v", identity)
+					return
+				}
+				log.Fatalf("Leadership is lost: %v", identity)
+			},
+		},
+	})
+}
@@ -0,0 +1,135 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	appConfig "k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	/*migrateStorageClassNames is comma separated names of storage classes which PVs are migrated*/
+	migrateStorageClassNames string
+	/*migrateCSIDriver is the name of the NFS CSI driver the migrated PVs get*/
+	migrateCSIDriver string
+	/*migrateConfirm makes the changes, otherwise they are only logged as plan lines*/
+	migrateConfirm bool
+	/*migrateTimeout is how long the deletion of each PV is waited for*/
+	migrateTimeout time.Duration
+)
+
+func init() {
+	var migrateCmd = &cobra.Command{
+		Use:   "migrate-nfs-csi",
+		Short: "rewrites the provisioned PVs having in-tree NFS volume source to csi volume source of the NFS CSI driver",
+	}
+
+	migrateCmd.Flags().StringVar(&migrateStorageClassNames, "storage-classes", "", "comma separated list of storage class names which PVs are migrated (requred)")
+	migrateCmd.Flags().StringVar(&migrateCSIDriver, "csi-driver", "nfs.csi.k8s.io", "name of the NFS CSI driver")
+	migrateCmd.Flags().BoolVar(&migrateConfirm, "confirm", false, "rewrites the PVs, otherwise the changes are only logged as plan lines")
+	migrateCmd.Flags().DurationVar(&migrateTimeout, "timeout", time.Minute, "how long the deletion of each PV is waited for")
+	migrateCmd.MarkFlagRequired("storage-classes")
+	migrateCmd.Run = runMigrate
+
+	rootCmd.AddCommand(migrateCmd)
+}
+
+func runMigrate(cmd *cobra.Command, args []string) {
+	config, err := restConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	classes, err := clientset.StorageV1().StorageClasses().List(meta_v1.ListOptions{})
+	if err != nil {
+		log.Fatalf("Could not fetch list of storage classes: %v", err)
+	}
+	selected, err := selectClasses(classes.Items, strings.Split(migrateStorageClassNames, ","))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	provisioners := make(map[string]string)
+	for _, class := range selected {
+		provisioners[class.Name] = class.Provisioner
+	}
+
+	pvs, err := clientset.CoreV1().PersistentVolumes().List(meta_v1.ListOptions{})
+	if err != nil {
+		log.Fatalf("Could not fetch list of PVs: %v", err)
+	}
+	var migrated, failed int
+	for index := range pvs.Items {
+		pv := &pvs.Items[index]
+		provisioner, ok := provisioners[pv.Spec.StorageClassName]
+		if !ok || pv.Spec.NFS == nil || pv.Annotations[appConfig.AnnotationProvisionedBy] != provisioner {
+			continue
+		}
+		if err := migratePV(clientset, pv, migrateCSIDriver, migrateConfirm, migrateTimeout); err != nil {
+			log.WithPV(pv).Errorf("PersistentVolume: %v could not be migrated: %v", pv.Name, err)
+			failed++
+			continue
+		}
+		migrated++
+	}
+
+	log.Infof("Migration is finished, migrated PVs: %v, failed PVs: %v, confirmed: %v", migrated, failed, migrateConfirm)
+	if failed > 0 {
+		log.Fatalf("Some PVs have not been migrated")
+	}
+}
+
+/*migratePV replaces the PV by its copy having csi volume source, because the volume source of existing PV could not be changed.
+The finalizer protecting the bound PV is removed first, otherwise the PV would not be deleted until its PVC is deleted. While the
+PV does not exist its PVC is Lost, it is bound again once the copy having the same name and claimRef is created. The mounted
+volumes of the running pods are not affected*/
+func migratePV(clientset kubernetes.Interface, pv *core_v1.PersistentVolume, driver string, confirm bool, timeout time.Duration) error {
+	pvLog := log.WithPV(pv)
+	replacement, err := storage.ConvertNfsToCsi(pv, driver)
+	if err != nil {
+		return err
+	}
+	if !confirm {
+		pvLog.With(logging.Fields{logging.KeyPlan: "migratePV"}).Infof("PersistentVolume: %v would be rewritten to csi volume source of: %v with handle: %v", pv.Name, driver, replacement.Spec.CSI.VolumeHandle)
+		return nil
+	}
+
+	//The reclaim policy does not matter, the deleted PV object is not reclaimed
+	unprotected := pv.DeepCopy()
+	unprotected.Finalizers = nil
+	if unprotected, err = clientset.CoreV1().PersistentVolumes().Update(unprotected); err != nil {
+		return err
+	}
+	uid := unprotected.UID
+	if err := clientset.CoreV1().PersistentVolumes().Delete(pv.Name, &meta_v1.DeleteOptions{Preconditions: &meta_v1.Preconditions{UID: &uid}}); err != nil {
+		return err
+	}
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		_, err := clientset.CoreV1().PersistentVolumes().Get(pv.Name, meta_v1.GetOptions{})
+		return errors.IsNotFound(err), nil
+	})
+	if err == nil {
+		_, err = clientset.CoreV1().PersistentVolumes().Create(replacement)
+	}
+	if err != nil {
+		//The PV has to be restored manually from the dump otherwise
+		dump, _ := json.Marshal(replacement)
+		return fmt.Errorf("PersistentVolume: %v was deleted but its replacement was not created: %v, replacement: %s", pv.Name, err, dump)
+	}
+
+	pvLog.Infof("PersistentVolume: %v was rewritten to csi volume source of: %v", pv.Name, driver)
+	return nil
+}
@@ -1,25 +1,47 @@
 package commands
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/agent"
+	"k8s-pv-provisioner/cmd/provisioner/backend"
 	appConfig "k8s-pv-provisioner/cmd/provisioner/config"
 	"k8s-pv-provisioner/cmd/provisioner/controllers"
 	"k8s-pv-provisioner/cmd/provisioner/controllers/pv"
 	"k8s-pv-provisioner/cmd/provisioner/controllers/pvc"
+	"k8s-pv-provisioner/cmd/provisioner/health"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+	"k8s-pv-provisioner/cmd/provisioner/usage"
 	"os"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	core_v1 "k8s.io/api/core/v1"
 	storage_v1 "k8s.io/api/storage/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typed_core_v1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog"
 )
 
+//eventSourceComponent is the name of the component which the events emitted by the provisioner come from
+const eventSourceComponent = "pv-provisioner"
+
 var (
 	rootCmd = &cobra.Command{
 		Use:   "provisioner help",
@@ -35,6 +57,43 @@ var (
 	kubectlConfig string
 	/*verbosityLogging is logging level for app*/
 	verbosityLogging int
+	/*logFormat is format of log lines: text or json*/
+	logFormat string
+	/*httpAddress is the address which the health and pprof endpoints are served on*/
+	httpAddress string
+	/*enablePprof enables /debug/pprof endpoints*/
+	enablePprof bool
+	/*leaderElect enables leader election in order to run few instances of the provisioner where only one is active*/
+	leaderElect bool
+	/*leaderElectNamespace is the namespace of the lease object used for leader election*/
+	leaderElectNamespace string
+	/*leaderElectID is the name of the lease object used for leader election*/
+	leaderElectID string
+	/*shutdownTimeout is how long the handling of items in progress is waited for on shutdown*/
+	shutdownTimeout time.Duration
+	/*pvcWorkers is number of PVCs handled simultaneously*/
+	pvcWorkers int
+	/*pvWorkers is number of PVs handled simultaneously*/
+	pvWorkers int
+	/*rateLimiterOptions are parameters of retries of the failed items for both controllers*/
+	rateLimiterOptions controllers.RateLimiterOptions
+	/*resyncPeriod is how often all PVCs and PVs from the informer caches are handled again*/
+	resyncPeriod time.Duration
+	/*maxRetries is how many times a failed PVC or PV is retried before giving up*/
+	maxRetries int
+	/*instanceID, adoptedInstanceIDs and adoptUnidentified define which PVs are handled by the current instance*/
+	instanceID         string
+	adoptedInstanceIDs []string
+	adoptUnidentified  bool
+	/*dryRun means the storage assets, PVs, PVCs and events are only logged as plan lines instead of being changed*/
+	dryRun bool
+	/*agentTokenFile is the file containing the bearer token sent to the agents, agentTimeout is the timeout of their requests*/
+	agentTokenFile string
+	agentTimeout   time.Duration
+	/*usageOptions are the interval, the throttling and the source of the scans of disk usage of the storage assets*/
+	usageOptions usage.Options
+
+	log = logging.New(nil)
 )
 
 func init() {
@@ -45,13 +104,38 @@ func init() {
 
 	serveCmd.Flags().StringVar(&storageClassNames, "storage-classes", "", "comma separated list of storage class names to watch for (requred)")
 	serveCmd.Flags().StringVar(&storageAssetRoot, "storage-asset-root", "", "directory where assets will be created  (requred)")
+	serveCmd.Flags().StringVar(&httpAddress, "http-address", ":8080", "address of HTTP server for /healthz, /readyz, /metrics and /debug/pprof endpoints (empty value disables the server)")
+	serveCmd.Flags().BoolVar(&enablePprof, "enable-pprof", false, "enables /debug/pprof endpoints on the HTTP server")
+	serveCmd.Flags().BoolVar(&leaderElect, "leader-elect", false, "enables leader election so only one of the running instances provisions volumes")
+	serveCmd.Flags().StringVar(&leaderElectNamespace, "leader-elect-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the lease object used for leader election (POD_NAMESPACE env by default)")
+	serveCmd.Flags().StringVar(&leaderElectID, "leader-elect-id", "pv-provisioner", "name of the lease object used for leader election")
+	serveCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long the handling of PVCs and PVs in progress is waited for on SIGTERM or SIGINT")
+	serveCmd.Flags().IntVar(&pvcWorkers, "pvc-workers", 1, "number of PVCs handled simultaneously")
+	serveCmd.Flags().IntVar(&pvWorkers, "pv-workers", 1, "number of PVs handled simultaneously")
+	serveCmd.Flags().DurationVar(&rateLimiterOptions.BaseDelay, "retry-base-delay", 5*time.Millisecond, "delay of the first retry of a failed PVC or PV, each next retry is delayed twice longer")
+	serveCmd.Flags().DurationVar(&rateLimiterOptions.MaxDelay, "retry-max-delay", 1000*time.Second, "maximal delay of retry of a failed PVC or PV")
+	serveCmd.Flags().Float64Var(&rateLimiterOptions.QPS, "retry-qps", 10, "overall number of retries per second for each controller")
+	serveCmd.Flags().IntVar(&rateLimiterOptions.Burst, "retry-burst", 100, "overall number of retries which might be done at once for each controller")
+	serveCmd.Flags().DurationVar(&resyncPeriod, "resync-period", 0, "how often all PVCs and PVs are handled again even if they have not been changed (0 disables it)")
+	serveCmd.Flags().IntVar(&maxRetries, "max-retries", 0, "how many times a PVC or PV failed by transient error is retried before giving up (0 means retrying forever)")
+	serveCmd.Flags().StringVar(&instanceID, "instance-id", "", "identity of the provisioner instance stamped on the provisioned PVs, only PVs having the same identity are deleted")
+	serveCmd.Flags().StringSliceVar(&adoptedInstanceIDs, "adopt-instance-ids", nil, "comma separated identities of other instances which PVs are taken over")
+	serveCmd.Flags().BoolVar(&adoptUnidentified, "adopt-unidentified", false, "takes over the PVs without identity if --instance-id is specified")
+	serveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "only logs the changes of storage assets, PVs, PVCs and events which would be made as plan lines")
+	serveCmd.Flags().StringVar(&agentTokenFile, "agent-token-file", "", "file containing the bearer token sent to the agents serving storage classes with agentUrl parameter")
+	serveCmd.Flags().DurationVar(&agentTimeout, "agent-timeout", 30*time.Second, "timeout of requests to the agents")
+	serveCmd.Flags().DurationVar(&usageOptions.Interval, "usage-scan-interval", 0, "pause between the scans of disk usage of the provisioned storage assets (0 disables the scans)")
+	serveCmd.Flags().Float64Var(&usageOptions.QPS, "usage-scan-qps", 1, "maximal number of storage assets scanned per second")
+	serveCmd.Flags().Float64Var(&usageOptions.WalkQPS, "usage-walk-qps", 1000, "maximal number of files and directories read per second by the walks of storage assets (0 disables the limit)")
+	serveCmd.Flags().StringVar(&usageOptions.Source, "usage-source", usage.SourceWalk, "source of disk usage: walk of the files or xfs-quota of the project of the storage asset")
 	serveCmd.MarkFlagRequired("storage-classes")
 	serveCmd.MarkFlagRequired("storage-asset-root")
 	serveCmd.Run = run
 
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.PersistentFlags().StringVarP(&kubectlConfig, "kubectl-config", "c", "", "path to kubectl's config")
-	rootCmd.PersistentFlags().IntVar(&verbosityLogging, "v", 0, "logging verbosity (0..2)")
+	rootCmd.PersistentFlags().IntVar(&verbosityLogging, "v", 0, "logging verbosity (0..3)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "format of log lines (text or json)")
 	rootCmd.PersistentPreRun = persistentPreRun
 }
 
@@ -71,6 +155,11 @@ func persistentPreRun(cmd *cobra.Command, args []string) {
 	flags.Set("logtostderr", "true")
 	flags.Set("v", strconv.Itoa(verbosityLogging))
 	flags.Parse(nil)
+
+	if err := logging.SetFormat(logFormat); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
 
 func selectClasses(source []storage_v1.StorageClass, patterns []string) ([]storage_v1.StorageClass, error) {
@@ -104,62 +193,248 @@ func selectClasses(source []storage_v1.StorageClass, patterns []string) ([]stora
 	return result, nil
 }
 
-func run(cmd *cobra.Command, args []string) {
+//restConfig returns the config of the client of the cluster either from --kubectl-config file or from the pod
+func restConfig() (*rest.Config, error) {
+	if kubectlConfig == "" {
+		log.Infof("Trying to use in-cluster config")
+		return rest.InClusterConfig()
+	}
+	log.Infof("Trying to use config specifyied as file path")
+	return clientcmd.BuildConfigFromFlags("", kubectlConfig)
+}
 
-	var config *rest.Config
-	var err error
+/*loadStorageClasses parses the storage classes of --storage-classes flag to the appConfig and sets up the backend serving them.
+The app exits if any of them could not be served*/
+func loadStorageClasses(clientset kubernetes.Interface) []storage_v1.StorageClass {
+	appConfig := appConfig.GetInstance()
+	clusterStorageClasses, err := clientset.StorageV1().StorageClasses().List(meta_v1.ListOptions{})
+	if err != nil {
+		log.Fatalf("Could not fetch list of storage classes")
+	}
 
-	if kubectlConfig == "" {
-		klog.Info("Trying to use in-cluster config")
-		config, err = rest.InClusterConfig()
-	} else {
-		klog.Info("Trying to use config specifyied as file path")
-		config, err = clientcmd.BuildConfigFromFlags("", kubectlConfig)
+	//Collecting storage classes which the provisioner should serve
+	selected, err := selectClasses(clusterStorageClasses.Items, strings.Split(storageClassNames, ","))
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
+	for _, storageClass := range selected {
+		appConfig.ParseStorageClass(&storageClass)
+	}
+
+	//The storage classes having agent are served by it, the other ones by the local file system
+	agentToken, err := readToken(agentTokenFile)
 	if err != nil {
-		klog.Fatal(err.Error())
+		log.Fatalf("%v", err)
+	}
+	router := backend.NewRouter(appConfig.Backend)
+	for name, storageClass := range appConfig.StorageClasses {
+		if storageClass.AgentURL != "" {
+			prefix := path.Join(storageAssetRoot, name)
+			router.Add(prefix, agent.NewClient(storageClass.AgentURL, prefix, agentToken, agentTimeout))
+			log.Infof("Storage class: %v is served by agent: %v", name, storageClass.AgentURL)
+		}
+	}
+	appConfig.Backend = router
+	return selected
+}
+
+func run(cmd *cobra.Command, args []string) {
+
+	config, err := restConfig()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if dryRun {
+		//The lease would be taken over from the active instance which should not be disturbed by the dry run
+		if leaderElect {
+			log.Fatalf("Flags --dry-run and --leader-elect could not be used together")
+		}
+		blockDryRunWrites(config)
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		klog.Fatal(err.Error())
+		log.Fatalf("%v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	if dryRun {
+		eventBroadcaster.StartEventWatcher(logDryRunEvent)
+	} else {
+		eventBroadcaster.StartRecordingToSink(&typed_core_v1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
 	}
 
 	//From this point we are ready to request a data from k8s cluster
 	appConfig := appConfig.GetInstance()
 	appConfig.StorageAssetRoot = storageAssetRoot
 	appConfig.Clientset = clientset
+	appConfig.DynamicClient = dynamicClient
+	appConfig.Recorder = eventBroadcaster.NewRecorder(scheme.Scheme, core_v1.EventSource{Component: eventSourceComponent})
+	loadStorageClasses(clientset)
 
-	clusterStorageClasses, err := clientset.StorageV1().StorageClasses().List(meta_v1.ListOptions{})
-	if err != nil {
-		klog.Fatal("Could not fetch list of storage classes")
+	if dryRun {
+		log.Infof("Running in the dry-run mode, the changes are only logged as plan lines")
+		appConfig.DryRun = true
+		appConfig.Clientset = newDryRunClientset(clientset)
+		appConfig.Backend = backend.NewDryRun(appConfig.Backend)
 	}
+	appConfig.InstanceID = instanceID
+	appConfig.AdoptedInstanceIDs = adoptedInstanceIDs
+	appConfig.AdoptUnidentified = adoptUnidentified
 
-	//Collecting storage classes which the provisioner should serve
-	selected, err := selectClasses(clusterStorageClasses.Items, strings.Split(storageClassNames, ","))
-	if err != nil {
-		klog.Fatal(err)
-	}
-	for _, storageClass := range selected {
-		appConfig.ParseStorageClass(&storageClass)
+	var usageScanner *usage.Scanner
+	if usageOptions.Interval > 0 {
+		if usageScanner, err = usage.NewScanner(usageOptions); err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
 	// //Preparation steps for PVC controller
-	pvcQueue, pvcIndexer, pvcInformer := controllers.PrepareStuff(clientset, "persistentvolumeclaims")
+	pvcQueue, pvcIndexer, pvcInformer := controllers.PrepareStuff(clientset, "persistentvolumeclaims", controllers.NewRateLimiter(rateLimiterOptions), resyncPeriod)
 	pvcCtrl := controllers.NewController("PersistentVolumeClaim", pvcQueue, pvcIndexer, pvcInformer)
 	pvcCtrl.ItemHandler = pvc.Handler
+	pvcCtrl.ReconcileFilter = pvc.NeedsReconciliation
+	pvcCtrl.MaxRetries = maxRetries
+	pvcCtrl.GiveUpHandler = pvc.GiveUpHandler
+	//There is no need to wait for the next retry once the storage asset population is finished
+	storage.PopulationFinished = pvcCtrl.Enqueue
 
 	//Preparation steps for PV controller
-	pvQueue, pvIndexer, pvInformer := controllers.PrepareStuff(clientset, "persistentvolumes")
+	pvQueue, pvIndexer, pvInformer := controllers.PrepareStuff(clientset, "persistentvolumes", controllers.NewRateLimiter(rateLimiterOptions), resyncPeriod)
 	pvCtrl := controllers.NewController("PersistentVolume", pvQueue, pvIndexer, pvInformer)
+	appConfig.PersistentVolumes = pvIndexer
 	pvCtrl.ItemHandler = pv.Handler
+	pvCtrl.ReconcileFilter = pv.NeedsReconciliation
+	pvCtrl.MaxRetries = maxRetries
+	pvCtrl.GiveUpHandler = pv.GiveUpHandler
+
+	//The PVCs waiting for the budget are handled again once their namespace or its PVs are changed, the ones waiting for free
+	//space once the PVs of their storage class are changed
+	namespaceIndexer, namespaceInformer := controllers.WatchNamespaces(clientset, resyncPeriod)
+	appConfig.Namespaces = namespaceIndexer
+	controllers.NamespaceChanged = func(name string) {
+		for _, key := range pvc.Waiting.Wake(pvc.NamespaceSubject(name)) {
+			pvcCtrl.Enqueue(key)
+		}
+	}
+	controllers.PersistentVolumeChanged = func(changed *core_v1.PersistentVolume) {
+		subjects := []string{pvc.ClassSubject(changed.Spec.StorageClassName)}
+		if changed.Spec.ClaimRef != nil {
+			subjects = append(subjects, pvc.NamespaceSubject(changed.Spec.ClaimRef.Namespace))
+		}
+		for _, key := range pvc.Waiting.Wake(subjects...) {
+			pvcCtrl.Enqueue(key)
+		}
+	}
 
 	//Starting the controllers with one stop-channel
 	stop := make(chan struct{})
-	defer close(stop)
-	go pvcCtrl.Run(stop)
-	go pvCtrl.Run(stop)
+	var controllersRunning sync.WaitGroup
+	startControllers := func() {
+		controllersRunning.Add(2)
+		go namespaceInformer.Run(stop)
+		go func() {
+			defer controllersRunning.Done()
+			pvcCtrl.Run(pvcWorkers, stop)
+		}()
+		go func() {
+			defer controllersRunning.Done()
+			pvCtrl.Run(pvWorkers, stop)
+		}()
+		//The scan in progress is not waited for on shutdown, it does not change anything but the annotations
+		if usageScanner != nil {
+			go usageScanner.Run(stop)
+		}
+	}
+
+	var shuttingDown int32
+	electorCtx, cancelElector := context.WithCancel(context.Background())
+	electorStopped := make(chan struct{})
+	close(electorStopped)
+
+	var healthServer *health.Server
+	if httpAddress != "" {
+		healthServer = health.NewServer(httpAddress, enablePprof)
+		healthServer.Handle("/metrics", promhttp.Handler())
+		healthServer.AddLivenessCheck("pvc-controller", pvcCtrl.CheckAlive)
+		healthServer.AddLivenessCheck("pv-controller", pvCtrl.CheckAlive)
+		healthServer.AddReadinessCheck("pvc-informer-synced", pvcCtrl.CheckSynced)
+		healthServer.AddReadinessCheck("pv-informer-synced", pvCtrl.CheckSynced)
+		//The check writes a probe file which is not done in the dry-run mode
+		if !dryRun {
+			healthServer.AddReadinessCheck("storage-asset-root", storage.CheckStorageAssetRoots)
+		}
+	}
+
+	if leaderElect {
+		if leaderElectNamespace == "" {
+			log.Fatalf("Namespace for leader election must be specified by --leader-elect-namespace flag or POD_NAMESPACE env")
+		}
+		elector, err := newLeaderElector(clientset, appConfig.Recorder, leaderElectNamespace, leaderElectID, startControllers, func() bool {
+			return atomic.LoadInt32(&shuttingDown) == 1
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if healthServer != nil {
+			healthServer.AddLivenessCheck("leader-election", func() error {
+				return elector.Check(leaseExpirationTolerance)
+			})
+			healthServer.AddReadinessCheck("leader", func() error {
+				if !elector.IsLeader() {
+					return fmt.Errorf("Current instance is not the leader, the leader is: %v", elector.GetLeader())
+				}
+				return nil
+			})
+		}
+		electorStopped = make(chan struct{})
+		go func() {
+			defer close(electorStopped)
+			elector.Run(electorCtx)
+		}()
+	} else {
+		startControllers()
+	}
+
+	if healthServer != nil {
+		healthServer.Start()
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	log.Infof("Received signal: %v, shutting down", <-signals)
+	atomic.StoreInt32(&shuttingDown, 1)
+
+	//The informers are stopped, the queues are shut down and the handling of items in progress is waited for
+	close(stop)
+	stopped := make(chan struct{})
+	go func() {
+		controllersRunning.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Infof("All controllers are stopped")
+	case <-time.After(shutdownTimeout):
+		log.Warningf("Controllers are not stopped within: %v, exiting anyway", shutdownTimeout)
+	case sig := <-signals:
+		log.Warningf("Received signal: %v again, exiting immediately", sig)
+	}
+
+	//The lease is released only after the controllers are stopped in order to not have 2 working leaders
+	cancelElector()
+	select {
+	case <-electorStopped:
+	case <-time.After(renewDeadline):
+	}
 
-	//Wait forever
-	select {}
+	klog.Flush()
+	log.Infof("Provisioner is stopped")
 }
@@ -1,9 +1,20 @@
 package commands
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	core_v1 "k8s.io/api/core/v1"
 	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 )
 
 func TestSelectingStorageClass(t *testing.T) {
@@ -44,3 +55,113 @@ func TestSelectingStorageClass(t *testing.T) {
 	}
 
 }
+
+func TestDryRunClientset(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	dryRun := newDryRunClientset(clientset)
+
+	pv := &core_v1.PersistentVolume{}
+	pv.Name = "pv1"
+	if _, err := dryRun.CoreV1().PersistentVolumes().Create(pv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.CoreV1().PersistentVolumes().Get(pv.Name, meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("PV should not be created in the dry-run mode, error: %v", err)
+	}
+
+	if _, err := clientset.CoreV1().PersistentVolumes().Create(pv); err != nil {
+		t.Fatal(err)
+	}
+	if err := dryRun.CoreV1().PersistentVolumes().Delete(pv.Name, &meta_v1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dryRun.CoreV1().PersistentVolumes().Get(pv.Name, meta_v1.GetOptions{}); err != nil {
+		t.Errorf("PV should be read through and not be deleted in the dry-run mode, error: %v", err)
+	}
+}
+
+func TestMigratePV(t *testing.T) {
+	pv := &core_v1.PersistentVolume{}
+	pv.Name = "pv1"
+	pv.Finalizers = []string{"kubernetes.io/pv-protection"}
+	pv.Spec.NFS = &core_v1.NFSVolumeSource{Server: "nfs1", Path: "/exports/class1/pv1"}
+	pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "pvc1"}
+	clientset := fake.NewSimpleClientset(pv)
+
+	if err := migratePV(clientset, pv, "nfs.csi.k8s.io", false, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	actual, err := clientset.CoreV1().PersistentVolumes().Get(pv.Name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Spec.NFS == nil {
+		t.Errorf("PV should not be migrated without confirmation")
+	}
+
+	if err := migratePV(clientset, pv, "nfs.csi.k8s.io", true, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	actual, err = clientset.CoreV1().PersistentVolumes().Get(pv.Name, meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Spec.NFS != nil || actual.Spec.CSI == nil || actual.Spec.CSI.Driver != "nfs.csi.k8s.io" {
+		t.Errorf("PV should have csi volume source after migration: %+v", actual.Spec.PersistentVolumeSource)
+	}
+	if actual.Spec.ClaimRef == nil || actual.Spec.ClaimRef.Name != "pvc1" {
+		t.Errorf("PV should keep its claimRef after migration: %+v", actual.Spec.ClaimRef)
+	}
+}
+
+func TestDryRunTransport(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"default"}}`))
+	}))
+	defer server.Close()
+
+	config := &rest.Config{Host: server.URL}
+	blockDryRunWrites(config)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := clientset.CoreV1().Namespaces().Get("default", meta_v1.GetOptions{}); err != nil {
+		t.Errorf("Namespace should be read in the dry-run mode, error: %v", err)
+	}
+	event := &core_v1.Event{}
+	event.Name = "event1"
+	if _, err := clientset.CoreV1().Events("default").Create(event); err == nil {
+		t.Errorf("Event should be refused in the dry-run mode")
+	}
+	if _, err := clientset.CoreV1().Namespaces().Update(&core_v1.Namespace{ObjectMeta: meta_v1.ObjectMeta{Name: "default"}}); err == nil {
+		t.Errorf("Namespace update should be refused in the dry-run mode")
+	}
+	if len(methods) != 1 || methods[0] != http.MethodGet {
+		t.Errorf("Only reading requests should reach the server, got: %v", methods)
+	}
+}
+
+func TestAgentToken(t *testing.T) {
+	file, err := ioutil.TempFile("", "agent-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("secret\n")
+	file.Close()
+
+	if token, err := agentToken(file.Name(), false); err != nil || token != "secret" {
+		t.Errorf("Token should be read from the file, got: %v, error: %v", token, err)
+	}
+	if _, err := agentToken("", false); err == nil {
+		t.Errorf("Agent should not start without token")
+	}
+	if token, err := agentToken("", true); err != nil || token != "" {
+		t.Errorf("Agent should start without token when it is allowed explicitly, got: %v, error: %v", token, err)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+/*CapacityAdmission is the set of parameters of storage class comparing the storage requested by the claims with the free space
+of the file system*/
+type CapacityAdmission struct {
+	//Enabled means the claims not fitting into the free space are not provisioned
+	Enabled bool
+	//HeadroomPercent is the part of the file system in percent which is kept free, it is used if HeadroomBytes is 0
+	HeadroomPercent float64
+	//HeadroomBytes is the space in bytes which is kept free
+	HeadroomBytes int64
+	//Overcommit is how many times the free space above the headroom might be requested, the volumes are rarely full
+	Overcommit float64
+}
+
+/*Allocatable is the method returning the storage in bytes which might be requested by new claims on the file system of the
+size and the available space while the claims of the storage class have already committed the storage in bytes. The committed
+storage is subtracted from the size above the headroom multiplied by the overcommit ratio, and the new claims never get more than
+the space available above the headroom multiplied by the ratio, e.g. the file system is shared with other data*/
+func (a CapacityAdmission) Allocatable(total, available, committed int64) int64 {
+	headroom := a.HeadroomBytes
+	if headroom == 0 {
+		headroom = int64(math.Ceil(float64(total) * a.HeadroomPercent / 100))
+	}
+	if available <= headroom || total <= headroom {
+		return 0
+	}
+	allocatable := int64(float64(total-headroom)*a.Overcommit) - committed
+	if free := int64(float64(available-headroom) * a.Overcommit); allocatable > free {
+		allocatable = free
+	}
+	if allocatable < 0 {
+		return 0
+	}
+	return allocatable
+}
+
+/*parseCapacityAdmission returns the capacity admission parameters of the storage class. The app exits if the headroom is neither
+percent nor quantity or the overcommit ratio is not positive number*/
+func parseCapacityAdmission(class *storage_v1.StorageClass) CapacityAdmission {
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+	enabled := strings.ToLower(getOptionalStorageClassParameter(class, "capacityAdmission", "false"))
+	admission := CapacityAdmission{Enabled: enabled == "true" || enabled == "yes", Overcommit: 1}
+
+	headroom := getOptionalStorageClassParameter(class, "capacityHeadroom", "0")
+	if strings.HasSuffix(headroom, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(headroom, "%"), 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			log.Fatalf("The parameter 'capacityHeadroom' must be percent in range 0..100 or quantity: %v", headroom)
+		}
+		admission.HeadroomPercent = percent
+	} else {
+		size, err := resource.ParseQuantity(headroom)
+		if err != nil || size.Sign() < 0 {
+			log.Fatalf("The parameter 'capacityHeadroom' must be percent in range 0..100 or quantity: %v", headroom)
+		}
+		admission.HeadroomBytes = size.Value()
+	}
+
+	if value := getOptionalStorageClassParameter(class, "capacityOvercommit", ""); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio <= 0 || math.IsInf(ratio, 0) {
+			log.Fatalf("The parameter 'capacityOvercommit' must be positive number: %v", value)
+		}
+		admission.Overcommit = ratio
+	}
+	return admission
+}
@@ -1,12 +1,23 @@
 package config
 
 import (
+	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"net"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
 
 	core_v1 "k8s.io/api/core/v1"
 	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/klog"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 /*storageClassDetails is set of the needed items for work fetched from "k8s.io/api/storage/v1" and few custom ones*/
@@ -23,6 +34,36 @@ type storageClassDetails struct {
 	Provisioner string
 	//ReclaimPolicy is value of *v1.PersistentVolumeReclaimPolicy which should have one of Recycle, Delete, Retain
 	ReclaimPolicy *core_v1.PersistentVolumeReclaimPolicy
+	//AssetTemplate is path to a directory or a tar(.gz) file relative to the storage asset root which new assets are seeded from (optional)
+	AssetTemplate string
+	//TemplatesDir is the directory relative to the storage asset root which the claims might pick templates from by annotation (optional)
+	TemplatesDir string
+	//MaxConcurrentOperations is maximal number of assets which are created or deleted simultaneously for the class, 0 means unlimited (optional)
+	MaxConcurrentOperations int
+	//Policy restricts the claims which the class might be provisioned for (optional)
+	Policy ClassPolicy
+	//SupportedAccessModes is the list of access modes the volumes of the class are able to provide
+	SupportedAccessModes []core_v1.PersistentVolumeAccessMode
+	//AssetType is either AssetTypeDirectory or AssetTypeImage (optional)
+	AssetType string
+	//ImageFilesystem is the file system the image assets are formatted with: ext4, xfs or none (optional)
+	ImageFilesystem string
+	//Volume describes the volume source of the provisioned PVs
+	Volume VolumeSource
+	//AgentURL is the URL of the agent on the file server which the storage assets are created and deleted by (optional)
+	AgentURL string
+	//PerVolumeExports means each storage asset is exported separately by the agent (optional)
+	PerVolumeExports bool
+	//ExportClients is the list of networks in CIDR notation which the exports are restricted to
+	ExportClients []string
+	//ExportOptions are the options of the exports, see exports(5)
+	ExportOptions string
+	//EphemeralReclaim is either EphemeralReclaimDelete or EphemeralReclaimInherit, it applies to the claims owned by pods
+	EphemeralReclaim string
+	//Capacity restricts the storage requested by the claims to the free space of the file system (optional)
+	Capacity CapacityAdmission
+	//EphemeralAssetDir is the directory relative to the assetRoot where the storage assets of the claims owned by pods are created (optional)
+	EphemeralAssetDir string
 }
 
 var config *AppConfig
@@ -32,6 +73,7 @@ func GetInstance() *AppConfig {
 	if config == nil {
 		config = new(AppConfig)
 		config.StorageClasses = make(StorageClassesMap)
+		config.Backend = backend.Local{}
 	}
 	return config
 }
@@ -45,6 +87,20 @@ func (conf *AppConfig) ParseStorageClass(class *storage_v1.StorageClass) {
 	sc.DefaultOwnerAssetUID = (getStorageClassParameters(class, "defaultOwnerAssetUid", 1)).(int)
 	sc.DefaultOwnerAssetGID = (getStorageClassParameters(class, "defaultOwnerAssetGid", 1)).(int)
 	sc.StorageAssetRoot = (getStorageClassParameters(class, "assetRoot", "")).(string)
+	sc.Volume = parseVolumeSource(class, sc.StorageAssetRoot)
+	sc.AssetTemplate = getOptionalStorageClassParameter(class, "assetTemplate", "")
+	sc.TemplatesDir = parseRelativeDir(class, "templatesDir")
+	sc.MaxConcurrentOperations = getOptionalIntStorageClassParameter(class, "maxConcurrentOperations", 0)
+	sc.Policy = parseClassPolicy(class)
+	sc.AssetType, sc.ImageFilesystem = parseAssetType(class, sc.Volume.Type)
+	sc.AgentURL = parseAgentURL(class, sc.AssetType)
+	sc.PerVolumeExports, sc.ExportClients, sc.ExportOptions = parseExports(class, sc.AgentURL, sc.Volume.Type)
+	sc.EphemeralReclaim, sc.EphemeralAssetDir = parseEphemeral(class)
+	sc.Capacity = parseCapacityAdmission(class)
+	sc.SupportedAccessModes = parseAccessModes(class, "supportedAccessModes")
+	if len(sc.SupportedAccessModes) == 0 {
+		sc.SupportedAccessModes = defaultAccessModes(sc.AssetType)
+	}
 
 	conf.StorageClasses[sc.Name] = *sc
 }
@@ -53,14 +109,14 @@ func (conf *AppConfig) ParseStorageClass(class *storage_v1.StorageClass) {
 func getStorageClassParameters(class *storage_v1.StorageClass, paramName string, castTo interface{}) interface{} {
 	tmpStr, ok := class.Parameters[paramName]
 	if !ok {
-		klog.Fatalf("Parameter '%s' in storage class '%s' must be defined", paramName, class.Name)
+		logging.New(logging.Fields{logging.KeyStorageClass: class.Name}).Fatalf("Parameter '%s' in storage class '%s' must be defined", paramName, class.Name)
 	}
 
 	switch castTo.(type) {
 	case int:
 		tmpInt, err := strconv.ParseInt(tmpStr, 10, 32)
 		if err != nil {
-			klog.Fatalf("Could not cast to UInt value of the parameter '%s': %v", paramName, tmpStr)
+			logging.New(logging.Fields{logging.KeyStorageClass: class.Name}).Fatalf("Could not cast to UInt value of the parameter '%s': %v", paramName, tmpStr)
 		}
 		return int(tmpInt)
 	case string:
@@ -70,6 +126,120 @@ func getStorageClassParameters(class *storage_v1.StorageClass, paramName string,
 	}
 }
 
+/*getOptionalStorageClassParameter returns value of the parameter of the storage class or defaultValue if the parameter is not defined*/
+func getOptionalStorageClassParameter(class *storage_v1.StorageClass, paramName string, defaultValue string) string {
+	if value, ok := class.Parameters[paramName]; ok {
+		return value
+	}
+	return defaultValue
+}
+
+/*getOptionalIntStorageClassParameter returns integer value of the parameter of the storage class or defaultValue if the parameter is not defined*/
+func getOptionalIntStorageClassParameter(class *storage_v1.StorageClass, paramName string, defaultValue int) int {
+	if _, ok := class.Parameters[paramName]; !ok {
+		return defaultValue
+	}
+	return (getStorageClassParameters(class, paramName, 1)).(int)
+}
+
+/*parseAssetType returns the type of storage assets of the storage class and the file system of the image assets. The app
+exits if they are unknown or the image assets are used with other volume type than hostPath, because kubelet is not able to
+mount the file on network share directly*/
+func parseAssetType(class *storage_v1.StorageClass, volumeType string) (string, string) {
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+
+	assetType := getOptionalStorageClassParameter(class, "assetType", AssetTypeDirectory)
+	filesystem := getOptionalStorageClassParameter(class, "imageFilesystem", "ext4")
+
+	switch assetType {
+	case AssetTypeDirectory:
+		return assetType, ""
+	case AssetTypeImage:
+	default:
+		log.Fatalf("Unknown value of the parameter 'assetType': %v", assetType)
+	}
+
+	if volumeType != VolumeTypeHostPath {
+		log.Fatalf("The parameter 'volumeType' must be %v if the parameter 'assetType' is %v: %v", VolumeTypeHostPath, AssetTypeImage, volumeType)
+	}
+	switch filesystem {
+	case "ext4", "xfs", ImageFilesystemNone:
+	default:
+		log.Fatalf("Unknown value of the parameter 'imageFilesystem': %v", filesystem)
+	}
+	return assetType, filesystem
+}
+
+/*parseAgentURL returns the URL of the agent serving the storage class. The app exits if it is not absolute http(s) URL or the
+storage class has image assets, because the agent does not create and format the images*/
+func parseAgentURL(class *storage_v1.StorageClass, assetType string) string {
+	value := getOptionalStorageClassParameter(class, "agentUrl", "")
+	if value == "" {
+		return ""
+	}
+
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		log.Fatalf("The parameter 'agentUrl' must be absolute http or https URL: %v", value)
+	}
+	if assetType == AssetTypeImage {
+		log.Fatalf("The parameter 'agentUrl' could not be used if the parameter 'assetType' is %v", AssetTypeImage)
+	}
+	return value
+}
+
+/*parseExports returns whether the storage assets of the storage class are exported one by one, the clients and the options of
+the exports. The app exits if the exports are requested for the storage class without agent or of other volume type than nfs, or the
+clients are not valid CIDRs*/
+func parseExports(class *storage_v1.StorageClass, agentURL, volumeType string) (bool, []string, string) {
+	value := strings.ToLower(getOptionalStorageClassParameter(class, "perVolumeExports", "false"))
+	if value != "true" && value != "yes" {
+		return false, nil, ""
+	}
+
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+	if agentURL == "" || volumeType != VolumeTypeNFS {
+		log.Fatalf("The parameter 'perVolumeExports' requires the parameter 'agentUrl' and %v volume type", VolumeTypeNFS)
+	}
+	clients := splitList(getOptionalStorageClassParameter(class, "exportClients", ""))
+	if len(clients) == 0 {
+		log.Fatalf("The parameter 'exportClients' must list the networks of the nodes if the parameter 'perVolumeExports' is true")
+	}
+	for _, client := range clients {
+		if _, _, err := net.ParseCIDR(client); err != nil {
+			log.Fatalf("The parameter 'exportClients' has the value which is not CIDR: %v", client)
+		}
+	}
+	return true, clients, getOptionalStorageClassParameter(class, "exportOptions", "rw,sync,no_subtree_check")
+}
+
+/*parseEphemeral returns the reclaim policy and the directory of the storage assets of the claims owned by pods. The app exits if
+the policy is unknown or the directory is not a plain relative path inside the assetRoot*/
+func parseEphemeral(class *storage_v1.StorageClass) (string, string) {
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+
+	reclaim := getOptionalStorageClassParameter(class, "ephemeralReclaim", EphemeralReclaimInherit)
+	if reclaim != EphemeralReclaimDelete && reclaim != EphemeralReclaimInherit {
+		log.Fatalf("Unknown value of the parameter 'ephemeralReclaim': %v", reclaim)
+	}
+
+	return reclaim, parseRelativeDir(class, "ephemeralAssetDir")
+}
+
+/*parseRelativeDir returns the optional parameter being a directory relative to another one. The app exits if the directory is not
+a plain relative path which stays inside the other one*/
+func parseRelativeDir(class *storage_v1.StorageClass, name string) string {
+	dir := getOptionalStorageClassParameter(class, name, "")
+	if dir == "" {
+		return ""
+	}
+	if path.IsAbs(dir) || path.Clean(dir) != dir || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") || strings.Contains(dir, ":") {
+		logging.New(logging.Fields{logging.KeyStorageClass: class.Name}).Fatalf("The parameter '%v' must be a plain relative path: %v", name, dir)
+	}
+	return dir
+}
+
 /*StorageClassesMap is the map of storage classes that the provisioner will serve*/
 type StorageClassesMap map[string]storageClassDetails
 
@@ -77,5 +247,69 @@ type StorageClassesMap map[string]storageClassDetails
 type AppConfig struct {
 	StorageClasses   StorageClassesMap
 	StorageAssetRoot string
-	Clientset        *kubernetes.Clientset
+	Clientset        kubernetes.Interface
+	//Backend is used for creating and deleting the storage assets
+	Backend backend.Backend
+	//DryRun means the changes on the file system and in the cluster are only logged as plan lines
+	DryRun bool
+	//DynamicClient is used for fetching objects which are not part of the core API like VolumeSnapshots
+	DynamicClient dynamic.Interface
+	//Recorder is used for emitting events for PVCs and PVs handled by the provisioner
+	Recorder record.EventRecorder
+	//PersistentVolumes is the cache of PVs having IndexByClaimNamespace index
+	PersistentVolumes cache.Indexer
+	//Namespaces is the cache of namespaces, the namespaces missing in it are fetched from the API
+	Namespaces cache.Indexer
+	//InstanceID is the identity of the provisioner instance stamped on the provisioned PVs
+	InstanceID string
+	//AdoptedInstanceIDs is the list of identities of other instances which PVs are taken over by the current one
+	AdoptedInstanceIDs []string
+	//AdoptUnidentified allows to take over the PVs which do not have the identity, e.g. provisioned by earlier versions
+	AdoptUnidentified bool
+}
+
+/*Adopts is the method returning true if the PV stamped with the identity should be taken over by the current instance. Empty
+identity means the PV has not been stamped*/
+func (conf *AppConfig) Adopts(instanceID string) bool {
+	if instanceID == conf.InstanceID {
+		return false
+	}
+	if instanceID == "" {
+		return conf.AdoptUnidentified
+	}
+	for _, item := range conf.AdoptedInstanceIDs {
+		if item == instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+/*Owns is the method returning true if the PV stamped with the identity might be handled by the current instance*/
+func (conf *AppConfig) Owns(instanceID string) bool {
+	return instanceID == conf.InstanceID || conf.Adopts(instanceID)
+}
+
+/*Event is the method emitting event for the object if the Recorder has been set up, otherwise it does nothing. In the dry-run
+mode the event is only logged as a plan line*/
+func (conf *AppConfig) Event(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if conf.DryRun {
+		log := logging.New(logging.Fields{logging.KeyPlan: "event"})
+		if accessor, err := meta.Accessor(object); err == nil {
+			kind := object.GetObjectKind().GroupVersionKind().Kind
+			switch object.(type) {
+			case *core_v1.PersistentVolume:
+				kind = "PersistentVolume"
+			case *core_v1.PersistentVolumeClaim:
+				kind = "PersistentVolumeClaim"
+			}
+			log = log.With(logging.ObjectFields(kind, accessor.GetNamespace(), accessor.GetName()))
+		}
+		log.Infof("%v event: %v would be emitted: %v", eventType, reason, fmt.Sprintf(messageFmt, args...))
+		return
+	}
+	if conf.Recorder == nil {
+		return
+	}
+	conf.Recorder.Eventf(object, eventType, reason, messageFmt, args...)
 }
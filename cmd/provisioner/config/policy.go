@@ -0,0 +1,100 @@
+package config
+
+import (
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+/*ClassPolicy is the set of restrictions of the claims which the storage class might be provisioned for. Empty values mean
+no restriction*/
+type ClassPolicy struct {
+	//AllowedNamespaces is the list of namespaces which the claims might be from
+	AllowedNamespaces []string
+	//DeniedNamespaces is the list of namespaces which the claims must not be from
+	DeniedNamespaces []string
+	//NamespaceSelector is the selector of labels which namespace of the claim must match
+	NamespaceSelector labels.Selector
+	//MaxRequestSize is the maximal size of storage requested by the claim
+	MaxRequestSize *resource.Quantity
+	//AllowedAccessModes is the list of access modes which the claims might request
+	AllowedAccessModes []core_v1.PersistentVolumeAccessMode
+	//RequiredClaimLabels is the selector of labels which the claim must match
+	RequiredClaimLabels labels.Selector
+}
+
+/*parseClassPolicy returns the policy built from the optional parameters of the storage class. The app exits if any of them
+is malformed*/
+func parseClassPolicy(class *storage_v1.StorageClass) ClassPolicy {
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+	policy := ClassPolicy{
+		AllowedNamespaces: splitList(getOptionalStorageClassParameter(class, "allowedNamespaces", "")),
+		DeniedNamespaces:  splitList(getOptionalStorageClassParameter(class, "deniedNamespaces", "")),
+	}
+
+	var err error
+	if value := getOptionalStorageClassParameter(class, "namespaceSelector", ""); value != "" {
+		if policy.NamespaceSelector, err = labels.Parse(value); err != nil {
+			log.Fatalf("Could not parse the parameter 'namespaceSelector': %v: %v", value, err)
+		}
+	}
+
+	if value := getOptionalStorageClassParameter(class, "requiredClaimLabels", ""); value != "" {
+		if policy.RequiredClaimLabels, err = labels.Parse(value); err != nil {
+			log.Fatalf("Could not parse the parameter 'requiredClaimLabels': %v: %v", value, err)
+		}
+	}
+
+	if value := getOptionalStorageClassParameter(class, "maxRequestSize", ""); value != "" {
+		size, err := resource.ParseQuantity(value)
+		if err != nil {
+			log.Fatalf("Could not parse the parameter 'maxRequestSize': %v: %v", value, err)
+		}
+		policy.MaxRequestSize = &size
+	}
+
+	policy.AllowedAccessModes = parseAccessModes(class, "allowedAccessModes")
+
+	return policy
+}
+
+/*parseAccessModes returns the access modes listed by the optional parameter of the storage class. The app exits if any of
+them is unknown*/
+func parseAccessModes(class *storage_v1.StorageClass, paramName string) []core_v1.PersistentVolumeAccessMode {
+	var result []core_v1.PersistentVolumeAccessMode
+	for _, item := range splitList(getOptionalStorageClassParameter(class, paramName, "")) {
+		mode := core_v1.PersistentVolumeAccessMode(item)
+		switch mode {
+		case core_v1.ReadWriteOnce, core_v1.ReadOnlyMany, core_v1.ReadWriteMany, AccessModeReadWriteOncePod:
+			result = append(result, mode)
+		default:
+			logging.New(logging.Fields{logging.KeyStorageClass: class.Name}).Fatalf("Unknown access mode in the parameter '%s': %v", paramName, item)
+		}
+	}
+	return result
+}
+
+/*defaultAccessModes returns the access modes supported by the storage class which does not declare them. The directories
+might be shared between nodes, the hostPath ones as well if the assetRoot is the shared file system mounted on every node. The
+image is mounted by a single node only, its file system would be corrupted otherwise*/
+func defaultAccessModes(assetType string) []core_v1.PersistentVolumeAccessMode {
+	if assetType != AssetTypeImage {
+		return []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteOnce, core_v1.ReadOnlyMany, core_v1.ReadWriteMany, AccessModeReadWriteOncePod}
+	}
+	return []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteOnce, AccessModeReadWriteOncePod}
+}
+
+/*splitList returns the items of comma separated list skipping empty ones*/
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
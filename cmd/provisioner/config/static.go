@@ -1,5 +1,9 @@
 package config
 
+import (
+	core_v1 "k8s.io/api/core/v1"
+)
+
 const (
 	/*AnnotationProvisionedBy is annotation used in provisioned PV*/
 	AnnotationProvisionedBy = "pv.kubernetes.io/provisioned-by"
@@ -22,4 +26,67 @@ const (
 	AnnotationOwnerNewAssetUID1 = "storage-asset.pv.provisioner/owner-uid"
 	/*AnnotationOwnerNewAssetGID1 is the annotation, value of which is able to override the parameter.defaultOwnerAssetGid value of storage class*/
 	AnnotationOwnerNewAssetGID1 = "storage-asset.pv.provisioner/owner-gid"
+
+	/*AnnotationFailure is the annotation which is set to the PVC or PV when the provisioner gives up handling it. The value is
+	the last error. The removal of the annotation makes the provisioner to try again*/
+	AnnotationFailure = "volume.pv.provisioner/failure"
+
+	/*AnnotationStorageBudgetPrefix is the prefix of the annotation of a namespace. The annotation with the name of a storage class
+	appended limits total storage size of PVs of the class provisioned for the namespace*/
+	AnnotationStorageBudgetPrefix = "storage-budget.pv.provisioner/"
+
+	/*IndexByClaimNamespace is the name of the index of PVs by the namespace of the claims they are bound to*/
+	IndexByClaimNamespace = "byClaimNamespace"
+
+	/*AccessModeReadWriteOncePod is the access mode of the volume which might be mounted by a single pod only. It is not defined by
+	the API package the provisioner is built with*/
+	AccessModeReadWriteOncePod core_v1.PersistentVolumeAccessMode = "ReadWriteOncePod"
+
+	/*AnnotationInstanceID is the annotation of provisioned PV which value is the identity of the provisioner instance created it*/
+	AnnotationInstanceID = "volume.pv.provisioner/instance-id"
+
+	/*AnnotationAssetPath is the annotation of provisioned PV which value is the path to its storage asset relative to the
+	storage asset root*/
+	AnnotationAssetPath = "storage-asset.pv.provisioner/path"
+
+	/*AnnotationExport is the annotation of provisioned PV which value is the name of the export of its storage asset created by
+	the agent*/
+	AnnotationExport = "storage-asset.pv.provisioner/export"
+
+	/*AnnotationUsedBytes is the annotation of provisioned PV which value is the disk usage of its storage asset in bytes measured
+	by the last scan*/
+	AnnotationUsedBytes = "storage-asset.pv.provisioner/used-bytes"
+	/*AnnotationLastScanned is the annotation of provisioned PV which value is the time of the last scan of its storage asset in
+	RFC 3339 format*/
+	AnnotationLastScanned = "storage-asset.pv.provisioner/last-scanned"
+
+	/*AnnotationMigratedFrom is the annotation of PV which value is the volume type the PV had before it was rewritten to csi
+	volume source*/
+	AnnotationMigratedFrom = "volume.pv.provisioner/migrated-from"
+
+	/*AssetTypeDirectory is the type of storage asset which is a directory shared by the volume*/
+	AssetTypeDirectory = "directory"
+	/*AssetTypeImage is the type of storage asset which is a sparse file containing a file system*/
+	AssetTypeImage = "image"
+	/*ImageFilesystemNone is the value of imageFilesystem parameter which leaves the image file unformatted*/
+	ImageFilesystemNone = "none"
+
+	/*VolumeTypeHostPath is the type of the PVs having hostPath volume source*/
+	VolumeTypeHostPath = "hostPath"
+	/*VolumeTypeNFS is the type of the PVs having nfs volume source*/
+	VolumeTypeNFS = "nfs"
+	/*VolumeTypeSMB is the type of the PVs having csi volume source of the SMB CSI driver*/
+	VolumeTypeSMB = "smb"
+	/*VolumeTypeCSI is the type of the PVs having csi volume source of the driver specified by the storage class*/
+	VolumeTypeCSI = "csi"
+
+	/*EphemeralReclaimDelete is the value of ephemeralReclaim parameter making the PVs of the claims owned by pods to be deleted
+	regardless of the reclaim policy of the storage class*/
+	EphemeralReclaimDelete = "Delete"
+	/*EphemeralReclaimInherit is the value of ephemeralReclaim parameter keeping the reclaim policy of the storage class for the
+	claims owned by pods*/
+	EphemeralReclaimInherit = "Inherit"
+
+	/*AnnotationAssetTemplate is the annotation, value of which is able to override the parameter.assetTemplate value of storage class*/
+	AnnotationAssetTemplate = "storage-asset.pv.provisioner/template"
 )
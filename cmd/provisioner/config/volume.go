@@ -0,0 +1,124 @@
+package config
+
+import (
+	"net"
+	"strings"
+
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+)
+
+//The parameters of storage class referring the secret passed to the CSI driver on staging of the volume on the node
+const (
+	paramNodeStageSecretName      = "csi.storage.k8s.io/node-stage-secret-name"
+	paramNodeStageSecretNamespace = "csi.storage.k8s.io/node-stage-secret-namespace"
+)
+
+//defaultSMBDriver is the name of the SMB CSI driver used unless the storage class has csiDriver parameter
+const defaultSMBDriver = "smb.csi.k8s.io"
+
+/*VolumeSource is the set of the parameters of storage class describing the volume source of the provisioned PVs*/
+type VolumeSource struct {
+	//Type is one of VolumeTypeHostPath, VolumeTypeNFS, VolumeTypeSMB and VolumeTypeCSI
+	Type string
+	//CSIDriver is the name of the CSI driver of the volumes of smb and csi types, nfs volumes use it instead of in-tree NFS if set
+	CSIDriver string
+	//CSIVolumeAttributes are additional volume attributes passed to the CSI driver
+	CSIVolumeAttributes map[string]string
+	//NodeStageSecretRef is the secret passed to the CSI driver on staging of the volume (optional)
+	NodeStageSecretRef *core_v1.SecretReference
+	//MountOptions are the mount options of the storage class set to the PVs of network volume types
+	MountOptions []string
+}
+
+/*defaultVolumeType returns the volume type which the storage classes without the volumeType parameter have: nfs for
+<server>:<path> assetRoot and hostPath otherwise*/
+func defaultVolumeType(assetRoot string) string {
+	if strings.Contains(assetRoot, ":") {
+		return VolumeTypeNFS
+	}
+	return VolumeTypeHostPath
+}
+
+/*SplitNFSAssetRoot returns the server and the path of <server>:<path> form. The path follows the last colon, so the server might
+be IPv6 address, either bare or in brackets, which are stripped. The result is not ok if either part is missing or the server having
+colons is not IP address*/
+func SplitNFSAssetRoot(assetRoot string) (string, string, bool) {
+	index := strings.LastIndex(assetRoot, ":")
+	if index < 0 {
+		return "", "", false
+	}
+	server, path := assetRoot[:index], assetRoot[index+1:]
+	if strings.HasPrefix(server, "[") && strings.HasSuffix(server, "]") {
+		server = server[1 : len(server)-1]
+		if net.ParseIP(server) == nil {
+			return "", "", false
+		}
+	}
+	if strings.Contains(server, ":") && net.ParseIP(server) == nil {
+		return "", "", false
+	}
+	return server, path, server != "" && path != ""
+}
+
+/*parseVolumeSource returns the volume source parameters of the storage class. The app exits if the volume type is unknown or
+the assetRoot does not have the form of the volume type*/
+func parseVolumeSource(class *storage_v1.StorageClass, assetRoot string) VolumeSource {
+	log := logging.New(logging.Fields{logging.KeyStorageClass: class.Name})
+
+	source := VolumeSource{
+		Type:                getOptionalStorageClassParameter(class, "volumeType", defaultVolumeType(assetRoot)),
+		CSIDriver:           getOptionalStorageClassParameter(class, "csiDriver", ""),
+		CSIVolumeAttributes: parseAttributes(log, getOptionalStorageClassParameter(class, "csiVolumeAttributes", "")),
+	}
+
+	switch source.Type {
+	case VolumeTypeHostPath:
+		if strings.Contains(assetRoot, ":") {
+			log.Fatalf("The parameter 'assetRoot' must be the path on the nodes if the parameter 'volumeType' is %v: %v", source.Type, assetRoot)
+		}
+		return source
+	case VolumeTypeNFS:
+		if _, _, ok := SplitNFSAssetRoot(assetRoot); !ok {
+			log.Fatalf("The parameter 'assetRoot' must have <server>:<path> form if the parameter 'volumeType' is %v: %v", source.Type, assetRoot)
+		}
+	case VolumeTypeSMB:
+		if !strings.HasPrefix(assetRoot, "//") || len(strings.Split(strings.TrimPrefix(assetRoot, "//"), "/")) < 2 {
+			log.Fatalf("The parameter 'assetRoot' must have //<server>/<share> form if the parameter 'volumeType' is %v: %v", source.Type, assetRoot)
+		}
+		if source.CSIDriver == "" {
+			source.CSIDriver = defaultSMBDriver
+		}
+	case VolumeTypeCSI:
+		if source.CSIDriver == "" {
+			log.Fatalf("The parameter 'csiDriver' is required if the parameter 'volumeType' is %v", source.Type)
+		}
+	default:
+		log.Fatalf("Unknown value of the parameter 'volumeType': %v", source.Type)
+	}
+
+	if name := getOptionalStorageClassParameter(class, paramNodeStageSecretName, ""); name != "" {
+		source.NodeStageSecretRef = &core_v1.SecretReference{
+			Name:      name,
+			Namespace: getOptionalStorageClassParameter(class, paramNodeStageSecretNamespace, "default"),
+		}
+	}
+	//The mount options are not allowed for hostPath PVs by API server
+	source.MountOptions = class.MountOptions
+	return source
+}
+
+/*parseAttributes returns the map of comma separated list of key=value pairs. The app exits if the item does not have the value*/
+func parseAttributes(log *logging.Logger, value string) map[string]string {
+	result := make(map[string]string)
+	for _, item := range splitList(value) {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			log.Fatalf("The item of the parameter 'csiVolumeAttributes' must have key=value form: %v", item)
+		}
+		result[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return result
+}
@@ -2,54 +2,157 @@ package controllers
 
 import (
 	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
 )
 
 /*Controller is the scaffold for controller*/
 type Controller struct {
-	name        string
-	indexer     cache.Indexer
-	queue       workqueue.RateLimitingInterface
-	informer    cache.Controller
-	ItemHandler func(indexer cache.Indexer, key string) error
+	name     string
+	indexer  cache.Indexer
+	queue    workqueue.RateLimitingInterface
+	//waitLimiter delays the items waiting for a change of the cluster, it does not touch the retry budget kept by the queue
+	waitLimiter workqueue.RateLimiter
+	informer cache.Controller
+	log      *logging.Logger
+	state    int32
+	//inFlight tracks the ItemHandler calls which are in progress, stopping forbids new ones
+	inFlight sync.WaitGroup
+	stopping bool
+	locker   sync.Mutex
+	//ItemHandler is called with the logger having the controller name and id of the current operation
+	ItemHandler func(log *logging.Logger, indexer cache.Indexer, key string) error
+	//ReconcileFilter selects the objects which are put to the queue right after the cache is synced (optional)
+	ReconcileFilter func(obj interface{}) bool
+	//MaxRetries is how many times the item failed by transient error is retried before giving up, 0 means retrying forever
+	MaxRetries int
+	//GiveUpHandler is called with the last error when the controller gives up handling the item (optional)
+	GiveUpHandler func(log *logging.Logger, indexer cache.Indexer, key string, err error)
 }
 
+//postponedRetryDelay is the delay of handling the item again when its handling has been postponed
+const postponedRetryDelay = 5 * time.Second
+
+//maxWaitingDelay is the maximal delay of handling the item waiting for a change again, the change itself enqueues it earlier
+const maxWaitingDelay = 5 * time.Minute
+
+const (
+	stateIdle int32 = iota
+	stateStarting
+	stateRunning
+	stateStopped
+)
+
 /*NewController is the func which is like a constructor*/
 func NewController(name string, queue workqueue.RateLimitingInterface, indexer cache.Indexer, informer cache.Controller) *Controller {
 	return &Controller{
-		name:     name,
-		informer: informer,
-		indexer:  indexer,
-		queue:    queue,
+		name:        name,
+		informer:    informer,
+		indexer:     indexer,
+		queue:       queue,
+		waitLimiter: workqueue.NewItemExponentialFailureRateLimiter(postponedRetryDelay, maxWaitingDelay),
+		log:         logging.New(logging.Fields{logging.KeyController: name}),
 	}
 }
 
-/*Run is the starter or a controller*/
-func (c *Controller) Run(stopCh chan struct{}) {
+/*Run is the starter or a controller which launches the number of workers handling items simultaneously. Once the stopCh is
+closed the controller stops taking new items from the queue and the method returns when the ItemHandler calls in progress are finished*/
+func (c *Controller) Run(workers int, stopCh chan struct{}) {
 	defer runtime.HandleCrash()
 
 	// Let the workers stop when we are done
 	defer c.queue.ShutDown()
-	klog.V(0).Infof("Starting controller: %v", c.name)
+	defer atomic.StoreInt32(&c.state, stateStopped)
+	c.log.Infof("Starting controller: %v with %v workers", c.name, workers)
+	atomic.StoreInt32(&c.state, stateStarting)
 
 	go c.informer.Run(stopCh)
 
 	// Wait for all involved caches to be synced, before processing items from the queue is started
 	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced) {
-		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+		c.log.Errorf("Timed out waiting for caches to sync")
 		return
 	}
 
-	go wait.Until(c.runWorker, time.Second, stopCh)
+	c.reconcile()
+
+	for index := 0; index < workers; index++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	atomic.StoreInt32(&c.state, stateRunning)
 
 	<-stopCh
-	klog.V(0).Infof("Stopping controller: %v", c.name)
+	c.log.Infof("Stopping controller: %v", c.name)
+
+	c.locker.Lock()
+	c.stopping = true
+	c.locker.Unlock()
+
+	// Wake up the workers waiting for new items and wait for the ones handling items at the moment
+	c.queue.ShutDown()
+	c.inFlight.Wait()
+	c.log.Infof("Controller stopped: %v", c.name)
+}
+
+/*reconcile puts to the queue all objects from the cache selected by ReconcileFilter. It lets to handle the objects whose
+changes have been missed while the provisioner was not running*/
+func (c *Controller) reconcile() {
+	if c.ReconcileFilter == nil {
+		return
+	}
+
+	count := 0
+	for _, obj := range c.indexer.List() {
+		if !c.ReconcileFilter(obj) {
+			continue
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			c.queue.Add(key)
+			count++
+		}
+	}
+	c.log.Infof("Reconciliation: %v objects are put to the queue", count)
+}
+
+/*startOperation returns false if the controller is stopping, otherwise the operation is tracked as in progress*/
+func (c *Controller) startOperation() bool {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.stopping {
+		return false
+	}
+	c.inFlight.Add(1)
+	return true
+}
+
+/*CheckAlive is the method returning error if the controller has been started and stopped after that*/
+func (c *Controller) CheckAlive() error {
+	if atomic.LoadInt32(&c.state) == stateStopped {
+		return fmt.Errorf("Controller: %v is stopped", c.name)
+	}
+	return nil
+}
+
+/*CheckSynced is the method returning error if the cache of the controller has not been synced and the workers are not running yet*/
+func (c *Controller) CheckSynced() error {
+	if atomic.LoadInt32(&c.state) != stateRunning {
+		return fmt.Errorf("Controller: %v has not synced its cache yet", c.name)
+	}
+	return nil
+}
+
+/*Enqueue is the method putting the key to the queue of the controller in order to be processed*/
+func (c *Controller) Enqueue(key string) {
+	c.queue.Add(key)
 }
 
 func (c *Controller) runWorker() {
@@ -68,21 +171,51 @@ func (c *Controller) processNextItem() bool {
 	// parallel.
 	defer c.queue.Done(key)
 
+	// The items left in the queue are not handled once the controller is stopping
+	if !c.startOperation() {
+		return false
+	}
+	defer c.inFlight.Done()
+
+	log := c.log.With(logging.Fields{logging.KeyOperationID: logging.NewOperationID()})
+
 	// Invoke the method containing the business logic
-	err := c.ItemHandler(c.indexer, key.(string))
+	err := c.ItemHandler(log, c.indexer, key.(string))
 	// Handle the error if something went wrong during the execution of the business logic
-	c.handleErr(err, key)
+	c.handleErr(log, err, key)
 	return true
 }
 
 // handleErr checks if an error happened and makes sure we will retry later.
-func (c *Controller) handleErr(err error, key interface{}) {
+func (c *Controller) handleErr(log *logging.Logger, err error, key interface{}) {
+	// The waiting items are handled again with growing delays until they stop waiting
+	if failures.IsWaiting(err) {
+		c.queue.AddAfter(key, c.waitLimiter.When(key))
+		return
+	}
+	c.waitLimiter.Forget(key)
+
 	if err == nil {
 		c.queue.Forget(key)
 		return
 	}
 
-	klog.V(0).Infof("Controller: %s - error processing %v: %v", c.name, key, err)
+	// The postponed items do not spend the retry budget
+	if failures.IsPostponed(err) {
+		c.queue.AddAfter(key, postponedRetryDelay)
+		return
+	}
+
+	log.Infof("Error processing %v: %v", key, err)
+
+	if failures.IsPermanent(err) || (c.MaxRetries > 0 && c.queue.NumRequeues(key) >= c.MaxRetries) {
+		log.Warningf("Giving up processing %v after %v retries: %v", key, c.queue.NumRequeues(key), err)
+		c.queue.Forget(key)
+		if c.GiveUpHandler != nil {
+			c.GiveUpHandler(log, c.indexer, key.(string), err)
+		}
+		return
+	}
 
 	// Re-enqueue the key rate limited. Based on the rate limiter on the
 	// queue and the re-enqueue history, the key will be processed later again.
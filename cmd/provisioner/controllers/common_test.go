@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"errors"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestReconcile(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, item := range []struct {
+		name  string
+		phase core_v1.PersistentVolumePhase
+	}{{"pv1", core_v1.VolumeBound}, {"pv2", core_v1.VolumeReleased}} {
+		pv := &core_v1.PersistentVolume{ObjectMeta: meta_v1.ObjectMeta{Name: item.name}}
+		pv.Status.Phase = item.phase
+		indexer.Add(pv)
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+
+	ctrl := NewController("PersistentVolume", queue, indexer, nil)
+	ctrl.reconcile()
+	if queue.Len() != 0 {
+		t.Fatal("Nothing must be put to the queue without the filter")
+	}
+
+	ctrl.ReconcileFilter = func(obj interface{}) bool {
+		return obj.(*core_v1.PersistentVolume).Status.Phase == core_v1.VolumeReleased
+	}
+	ctrl.reconcile()
+	if queue.Len() != 1 {
+		t.Fatalf("Only released PV must be put to the queue, but queue length is: %v", queue.Len())
+	}
+	if key, _ := queue.Get(); key != "pv2" {
+		t.Errorf("Unexpected key in the queue: %v", key)
+	}
+}
+
+func TestHandleErr(t *testing.T) {
+	queue := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+	defer queue.ShutDown()
+
+	var givenUp []string
+	ctrl := NewController("PersistentVolumeClaim", queue, cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}), nil)
+	ctrl.MaxRetries = 2
+	ctrl.GiveUpHandler = func(log *logging.Logger, indexer cache.Indexer, key string, err error) {
+		givenUp = append(givenUp, key)
+	}
+
+	transientErr := errors.New("transient error")
+	ctrl.handleErr(nil, transientErr, "ns1/pvc1")
+	ctrl.handleErr(nil, transientErr, "ns1/pvc1")
+	if len(givenUp) != 0 || queue.NumRequeues("ns1/pvc1") != 2 {
+		t.Fatalf("Item must be retried within the budget, given up: %v, retries: %v", givenUp, queue.NumRequeues("ns1/pvc1"))
+	}
+
+	ctrl.handleErr(nil, transientErr, "ns1/pvc1")
+	if len(givenUp) != 1 || queue.NumRequeues("ns1/pvc1") != 0 {
+		t.Fatalf("Item must be given up after the budget is spent, given up: %v, retries: %v", givenUp, queue.NumRequeues("ns1/pvc1"))
+	}
+
+	ctrl.handleErr(nil, failures.Postponed(transientErr), "ns1/pvc2")
+	if len(givenUp) != 1 || queue.NumRequeues("ns1/pvc2") != 0 {
+		t.Fatalf("Postponed item must not spend the budget, given up: %v, retries: %v", givenUp, queue.NumRequeues("ns1/pvc2"))
+	}
+
+	ctrl.handleErr(nil, failures.Waiting(transientErr), "ns1/pvc4")
+	ctrl.handleErr(nil, failures.Waiting(transientErr), "ns1/pvc4")
+	if len(givenUp) != 1 || queue.NumRequeues("ns1/pvc4") != 0 || ctrl.waitLimiter.NumRequeues("ns1/pvc4") != 2 {
+		t.Fatalf("Waiting item must be delayed more and more without spending the budget, given up: %v, retries: %v", givenUp, queue.NumRequeues("ns1/pvc4"))
+	}
+	ctrl.handleErr(nil, nil, "ns1/pvc4")
+	if ctrl.waitLimiter.NumRequeues("ns1/pvc4") != 0 {
+		t.Fatal("Delay of the item must be reset once it does not wait")
+	}
+
+	ctrl.handleErr(nil, failures.Permanent(transientErr), "ns1/pvc3")
+	if len(givenUp) != 2 || givenUp[1] != "ns1/pvc3" {
+		t.Fatalf("Item failed permanently must be given up at once, given up: %v", givenUp)
+	}
+}
+
+func TestWaiters(t *testing.T) {
+	waiters := NewWaiters()
+	if !waiters.Wait("ns1/pvc1", "withinBudget", "namespace/ns1") {
+		t.Error("New waiting item must be reported")
+	}
+	if waiters.Wait("ns1/pvc1", "withinBudget", "namespace/ns1") {
+		t.Error("Item waiting for the same reason must not be reported again")
+	}
+	waiters.Wait("ns2/pvc2", "withinBudget", "namespace/ns2", "class/class1")
+
+	if keys := waiters.Wake("namespace/ns1", "class/class1"); len(keys) != 2 || keys[0] != "ns1/pvc1" || keys[1] != "ns2/pvc2" {
+		t.Errorf("Unexpected woken items: %v", keys)
+	}
+	if keys := waiters.Wake("namespace/ns1"); len(keys) != 1 {
+		t.Errorf("Woken item must keep waiting until it is handled: %v", keys)
+	}
+
+	if !waiters.Wait("ns1/pvc1", "fitsCapacity", "class/class1") {
+		t.Error("Item waiting for another reason must be reported")
+	}
+	if keys := waiters.Wake("namespace/ns1"); len(keys) != 0 {
+		t.Errorf("Item must not wait for the previous subjects: %v", keys)
+	}
+
+	waiters.Done("ns1/pvc1")
+	if keys := waiters.Wake("class/class1"); len(keys) != 1 || keys[0] != "ns2/pvc2" {
+		t.Errorf("Done item must not be woken: %v", keys)
+	}
+	if !waiters.Wait("ns1/pvc1", "fitsCapacity", "class/class1") {
+		t.Error("Item waiting again after it was done must be reported")
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+)
+
+/*RateLimiterOptions is the set of parameters of the rate limiter used by the queue of a controller for retries*/
+type RateLimiterOptions struct {
+	//BaseDelay is the delay of the first retry of an item, each next retry of the item is delayed twice longer
+	BaseDelay time.Duration
+	//MaxDelay is the maximal delay of retry of an item
+	MaxDelay time.Duration
+	//QPS is overall number of retries per second for all items
+	QPS float64
+	//Burst is overall number of retries which might be done at once for all items
+	Burst int
+}
+
+/*NewRateLimiter is the func returning rate limiter which is the same as workqueue.DefaultControllerRateLimiter but with the
+parameters specified by the options*/
+func NewRateLimiter(options RateLimiterOptions) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(options.BaseDelay, options.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(options.QPS), options.Burst)},
+	)
+}
+
+/*storageClassSlots counts the operations in progress per storage class*/
+var storageClassSlots = struct {
+	sync.Mutex
+	inProgress map[string]int
+}{inProgress: make(map[string]int)}
+
+/*AcquireStorageClassSlot is the func which registers a new operation for the storage class if number of operations in
+progress for the class is less than the limit. The returned func must be called once the operation is finished. The limit
+less than 1 means unlimited number of operations. The error is returned if the limit is reached*/
+func AcquireStorageClassSlot(storageClassName string, limit int) (func(), error) {
+	storageClassSlots.Lock()
+	defer storageClassSlots.Unlock()
+
+	if limit > 0 && storageClassSlots.inProgress[storageClassName] >= limit {
+		return nil, fmt.Errorf("Storage class: %v already has maximal number of operations in progress: %v", storageClassName, limit)
+	}
+	storageClassSlots.inProgress[storageClassName]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			storageClassSlots.Lock()
+			defer storageClassSlots.Unlock()
+			storageClassSlots.inProgress[storageClassName]--
+		})
+	}, nil
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"testing"
+)
+
+func TestAcquireStorageClassSlot(t *testing.T) {
+	release1, err := AcquireStorageClassSlot("storageClass1", 2)
+	if err != nil {
+		t.Fatalf("The first slot must be acquired: %v", err)
+	}
+	release2, err := AcquireStorageClassSlot("storageClass1", 2)
+	if err != nil {
+		t.Fatalf("The second slot must be acquired: %v", err)
+	}
+	if _, err := AcquireStorageClassSlot("storageClass1", 2); err == nil {
+		t.Fatal("The third slot must not be acquired")
+	}
+	if _, err := AcquireStorageClassSlot("storageClass2", 2); err != nil {
+		t.Fatalf("The slot of another storage class must be acquired: %v", err)
+	}
+
+	release1()
+	release1()
+	release3, err := AcquireStorageClassSlot("storageClass1", 2)
+	if err != nil {
+		t.Fatalf("The slot must be acquired after release: %v", err)
+	}
+	if _, err := AcquireStorageClassSlot("storageClass1", 2); err == nil {
+		t.Fatal("Repeated release must not free more than one slot")
+	}
+	release2()
+	release3()
+
+	for index := 0; index < 10; index++ {
+		if _, err := AcquireStorageClassSlot("storageClass3", 0); err != nil {
+			t.Fatalf("Unlimited storage class must not refuse slots: %v", err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package controllers
 
 import (
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"time"
+
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -8,7 +12,6 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
 )
 
 var (
@@ -16,48 +19,113 @@ var (
 		"persistentvolumeclaims": &core_v1.PersistentVolumeClaim{},
 		"persistentvolumes":      &core_v1.PersistentVolume{},
 	}
+	resourceKind = map[string]string{
+		"persistentvolumeclaims": "PersistentVolumeClaim",
+		"persistentvolumes":      "PersistentVolume",
+	}
+	resourceIndexers = map[string]cache.Indexers{
+		"persistentvolumeclaims": {},
+		"persistentvolumes":      {config.IndexByClaimNamespace: indexByClaimNamespace},
+	}
 )
 
-//PrepareStuff is the function that returns all stuff that is needed to launch controller
-func PrepareStuff(clientset *kubernetes.Clientset, resource string) (workqueue.RateLimitingInterface, cache.Indexer, cache.Controller) {
+/*PersistentVolumeChanged is called with the PV which has been changed or deleted. It allows to wake up the PVCs waiting for the
+storage used by the PVs to be freed (optional)*/
+var PersistentVolumeChanged func(pv *core_v1.PersistentVolume)
+
+/*NamespaceChanged is called with the name of the namespace which has been changed (optional)*/
+var NamespaceChanged func(name string)
+
+/*indexByClaimNamespace is the index func returning the namespace of the claim which the PV is bound to*/
+func indexByClaimNamespace(obj interface{}) ([]string, error) {
+	pv, ok := obj.(*core_v1.PersistentVolume)
+	if !ok || pv.Spec.ClaimRef == nil {
+		return []string{}, nil
+	}
+	return []string{pv.Spec.ClaimRef.Namespace}, nil
+}
+
+/*objectLogger returns the logger having the keys of the PVC or the PV*/
+func objectLogger(log *logging.Logger, obj interface{}) *logging.Logger {
+	switch object := obj.(type) {
+	case *core_v1.PersistentVolumeClaim:
+		return log.WithPVC(object)
+	case *core_v1.PersistentVolume:
+		return log.WithPV(object)
+	default:
+		return log
+	}
+}
+
+//PrepareStuff is the function that returns all stuff that is needed to launch controller. Every resyncPeriod all objects
+//from the cache are passed to UpdateFunc of the event handler again, 0 disables it
+func PrepareStuff(clientset *kubernetes.Clientset, resource string, rateLimiter workqueue.RateLimiter, resyncPeriod time.Duration) (workqueue.RateLimitingInterface, cache.Indexer, cache.Controller) {
 	listWatcher := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), resource, meta_v1.NamespaceAll, fields.Everything())
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	queue := workqueue.NewRateLimitingQueue(rateLimiter)
+	log := logging.New(logging.Fields{logging.KeyController: resourceKind[resource]})
 
 	var eventHandler cache.ResourceEventHandlerFuncs
 	switch resource {
 	case "persistentvolumeclaims":
 		eventHandler = cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				klog.V(3).Infof("Added object: %v", obj)
+				objectLogger(log, obj).V(logging.LevelDebug).Infof("Added object: %v", obj)
 				key, err := cache.MetaNamespaceKeyFunc(obj)
 				if err == nil {
 					queue.Add(key)
-					klog.V(2).Infof("The new persistentVolumeClaim was added: %v", key)
+					objectLogger(log, obj).V(logging.LevelDecision).Infof("The new persistentVolumeClaim was added: %v", key)
 				}
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				klog.V(3).Infof("Changed object: %v", newObj)
+				objectLogger(log, newObj).V(logging.LevelDebug).Infof("Changed object: %v", newObj)
 				key, err := cache.MetaNamespaceKeyFunc(newObj)
 				if err == nil {
 					queue.Add(key)
-					klog.V(2).Infof("The persistentVolumeClaim was changed: %v", key)
+					objectLogger(log, newObj).V(logging.LevelDecision).Infof("The persistentVolumeClaim was changed: %v", key)
 				}
 			},
 		}
 	case "persistentvolumes":
 		eventHandler = cache.ResourceEventHandlerFuncs{
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				klog.V(3).Infof("Changed object: %v", newObj)
+				objectLogger(log, newObj).V(logging.LevelDebug).Infof("Changed object: %v", newObj)
 				key, err := cache.MetaNamespaceKeyFunc(newObj)
 				if err == nil {
 					queue.Add(key)
-					klog.V(2).Infof("The persistentVolume was changed: %v", key)
+					objectLogger(log, newObj).V(logging.LevelDecision).Infof("The persistentVolume was changed: %v", key)
 				}
+				notifyPersistentVolumeChanged(newObj)
+			},
+			//The deleted PVs are not handled, but their storage is free again
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				notifyPersistentVolumeChanged(obj)
 			},
 		}
 	}
 
-	indexer, informer := cache.NewIndexerInformer(listWatcher, resourceType[resource], 0, eventHandler, cache.Indexers{})
+	indexer, informer := cache.NewIndexerInformer(listWatcher, resourceType[resource], resyncPeriod, eventHandler, resourceIndexers[resource])
 
 	return queue, indexer, informer
 }
+
+func notifyPersistentVolumeChanged(obj interface{}) {
+	if pv, ok := obj.(*core_v1.PersistentVolume); ok && PersistentVolumeChanged != nil {
+		PersistentVolumeChanged(pv)
+	}
+}
+
+/*WatchNamespaces is the func returning the cache of namespaces and its informer. The checks of PVCs read the namespaces from the
+cache instead of fetching them for every PVC. The changed namespaces are passed to NamespaceChanged*/
+func WatchNamespaces(clientset *kubernetes.Clientset, resyncPeriod time.Duration) (cache.Indexer, cache.Controller) {
+	listWatcher := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "namespaces", meta_v1.NamespaceAll, fields.Everything())
+	return cache.NewIndexerInformer(listWatcher, &core_v1.Namespace{}, resyncPeriod, cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if namespace, ok := newObj.(*core_v1.Namespace); ok && NamespaceChanged != nil {
+				NamespaceChanged(namespace.Name)
+			}
+		},
+	}, cache.Indexers{})
+}
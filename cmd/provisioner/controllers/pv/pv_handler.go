@@ -4,48 +4,106 @@ import (
 	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/checker"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/controllers"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
 	"k8s-pv-provisioner/cmd/provisioner/storage"
-	"path"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/klog"
 )
 
 var appConfig = config.GetInstance()
 
+/*NeedsReconciliation is the func returning true for the PV which is released and therefore may need removal, or which might
+be taken over from another instance*/
+func NeedsReconciliation(obj interface{}) bool {
+	pv, ok := obj.(*v1.PersistentVolume)
+	return ok && (pv.Status.Phase == v1.VolumeReleased || appConfig.Adopts(pv.Annotations[config.AnnotationInstanceID]))
+}
+
+/*adopt is the func stamping the PV provisioned by the instance which is taken over with the identity of the current instance,
+so the former instance does not handle it anymore*/
+func adopt(log *logging.Logger, pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	storageClass, ok := appConfig.StorageClasses[pv.Spec.StorageClassName]
+	formerInstanceID := pv.Annotations[config.AnnotationInstanceID]
+	if !ok || pv.Annotations[config.AnnotationProvisionedBy] != storageClass.Provisioner || !appConfig.Adopts(formerInstanceID) {
+		return pv, nil
+	}
+
+	adopted := pv.DeepCopy()
+	if appConfig.InstanceID == "" {
+		delete(adopted.Annotations, config.AnnotationInstanceID)
+	} else {
+		adopted.Annotations[config.AnnotationInstanceID] = appConfig.InstanceID
+	}
+
+	result, err := appConfig.Clientset.CoreV1().PersistentVolumes().Update(adopted)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.Event(result, v1.EventTypeNormal, "Adopted", "PersistentVolume has been taken over from instance %q", formerInstanceID)
+	log.Infof("PersistentVolume: %v has been taken over from instance: %q", pv.Name, formerInstanceID)
+	return result, nil
+}
+
 /*Handler is the business logic method of the Controller for removal of PersistentVolumes.
 Once the handler returns an error the current key will be put to the queue to be processed later. If the method returns nil the key
 will be withdrawn from the queue because there is no need to do anything with it */
-func Handler(indexer cache.Indexer, key string) error {
+func Handler(log *logging.Logger, indexer cache.Indexer, key string) error {
 	obj, exists, err := indexer.GetByKey(key)
 
 	if err != nil {
-		klog.Errorf("Could not fetch key: %v", key)
+		log.Errorf("Could not fetch key: %v", key)
 		return err
 	}
 
 	if !exists {
-		klog.Warningf("PersistentVolume does not exists anymore: %v", key)
+		log.Warningf("PersistentVolume does not exists anymore: %v", key)
 		return nil
 	}
 
 	pv := obj.(*v1.PersistentVolume)
+	log = log.WithPV(pv)
+
+	if lastError, ok := pv.Annotations[config.AnnotationFailure]; ok {
+		log.V(logging.LevelDecision).Infof("PersistentVolume: %v is skipped because removal has been given up: %v", pv.Name, lastError)
+		return nil
+	}
+
+	if pv, err = adopt(log, pv); err != nil {
+		return err
+	}
+
 	checkList := checker.NewPvChecker(pv)
-	checkList.PerformChecks()
+	checkList.SetLogger(log)
+	report := checkList.PerformChecks()
 
-	if !checkList.IsAllOK() {
-		if checkList.IsReleased() && checkList.HasProperClassName() && checkList.HasProperReclaimPolicy() {
-			return fmt.Errorf("Not all checks of persistentVolume have been passed for removal: %v", pv.Name)
-		}
+	switch report.Verdict {
+	case checker.RetryLater:
+		return fmt.Errorf("Not all checks of persistentVolume have been passed for removal: %v: %v", pv.Name, report.Failed().Reason)
+	case checker.Skip:
 		//It's not our candidate at all. Forget about it
 		return nil
 	}
 
-	storageAssetPath := path.Join(appConfig.StorageAssetRoot, pv.Spec.StorageClassName, pv.Name)
-	if err := storage.DeleteStorageAsset(storageAssetPath); err != nil {
-		klog.Errorf("PersistentVolume: %v deleting storage asset failed: %v", pv.Name, err)
+	release, err := controllers.AcquireStorageClassSlot(pv.Spec.StorageClassName, appConfig.StorageClasses[pv.Spec.StorageClassName].MaxConcurrentOperations)
+	if err != nil {
+		log.V(logging.LevelDecision).Infof("PersistentVolume: %v removal is postponed: %v", pv.Name, err)
+		return failures.Postponed(err)
+	}
+	defer release()
+
+	storageAssetPath := storage.AssetPathOf(pv)
+	//The export is removed first, so the clients could not reach the storage asset being deleted
+	if err := storage.UnexportStorageAsset(log, pv); err != nil {
+		log.Errorf("PersistentVolume: %v removing export of storage asset failed: %v", pv.Name, err)
+		return err
+	}
+	if err := storage.DeleteStorageAsset(log, storageAssetPath); err != nil {
+		log.Errorf("PersistentVolume: %v deleting storage asset failed: %v", pv.Name, err)
 		return err
 	}
 
@@ -53,7 +111,27 @@ func Handler(indexer cache.Indexer, key string) error {
 		return err
 	}
 
-	klog.V(1).Infof("PersistentVolume successfully deleted: %v", pv.Name)
+	log.Infof("PersistentVolume successfully deleted: %v", pv.Name)
 
 	return nil
 }
+
+/*GiveUpHandler is the func marking the PersistentVolume which removal has been given up with the annotation and the event.
+The removal is tried again once the annotation is removed*/
+func GiveUpHandler(log *logging.Logger, indexer cache.Indexer, key string, lastErr error) {
+	obj, exists, err := indexer.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+
+	pv := obj.(*v1.PersistentVolume).DeepCopy()
+	if pv.Annotations == nil {
+		pv.Annotations = make(map[string]string)
+	}
+	pv.Annotations[config.AnnotationFailure] = lastErr.Error()
+
+	if _, err := appConfig.Clientset.CoreV1().PersistentVolumes().Update(pv); err != nil {
+		log.Errorf("PersistentVolume: %v could not be marked with annotation: %v: %v", pv.Name, config.AnnotationFailure, err)
+	}
+	appConfig.Event(pv, v1.EventTypeWarning, "RemovalFailed", "Removal has been given up: %v. Remove annotation %v to try again", lastErr, config.AnnotationFailure)
+}
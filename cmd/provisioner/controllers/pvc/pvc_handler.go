@@ -4,56 +4,156 @@ import (
 	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/checker"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/controllers"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
 	"k8s-pv-provisioner/cmd/provisioner/storage"
 
 	core_v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/klog"
 )
 
 var appConfig = config.GetInstance()
 
+/*Waiting keeps the PVCs waiting for a change of the cluster, e.g. the ones over the budget of their namespace. They are handled
+again as soon as the subject they wait for is changed*/
+var Waiting = controllers.NewWaiters()
+
+/*NamespaceSubject is the subject of Waiting changed along with the namespace or the PVs bound to its PVCs*/
+func NamespaceSubject(namespace string) string {
+	return "namespace/" + namespace
+}
+
+/*ClassSubject is the subject of Waiting changed along with the PVs of the storage class*/
+func ClassSubject(className string) string {
+	return "class/" + className
+}
+
+/*NeedsReconciliation is the func returning true for the PVC which is not bound yet and therefore may need a new PV*/
+func NeedsReconciliation(obj interface{}) bool {
+	pvc, ok := obj.(*core_v1.PersistentVolumeClaim)
+	return ok && pvc.Spec.VolumeName == ""
+}
+
 /*Handler is the business logic method of the Controller to provision PersistentVolumes for corresponding PersistentVolumeClaims.
 Once the handler returns an error the current key will be put to the queue to be processed later. If the method returns nil the key
 will be withdrawn from the queue because there is no need to do anything with it */
-func Handler(indexer cache.Indexer, key string) error {
+func Handler(log *logging.Logger, indexer cache.Indexer, key string) error {
 	obj, exists, err := indexer.GetByKey(key)
 
 	if err != nil {
-		klog.Errorf("Could not fetch key: %v", key)
+		log.Errorf("Could not fetch key: %v", key)
 		return err
 	}
 
 	if !exists {
-		klog.Warningf("PersistentVolumeClaim does not exists anymore: %v", key)
+		log.Warningf("PersistentVolumeClaim does not exists anymore: %v", key)
+		Waiting.Done(key)
+		storage.ReleaseStorage(key)
+		storage.CancelPopulation(key)
 		return nil
 	}
 
+	//The storage reserved by the checks is kept until the PV is in the cache, unless the PVC is not provisioned
+	var waiting, provisioned bool
+	defer func() {
+		if !waiting {
+			Waiting.Done(key)
+		}
+		if !provisioned {
+			storage.ReleaseStorage(key)
+		}
+	}()
+
 	pvc := obj.(*core_v1.PersistentVolumeClaim)
+	log = log.WithPVC(pvc)
+
+	if lastError, ok := pvc.Annotations[config.AnnotationFailure]; ok {
+		log.V(logging.LevelDecision).Infof("PersistentVolumeClaim: %v is skipped because provisioning has been given up: %v", pvc.Name, lastError)
+		return nil
+	}
+
 	checkList := checker.NewPvcChecker(pvc)
-	checkList.PerformChecks()
+	checkList.SetLogger(log)
+	report := checkList.PerformChecks()
 
-	if !checkList.IsAllOK() {
-		if checkList.IsNotBound() && checkList.HasProperStorageClassName() {
-			return fmt.Errorf("Not all checks of persistentVolumeClaim have been passed to continue provisioning: %v", pvc.Name)
+	switch report.Verdict {
+	case checker.RetryLater:
+		//The budget might be freed or increased at any moment therefore the claim waits for it without spending its retries. The
+		//claim is handled again once its namespace or the PVs of the namespace are changed, the event is emitted only once
+		if report.Failed().Name == checker.WithinBudget {
+			waiting = true
+			if Waiting.Wait(key, checker.WithinBudget, NamespaceSubject(pvc.Namespace)) {
+				appConfig.Event(pvc, core_v1.EventTypeWarning, "OverBudget", "Provisioning is waiting for the budget: %v", report.Failed().Reason)
+			}
+			return failures.Waiting(fmt.Errorf("PersistentVolumeClaim: %v is over budget", pvc.Name))
+		}
+		//The same goes for the free space which appears once the PVs of the storage class are deleted or the file system is
+		//extended, the latter is noticed by the growing delays
+		if report.Failed().Name == checker.FitsCapacity {
+			waiting = true
+			if Waiting.Wait(key, checker.FitsCapacity, ClassSubject(*pvc.Spec.StorageClassName)) {
+				appConfig.Event(pvc, core_v1.EventTypeWarning, "InsufficientCapacity", "Provisioning is waiting for free space: %v", report.Failed().Reason)
+			}
+			return failures.Waiting(fmt.Errorf("PersistentVolumeClaim: %v does not fit into free space", pvc.Name))
+		}
+		return fmt.Errorf("Not all checks of persistentVolumeClaim have been passed to continue provisioning: %v: %v", pvc.Name, report.Failed().Reason)
+	case checker.Skip:
+		//The unbound claim of the served storage class is waiting for us therefore its owner should know why nothing happens
+		if report.Passed(checker.ProperStorageClassName) && report.Passed(checker.NotBound) {
+			appConfig.Event(pvc, core_v1.EventTypeWarning, "ProvisioningSkipped", "Provisioning is skipped: %v", report)
 		}
 		//It's not our canditate at all. Forget about it
 		return nil
 	}
 
-	klog.V(1).Infof("PersistentVolumeClaim looks like a candidate for provisioning: %v", pvc.Name)
+	log.V(logging.LevelChange).Infof("PersistentVolumeClaim looks like a candidate for provisioning: %v", pvc.Name)
 
-	pv, err := storage.PreparePV(pvc)
+	currentStorageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
+	release, err := controllers.AcquireStorageClassSlot(currentStorageClass.Name, currentStorageClass.MaxConcurrentOperations)
 	if err != nil {
-		klog.Errorf("PersistentVolume provisioning for persistentVolumeClaim: %s failed: %s", pvc.Name, err)
+		log.V(logging.LevelDecision).Infof("PersistentVolumeClaim: %v provisioning is postponed: %v", pvc.Name, err)
+		return failures.Postponed(err)
+	}
+	defer release()
+
+	pv, err := storage.PreparePV(log, pvc)
+	if err == storage.ErrPopulationInProgress {
+		provisioned = true
+		log.V(logging.LevelChange).Infof("PersistentVolumeClaim: %v is waiting for population of storage asset from data source", pvc.Name)
+		return failures.Postponed(err)
+	}
+	if err != nil {
+		log.Errorf("PersistentVolume provisioning for persistentVolumeClaim: %s failed: %s", pvc.Name, err)
 		return err
 	}
 
 	if _, err = appConfig.Clientset.CoreV1().PersistentVolumes().Create(pv); err != nil {
 		return err
 	}
+	provisioned = true
 
-	klog.V(1).Infof("PersistentVolume: %v successfully created and bound to persistentVolumeClaim: %v", pv.Name, pvc.Name)
+	log.With(logging.Fields{logging.KeyPV: pv.Name}).Infof("PersistentVolume: %v successfully created and bound to persistentVolumeClaim: %v", pv.Name, pvc.Name)
 
 	return nil
 }
+
+/*GiveUpHandler is the func marking the PersistentVolumeClaim which provisioning has been given up with the annotation and the
+event. The provisioning is tried again once the annotation is removed*/
+func GiveUpHandler(log *logging.Logger, indexer cache.Indexer, key string, lastErr error) {
+	obj, exists, err := indexer.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+
+	pvc := obj.(*core_v1.PersistentVolumeClaim).DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = make(map[string]string)
+	}
+	pvc.Annotations[config.AnnotationFailure] = lastErr.Error()
+
+	if _, err := appConfig.Clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(pvc); err != nil {
+		log.Errorf("PersistentVolumeClaim: %v could not be marked with annotation: %v: %v", pvc.Name, config.AnnotationFailure, err)
+	}
+	appConfig.Event(pvc, core_v1.EventTypeWarning, "ProvisioningFailed", "Provisioning has been given up: %v. Remove annotation %v to try again", lastErr, config.AnnotationFailure)
+}
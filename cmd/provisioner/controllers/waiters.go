@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"sort"
+	"sync"
+)
+
+/*Waiters keeps the keys of the items waiting for a change of the cluster, e.g. the PVCs over the budget of their namespace. Each
+item waits for the reason, e.g. the name of the failed check, and is woken up by the changes of the subjects, e.g. of its namespace*/
+type Waiters struct {
+	locker   sync.Mutex
+	reasons  map[string]string
+	subjects map[string]map[string]bool
+}
+
+/*NewWaiters is the func which is like a constructor*/
+func NewWaiters() *Waiters {
+	return &Waiters{reasons: make(map[string]string), subjects: make(map[string]map[string]bool)}
+}
+
+/*Wait is the method registering the item waiting for the reason and for a change of the subjects. The true is returned if the
+item has not been waiting for the same reason before, e.g. the event about it should be emitted*/
+func (w *Waiters) Wait(key, reason string, subjects ...string) bool {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+
+	previous, ok := w.reasons[key]
+	w.forget(key)
+	w.reasons[key] = reason
+	for _, subject := range subjects {
+		if w.subjects[subject] == nil {
+			w.subjects[subject] = make(map[string]bool)
+		}
+		w.subjects[subject][key] = true
+	}
+	return !ok || previous != reason
+}
+
+/*Done is the method forgetting the item which does not wait anymore*/
+func (w *Waiters) Done(key string) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+
+	w.forget(key)
+	delete(w.reasons, key)
+}
+
+/*Wake is the method returning the sorted keys of the items waiting for a change of any of the subjects. The items keep waiting
+until they are handled again*/
+func (w *Waiters) Wake(subjects ...string) []string {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+
+	found := make(map[string]bool)
+	for _, subject := range subjects {
+		for key := range w.subjects[subject] {
+			found[key] = true
+		}
+	}
+	result := make([]string, 0, len(found))
+	for key := range found {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (w *Waiters) forget(key string) {
+	for subject, keys := range w.subjects {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(w.subjects, subject)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/cache"
+)
+
+var appConfig = config.GetInstance()
+
+//instanceSeparator separates the identity of the instance which created the volume from the storage asset path in the volume ID
+const instanceSeparator = "#"
+
+//accessModes maps the access modes of CSI to the ones of the PVCs the same way as kubelet does
+var accessModes = map[csi.VolumeCapability_AccessMode_Mode]core_v1.PersistentVolumeAccessMode{
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        core_v1.ReadWriteOnce,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  core_v1.ReadWriteOnce,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: config.AccessModeReadWriteOncePod,
+	csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:   core_v1.ReadOnlyMany,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:    core_v1.ReadOnlyMany,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:  core_v1.ReadWriteMany,
+	csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER:   core_v1.ReadWriteMany,
+}
+
+//controllerServer is the controller service of the driver
+type controllerServer struct {
+	csi.UnimplementedControllerServer
+	driver *Driver
+}
+
+/*accessModesOf returns the access modes of PVC requested by the capabilities. Only the mounted volumes are supported, the block
+ones are not*/
+func accessModesOf(capabilities []*csi.VolumeCapability) ([]core_v1.PersistentVolumeAccessMode, error) {
+	result := make([]core_v1.PersistentVolumeAccessMode, 0, len(capabilities))
+	seen := make(map[core_v1.PersistentVolumeAccessMode]bool)
+	for _, capability := range capabilities {
+		if capability.GetBlock() != nil {
+			return nil, fmt.Errorf("Block volumes are not supported")
+		}
+		if capability.GetMount() == nil {
+			return nil, fmt.Errorf("Access type of volume capability must be mount")
+		}
+		mode, ok := accessModes[capability.GetAccessMode().GetMode()]
+		if !ok {
+			return nil, fmt.Errorf("Access mode: %v is not supported", capability.GetAccessMode().GetMode())
+		}
+		if !seen[mode] {
+			seen[mode] = true
+			result = append(result, mode)
+		}
+	}
+	return result, nil
+}
+
+//checkAccessModes returns error if the storage class does not support any of the access modes
+func checkAccessModes(className string, modes []core_v1.PersistentVolumeAccessMode) error {
+	supported := make(map[core_v1.PersistentVolumeAccessMode]bool)
+	for _, mode := range appConfig.StorageClasses[className].SupportedAccessModes {
+		supported[mode] = true
+	}
+	for _, mode := range modes {
+		if !supported[mode] {
+			return fmt.Errorf("Access mode: %v is not supported by storage class: %v", mode, className)
+		}
+	}
+	return nil
+}
+
+/*claimOf returns the PVC which the storage asset of the volume is prepared for. The volume name is unique, therefore the storage
+asset left by the previous call with the same name is reused*/
+func claimOf(req *csi.CreateVolumeRequest, className string, modes []core_v1.PersistentVolumeAccessMode) *core_v1.PersistentVolumeClaim {
+	pvc := new(core_v1.PersistentVolumeClaim)
+	pvc.Namespace = req.GetParameters()[paramPVCNamespace]
+	pvc.Name = req.GetName()
+	pvc.Annotations = map[string]string{config.AnnotationUseExistingAsset: "true"}
+	pvc.Spec.StorageClassName = &className
+	pvc.Spec.AccessModes = modes
+	pvc.Spec.Resources.Requests = core_v1.ResourceList{
+		core_v1.ResourceStorage: *resource.NewQuantity(req.GetCapacityRange().GetRequiredBytes(), resource.BinarySI),
+	}
+	return pvc
+}
+
+//contextOf returns the volume context telling the node service how to mount the volume of the PV
+func contextOf(pv *core_v1.PersistentVolume) map[string]string {
+	source := pv.Spec.PersistentVolumeSource
+	switch {
+	case source.NFS != nil:
+		return map[string]string{contextType: config.VolumeTypeNFS, contextServer: source.NFS.Server, contextPath: source.NFS.Path}
+	case source.CSI != nil:
+		//The storage class has csiDriver parameter, the volume is mounted by the driver itself anyway
+		attributes := source.CSI.VolumeAttributes
+		return map[string]string{contextType: config.VolumeTypeNFS, contextServer: attributes["server"], contextPath: path.Join(attributes["share"], attributes["subDir"])}
+	}
+	return map[string]string{contextType: config.VolumeTypeHostPath, contextPath: source.HostPath.Path}
+}
+
+/*volumeIDOf returns the volume ID of the PV: the path of its storage asset followed by the identity of the instance which created
+it, if the instance has any*/
+func volumeIDOf(pv *core_v1.PersistentVolume) string {
+	volumeID := pv.Annotations[config.AnnotationAssetPath]
+	if instanceID := pv.Annotations[config.AnnotationInstanceID]; instanceID != "" {
+		volumeID += instanceSeparator + instanceID
+	}
+	return volumeID
+}
+
+/*volumeOf returns the PV which the storage asset of the volume could be found and deleted by. The volume ID is the path of the
+storage asset relative to the storage asset root: <storage class name>/<storage asset name>, optionally followed by #<instance id>*/
+func volumeOf(volumeID string) (*core_v1.PersistentVolume, bool) {
+	var instanceID string
+	if index := strings.Index(volumeID, instanceSeparator); index >= 0 {
+		volumeID, instanceID = volumeID[:index], volumeID[index+1:]
+	}
+	items := strings.SplitN(volumeID, "/", 2)
+	if len(items) != 2 || items[1] == "" || items[1] == "." || items[1] == ".." || strings.Contains(items[1], "/") {
+		return nil, false
+	}
+	class, ok := appConfig.StorageClasses[items[0]]
+	if !ok {
+		return nil, false
+	}
+
+	pv := new(core_v1.PersistentVolume)
+	pv.Name = items[1]
+	pv.Spec.StorageClassName = class.Name
+	pv.Annotations = map[string]string{config.AnnotationAssetPath: volumeID}
+	if instanceID != "" {
+		pv.Annotations[config.AnnotationInstanceID] = instanceID
+	}
+	if class.PerVolumeExports {
+		pv.Annotations[config.AnnotationExport] = items[1]
+	}
+	return pv, true
+}
+
+//CreateVolume is implementation of csi.ControllerServer.CreateVolume
+func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Name of volume is required")
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities are required")
+	}
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume content source is not supported")
+	}
+	capacity := req.GetCapacityRange()
+	if capacity.GetLimitBytes() > 0 && capacity.GetRequiredBytes() > capacity.GetLimitBytes() {
+		return nil, status.Errorf(codes.OutOfRange, "Required bytes: %v exceed limit bytes: %v", capacity.GetRequiredBytes(), capacity.GetLimitBytes())
+	}
+
+	className, err := s.driver.classOf(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	class := appConfig.StorageClasses[className]
+	if class.AssetType == config.AssetTypeImage {
+		return nil, status.Errorf(codes.InvalidArgument, "Image storage assets of storage class: %v are not supported", className)
+	}
+	if class.Volume.Type != config.VolumeTypeHostPath && class.Volume.Type != config.VolumeTypeNFS {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume type: %v of storage class: %v is not supported", class.Volume.Type, className)
+	}
+	modes, err := accessModesOf(req.GetVolumeCapabilities())
+	if err == nil {
+		err = checkAccessModes(className, modes)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	//The storage is reserved while the volume is created, so the simultaneous requests do not exceed the capacity. There is no
+	//cache of PVs dropping the reservation, the PV is counted once csi-provisioner creates it
+	pvc := claimOf(req, className, modes)
+	if key, err := cache.MetaNamespaceKeyFunc(pvc); err == nil {
+		defer storage.ReleaseStorage(key)
+	}
+	if err := storage.CheckCapacity(pvc); storage.IsInsufficientCapacity(err) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log := s.driver.log.With(logging.Fields{logging.KeyStorageClass: className, logging.KeyPV: req.GetName()})
+	pv, err := storage.PreparePV(log, pvc)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	volumeID := volumeIDOf(pv)
+	log.V(logging.LevelChange).Infof("Volume: %v was created for: %v", volumeID, req.GetName())
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: capacity.GetRequiredBytes(),
+			VolumeContext: contextOf(pv),
+		},
+	}, nil
+}
+
+//DeleteVolume is implementation of csi.ControllerServer.DeleteVolume, the unknown volumes are considered deleted
+func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	pv, ok := volumeOf(req.GetVolumeId())
+	if !ok {
+		s.driver.log.V(logging.LevelDecision).Infof("Volume: %v does not belong to any of served storage classes", req.GetVolumeId())
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+	if err := checkOwned(pv, req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+
+	assetPath := storage.AssetPathOf(pv)
+	log := s.driver.log.WithPV(pv).WithAssetPath(assetPath)
+	if err := storage.UnexportStorageAsset(log, pv); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := storage.DeleteStorageAsset(log, assetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+//ControllerExpandVolume is implementation of csi.ControllerServer.ControllerExpandVolume, the directories do not have any size
+func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if req.GetCapacityRange() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Capacity range is required")
+	}
+	if err := s.checkExists(req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+	return &csi.ControllerExpandVolumeResponse{CapacityBytes: req.GetCapacityRange().GetRequiredBytes(), NodeExpansionRequired: false}, nil
+}
+
+//ValidateVolumeCapabilities is implementation of csi.ControllerServer.ValidateVolumeCapabilities
+func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume capabilities are required")
+	}
+	if err := s.checkExists(req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+
+	modes, err := accessModesOf(req.GetVolumeCapabilities())
+	if err == nil {
+		err = checkAccessModes(strings.SplitN(req.GetVolumeId(), "/", 2)[0], modes)
+	}
+	if err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+/*GetCapacity is implementation of csi.ControllerServer.GetCapacity. The capacity is the storage which might be requested by new
+volumes of the storage class according to its capacityHeadroom and capacityOvercommit parameters, the storage class which does
+not support the capabilities has none*/
+func (s *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	className, err := s.driver.classOf(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(req.GetVolumeCapabilities()) > 0 {
+		modes, err := accessModesOf(req.GetVolumeCapabilities())
+		if err == nil {
+			err = checkAccessModes(className, modes)
+		}
+		if err != nil {
+			return &csi.GetCapacityResponse{}, nil
+		}
+	}
+
+	allocatable, err := storage.AllocatableCapacity(className)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: allocatable}, nil
+}
+
+//ControllerGetCapabilities is implementation of csi.ControllerServer.ControllerGetCapabilities
+func (s *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	capabilities := make([]*csi.ControllerServiceCapability, 0, 3)
+	for _, capability := range []csi.ControllerServiceCapability_RPC_Type{
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+	} {
+		capabilities = append(capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: capability}},
+		})
+	}
+	return &csi.ControllerGetCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+/*checkOwned returns FailedPrecondition error if the volume has been created by another instance which has not been taken over,
+the same way the PVs of other instances are not deleted by the provisioner*/
+func checkOwned(pv *core_v1.PersistentVolume, volumeID string) error {
+	if instanceID := pv.Annotations[config.AnnotationInstanceID]; !appConfig.Owns(instanceID) {
+		return status.Errorf(codes.FailedPrecondition, "Volume: %v belongs to instance: %q", volumeID, instanceID)
+	}
+	return nil
+}
+
+//checkExists returns NotFound error if the storage asset of the volume does not exist
+func (s *controllerServer) checkExists(volumeID string) error {
+	pv, ok := volumeOf(volumeID)
+	if !ok {
+		return status.Errorf(codes.NotFound, "Volume: %v does not belong to any of served storage classes", volumeID)
+	}
+	if err := checkOwned(pv, volumeID); err != nil {
+		return err
+	}
+	exists, err := appConfig.Backend.Exists(storage.AssetPathOf(pv))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !exists {
+		return status.Errorf(codes.NotFound, "Volume: %v does not exist", volumeID)
+	}
+	return nil
+}
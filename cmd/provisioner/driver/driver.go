@@ -0,0 +1,169 @@
+/*Package driver is the CSI driver provisioning the storage assets by the same logic as the provisioner watching PVCs. It runs
+behind the standard sidecars: external-provisioner and external-resizer call the controller service, node-driver-registrar
+registers the node service on each node*/
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	storage_v1 "k8s.io/api/storage/v1"
+)
+
+//The keys of the volume context passed from the controller service to the node service
+const (
+	contextType   = "type"
+	contextServer = "server"
+	contextPath   = "path"
+)
+
+//paramPrefix is the prefix of the parameters added to the storage class parameters by the sidecars, e.g. the PVC name
+const paramPrefix = "csi.storage.k8s.io/"
+
+//paramPVCNamespace is the parameter passed by external-provisioner started with --extra-create-metadata flag
+const paramPVCNamespace = paramPrefix + "pvc/namespace"
+
+//Options are the parameters of the driver
+type Options struct {
+	//Name is the name of the driver which the storage classes have as the provisioner
+	Name string
+	//Version is the version of the driver reported to the sidecars
+	Version string
+	//NodeID is the name of the node which the node service runs on
+	NodeID string
+	//Controller enables the controller service
+	Controller bool
+	//Node enables the node service
+	Node bool
+}
+
+/*Driver serves the CSI services over gRPC. The controller service creates the storage assets of the storage classes which have
+been parsed to the appConfig, the node service mounts them*/
+type Driver struct {
+	options Options
+	//classes are the parameters of the served storage classes which the storage class of the volume is recognized by
+	classes map[string]map[string]string
+	mounter Mounter
+	server  *grpc.Server
+	log     *logging.Logger
+}
+
+//NewDriver is the func which is like a constructor, the classes must be parsed to the appConfig already
+func NewDriver(options Options, classes []storage_v1.StorageClass) *Driver {
+	d := &Driver{
+		options: options,
+		classes: make(map[string]map[string]string),
+		mounter: commandMounter{},
+		log:     logging.New(logging.Fields{logging.KeyController: "CSI"}),
+	}
+	for _, class := range classes {
+		d.classes[class.Name] = class.Parameters
+	}
+
+	d.server = grpc.NewServer(grpc.UnaryInterceptor(d.logCalls))
+	csi.RegisterIdentityServer(d.server, &identityServer{driver: d})
+	if options.Controller {
+		csi.RegisterControllerServer(d.server, &controllerServer{driver: d})
+	}
+	if options.Node {
+		csi.RegisterNodeServer(d.server, &nodeServer{driver: d})
+	}
+	return d
+}
+
+/*parseEndpoint returns the network and the address of the endpoint, e.g. unix:///csi/csi.sock or tcp://127.0.0.1:10000. The
+stale unix socket left by the previous run is removed*/
+func parseEndpoint(endpoint string) (string, string, error) {
+	value, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("Wrong endpoint: %v: %v", endpoint, err)
+	}
+	switch value.Scheme {
+	case "unix":
+		address := value.Path
+		if address == "" {
+			address = value.Host
+		}
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("Could not remove unix socket: %v: %v", address, err)
+		}
+		return "unix", address, nil
+	case "tcp":
+		return "tcp", value.Host, nil
+	}
+	return "", "", fmt.Errorf("Endpoint: %v must have unix or tcp scheme", endpoint)
+}
+
+//Run is the method serving the services on the endpoint until Stop is called
+func (d *Driver) Run(endpoint string) error {
+	network, address, err := parseEndpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	d.log.Infof("Starting CSI driver: %v on: %v, controller service: %v, node service: %v", d.options.Name, endpoint, d.options.Controller, d.options.Node)
+	return d.server.Serve(listener)
+}
+
+//Stop is the method stopping the server once the calls in progress are finished
+func (d *Driver) Stop() {
+	d.server.GracefulStop()
+}
+
+//logCalls is the interceptor logging every call and its failure
+func (d *Driver) logCalls(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	d.log.V(logging.LevelDebug).Infof("Call: %v request: %+v", info.FullMethod, req)
+	result, err := handler(ctx, req)
+	if err != nil {
+		d.log.Warningf("Call: %v failed: %v", info.FullMethod, err)
+	}
+	return result, err
+}
+
+/*classOf returns the name of the served storage class which parameters are the same as the parameters of the request. The
+parameters added by the sidecars are not taken into account*/
+func (d *Driver) classOf(parameters map[string]string) (string, error) {
+	matched := make([]string, 0, 1)
+	for name, classParameters := range d.classes {
+		if sameParameters(classParameters, parameters) {
+			matched = append(matched, name)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("Parameters: %v do not belong to any of served storage classes", parameters)
+	case 1:
+		return matched[0], nil
+	}
+	return "", fmt.Errorf("Parameters: %v belong to several storage classes: %v", parameters, strings.Join(matched, ", "))
+}
+
+func sameParameters(class, request map[string]string) bool {
+	count := 0
+	for key, value := range request {
+		if strings.HasPrefix(key, paramPrefix) {
+			continue
+		}
+		if classValue, ok := class[key]; !ok || classValue != value {
+			return false
+		}
+		count++
+	}
+	for key := range class {
+		if strings.HasPrefix(key, paramPrefix) {
+			count++
+		}
+	}
+	return count == len(class)
+}
@@ -0,0 +1,379 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+//fakeMounter records the mounts instead of making them
+type fakeMounter struct {
+	mounts map[string]string
+}
+
+func (m *fakeMounter) Mount(source, target, fsType string, options []string) error {
+	m.mounts[target] = fmt.Sprintf("%v %v %v", fsType, source, strings.Join(options, ","))
+	return nil
+}
+
+func (m *fakeMounter) Unmount(target string) error {
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMountPoint(target string) (bool, error) {
+	_, ok := m.mounts[target]
+	return ok, nil
+}
+
+func newTestDriver(t *testing.T) (*Driver, *backend.Memory) {
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	classes := []storage_v1.StorageClass{
+		{Provisioner: "csi.pv.provisioner", ReclaimPolicy: &retainPolicy, Parameters: map[string]string{
+			"defaultOwnerAssetUid": "1000",
+			"defaultOwnerAssetGid": "1000",
+			"assetRoot":            "nfs1:/exports/pv"}},
+		{Provisioner: "csi.pv.provisioner", ReclaimPolicy: &retainPolicy, Parameters: map[string]string{
+			"defaultOwnerAssetUid": "1000",
+			"defaultOwnerAssetGid": "1000",
+			"assetRoot":            "/mnt/pv",
+			"supportedAccessModes": "ReadWriteOnce,ReadWriteOncePod"}},
+	}
+	classes[0].Name = "csi-nfs"
+	classes[1].Name = "csi-local"
+
+	memory := backend.NewMemory()
+	appConfig.StorageAssetRoot = "/pv-store"
+	appConfig.Backend = memory
+	for index := range classes {
+		appConfig.ParseStorageClass(&classes[index])
+	}
+	t.Cleanup(func() {
+		appConfig.Backend = backend.Local{}
+		for _, class := range classes {
+			delete(appConfig.StorageClasses, class.Name)
+		}
+	})
+
+	d := NewDriver(Options{Name: "csi.pv.provisioner", Version: "test", NodeID: "node1", Controller: true, Node: true}, classes)
+	d.mounter = &fakeMounter{mounts: make(map[string]string)}
+	return d, memory
+}
+
+func mountCapability(mode csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability {
+	return []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}}
+}
+
+func codeOf(err error) codes.Code {
+	return status.Code(err)
+}
+
+func Test_sameParameters(t *testing.T) {
+	class := map[string]string{"assetRoot": "/mnt/pv", paramPrefix + "node-stage-secret-name": "secret"}
+	checkTestResults(t, "sidecar parameters are ignored", true, sameParameters(class, map[string]string{"assetRoot": "/mnt/pv", paramPVCNamespace: "ns1"}))
+	checkTestResults(t, "different value", false, sameParameters(class, map[string]string{"assetRoot": "/mnt/other"}))
+	checkTestResults(t, "missing parameter", false, sameParameters(class, map[string]string{}))
+	checkTestResults(t, "extra parameter", false, sameParameters(class, map[string]string{"assetRoot": "/mnt/pv", "extra": "1"}))
+}
+
+func Test_controller(t *testing.T) {
+	d, memory := newTestDriver(t)
+	controller := &controllerServer{driver: d}
+	ctx := context.Background()
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		Parameters:         map[string]string{"defaultOwnerAssetUid": "1000", "defaultOwnerAssetGid": "1000", "assetRoot": "nfs1:/exports/pv", paramPVCNamespace: "ns1"},
+	}
+	result, err := controller.CreateVolume(ctx, req)
+	checkTestResults(t, "nfs volume is created", nil, err)
+	checkTestResults(t, "nfs volume ID", "csi-nfs/ns1-pvc-1-vol", result.GetVolume().GetVolumeId())
+	checkTestResults(t, "nfs volume capacity", int64(1<<30), result.GetVolume().GetCapacityBytes())
+	checkTestResults(t, "nfs volume context", map[string]string{"type": "nfs", "server": "nfs1", "path": "/exports/pv/ns1-pvc-1-vol"}, result.GetVolume().GetVolumeContext())
+	uid, gid, _ := memory.Owner("/pv-store/csi-nfs/ns1-pvc-1-vol")
+	checkTestResults(t, "storage asset owner", "1000:1000", fmt.Sprintf("%v:%v", uid, gid))
+
+	_, err = controller.CreateVolume(ctx, req)
+	checkTestResults(t, "creation is idempotent", nil, err)
+
+	local := &csi.CreateVolumeRequest{
+		Name:               "pvc-2",
+		VolumeCapabilities: mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		Parameters:         map[string]string{"defaultOwnerAssetUid": "1000", "defaultOwnerAssetGid": "1000", "assetRoot": "/mnt/pv", "supportedAccessModes": "ReadWriteOnce,ReadWriteOncePod"},
+	}
+	_, err = controller.CreateVolume(ctx, local)
+	checkTestResults(t, "hostPath volume does not support RWX", codes.InvalidArgument, codeOf(err))
+	local.VolumeCapabilities = mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	result, err = controller.CreateVolume(ctx, local)
+	checkTestResults(t, "hostPath volume is created", nil, err)
+	checkTestResults(t, "hostPath volume ID without namespace", "csi-local/pvc-2-vol", result.GetVolume().GetVolumeId())
+	checkTestResults(t, "hostPath volume context", map[string]string{"type": "hostPath", "path": "/mnt/pv/pvc-2-vol"}, result.GetVolume().GetVolumeContext())
+
+	_, err = controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-3", VolumeCapabilities: local.VolumeCapabilities, Parameters: map[string]string{"assetRoot": "/unknown"}})
+	checkTestResults(t, "unknown storage class", codes.InvalidArgument, codeOf(err))
+	block := []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+	_, err = controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-3", VolumeCapabilities: block, Parameters: local.Parameters})
+	checkTestResults(t, "block volume", codes.InvalidArgument, codeOf(err))
+
+	validation, err := controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "csi-nfs/ns1-pvc-1-vol", VolumeCapabilities: req.VolumeCapabilities})
+	checkTestResults(t, "capabilities are validated", nil, err)
+	checkTestResults(t, "capabilities are confirmed", true, validation.GetConfirmed() != nil)
+	validation, err = controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "csi-local/pvc-2-vol", VolumeCapabilities: req.VolumeCapabilities})
+	checkTestResults(t, "unsupported capabilities are not confirmed", true, err == nil && validation.GetConfirmed() == nil)
+	_, err = controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "csi-nfs/missing", VolumeCapabilities: req.VolumeCapabilities})
+	checkTestResults(t, "missing volume is not validated", codes.NotFound, codeOf(err))
+
+	expansion, err := controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "csi-nfs/ns1-pvc-1-vol", CapacityRange: &csi.CapacityRange{RequiredBytes: 2 << 30}})
+	checkTestResults(t, "volume is expanded", nil, err)
+	checkTestResults(t, "expanded capacity", int64(2<<30), expansion.GetCapacityBytes())
+	checkTestResults(t, "node expansion is not required", false, expansion.GetNodeExpansionRequired())
+
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/ns1-pvc-1-vol"})
+	checkTestResults(t, "volume is deleted", nil, err)
+	exists, _ := memory.Exists("/pv-store/csi-nfs/ns1-pvc-1-vol")
+	checkTestResults(t, "storage asset is deleted", false, exists)
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/ns1-pvc-1-vol"})
+	checkTestResults(t, "deletion is idempotent", nil, err)
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/.."})
+	checkTestResults(t, "volume ID could not escape storage class", nil, err)
+	exists, _ = memory.Exists("/pv-store/csi-local/pvc-2-vol")
+	checkTestResults(t, "other storage assets are kept", true, exists)
+}
+
+func Test_instanceIdentity(t *testing.T) {
+	d, memory := newTestDriver(t)
+	controller := &controllerServer{driver: d}
+	ctx := context.Background()
+	defer func() { appConfig.InstanceID, appConfig.AdoptedInstanceIDs = "", nil }()
+
+	appConfig.InstanceID = "cluster1"
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		VolumeCapabilities: mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		Parameters:         map[string]string{"defaultOwnerAssetUid": "1000", "defaultOwnerAssetGid": "1000", "assetRoot": "nfs1:/exports/pv"},
+	}
+	result, err := controller.CreateVolume(ctx, req)
+	checkTestResults(t, "volume is created", nil, err)
+	checkTestResults(t, "volume ID has identity", "csi-nfs/pvc-1-vol#cluster1", result.GetVolume().GetVolumeId())
+
+	appConfig.InstanceID = "cluster2"
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/pvc-1-vol#cluster1"})
+	checkTestResults(t, "volume of other instance is not deleted", codes.FailedPrecondition, codeOf(err))
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/pvc-1-vol"})
+	checkTestResults(t, "volume without identity is not deleted", codes.FailedPrecondition, codeOf(err))
+	_, err = controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "csi-nfs/pvc-1-vol#cluster1", CapacityRange: &csi.CapacityRange{RequiredBytes: 1 << 30}})
+	checkTestResults(t, "volume of other instance is not expanded", codes.FailedPrecondition, codeOf(err))
+	exists, _ := memory.Exists("/pv-store/csi-nfs/pvc-1-vol")
+	checkTestResults(t, "storage asset is kept", true, exists)
+
+	appConfig.AdoptedInstanceIDs = []string{"cluster1"}
+	_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csi-nfs/pvc-1-vol#cluster1"})
+	checkTestResults(t, "volume of adopted instance is deleted", nil, err)
+	exists, _ = memory.Exists("/pv-store/csi-nfs/pvc-1-vol")
+	checkTestResults(t, "storage asset is deleted", false, exists)
+}
+
+func Test_capacity(t *testing.T) {
+	d, memory := newTestDriver(t)
+	controller := &controllerServer{driver: d}
+	ctx := context.Background()
+
+	//The class of local volumes admits the volumes against the free space of the file system
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	class := storage_v1.StorageClass{Provisioner: "csi.pv.provisioner", ReclaimPolicy: &retainPolicy, Parameters: map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/mnt/pv",
+		"capacityAdmission":    "true",
+		"capacityHeadroom":     "1Gi",
+		"supportedAccessModes": "ReadWriteOnce"}}
+	class.Name = "csi-local"
+	appConfig.ParseStorageClass(&class)
+	d.classes[class.Name] = class.Parameters
+	memory.MkdirAll("/pv-store/csi-local", 0755)
+	memory.SetCapacity(4 << 30)
+
+	capacity, err := controller.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: class.Parameters})
+	checkTestResults(t, "capacity is reported", nil, err)
+	checkTestResults(t, "capacity without headroom", int64(3<<30), capacity.GetAvailableCapacity())
+	capacity, err = controller.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: class.Parameters, VolumeCapabilities: mountCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)})
+	checkTestResults(t, "no capacity for unsupported capabilities", true, err == nil && capacity.GetAvailableCapacity() == 0)
+	_, err = controller.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: map[string]string{"assetRoot": "/unknown"}})
+	checkTestResults(t, "capacity of unknown storage class", codes.InvalidArgument, codeOf(err))
+
+	//The PVs of the storage class are listed by the API and commit the storage
+	pv := new(core_v1.PersistentVolume)
+	pv.Name = "pv-1"
+	pv.Annotations = map[string]string{config.AnnotationProvisionedBy: class.Provisioner}
+	pv.Spec.StorageClassName = class.Name
+	pv.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("2Gi")}
+	appConfig.Clientset = fake.NewSimpleClientset(pv)
+	capacity, err = controller.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: class.Parameters})
+	checkTestResults(t, "capacity without committed storage", true, err == nil && capacity.GetAvailableCapacity() == 1<<30)
+	appConfig.Clientset = nil
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 4 << 30},
+		VolumeCapabilities: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+		Parameters:         class.Parameters,
+	}
+	_, err = controller.CreateVolume(ctx, req)
+	checkTestResults(t, "volume larger than free space", codes.ResourceExhausted, codeOf(err))
+	exists, _ := memory.Exists("/pv-store/csi-local/pvc-1-vol")
+	checkTestResults(t, "storage asset is not created", false, exists)
+	req.CapacityRange.RequiredBytes = 3 << 30
+	_, err = controller.CreateVolume(ctx, req)
+	checkTestResults(t, "volume fitting into free space", nil, err)
+	capacity, _ = controller.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: class.Parameters})
+	checkTestResults(t, "storage is not reserved once volume is created", int64(3<<30), capacity.GetAvailableCapacity())
+
+	memory.AddFile("/pv-store/csi-local/pvc-1-vol/data", 3<<30, 0644)
+	_, err = controller.CreateVolume(ctx, req)
+	checkTestResults(t, "creation of existing volume is idempotent", nil, err)
+}
+
+func Test_node(t *testing.T) {
+	d, _ := newTestDriver(t)
+	node := &nodeServer{driver: d}
+	mounter := d.mounter.(*fakeMounter)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "csi-node-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: []string{"nfsvers=4.1"}}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "csi-nfs/ns1-pvc-1-vol",
+		TargetPath:       target,
+		VolumeCapability: capability,
+		Readonly:         true,
+		VolumeContext:    map[string]string{"type": "nfs", "server": "nfs1", "path": "/exports/pv/ns1-pvc-1-vol"},
+	}
+	_, err = node.NodePublishVolume(ctx, req)
+	checkTestResults(t, "nfs volume is published", nil, err)
+	checkTestResults(t, "nfs mount", "nfs nfs1:/exports/pv/ns1-pvc-1-vol nfsvers=4.1,ro", mounter.mounts[target])
+	_, err = node.NodePublishVolume(ctx, req)
+	checkTestResults(t, "publishing is idempotent", nil, err)
+
+	_, err = node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: req.VolumeId, TargetPath: target})
+	checkTestResults(t, "volume is unpublished", nil, err)
+	checkTestResults(t, "volume is unmounted", 0, len(mounter.mounts))
+	_, err = os.Stat(target)
+	checkTestResults(t, "target path is removed", true, os.IsNotExist(err))
+	_, err = node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: req.VolumeId, TargetPath: target})
+	checkTestResults(t, "unpublishing is idempotent", nil, err)
+
+	req.VolumeContext = map[string]string{"type": "hostPath", "path": "/mnt/pv/pvc-2-vol"}
+	_, err = node.NodePublishVolume(ctx, req)
+	checkTestResults(t, "hostPath volume is published", nil, err)
+	checkTestResults(t, "read only bind mount", " /mnt/pv/pvc-2-vol remount,bind,ro", mounter.mounts[target])
+
+	req.VolumeContext = map[string]string{"type": "smb", "path": "//server/share"}
+	req.TargetPath = filepath.Join(dir, "other")
+	_, err = node.NodePublishVolume(ctx, req)
+	checkTestResults(t, "unknown volume type", codes.InvalidArgument, codeOf(err))
+
+	info, err := node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	checkTestResults(t, "node ID", "node1", info.GetNodeId())
+}
+
+func Test_unescapeOctal(t *testing.T) {
+	checkTestResults(t, "plain path", "/var/lib/kubelet", unescapeOctal("/var/lib/kubelet"))
+	checkTestResults(t, "escaped space", "/mnt/my dir", unescapeOctal(`/mnt/my\040dir`))
+	checkTestResults(t, "trailing backslash", `/mnt/a\`, unescapeOctal(`/mnt/a\`))
+}
+
+func Test_isMountPoint(t *testing.T) {
+	file, err := ioutil.TempFile("", "mountinfo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("36 35 98:0 / /mnt/my\\040dir rw,noatime master:1 - ext3 /dev/root rw\n")
+	file.Close()
+
+	defer func(value string) { mountInfoPath = value }(mountInfoPath)
+	mountInfoPath = file.Name()
+	mounted, err := commandMounter{}.IsMountPoint("/mnt/my dir")
+	checkTestResults(t, "mount point is found", true, mounted && err == nil)
+	mounted, err = commandMounter{}.IsMountPoint("/mnt")
+	checkTestResults(t, "parent is not mount point", false, mounted || err != nil)
+}
+
+func Test_identityOverSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-socket-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "csi.sock")
+
+	d := NewDriver(Options{Name: "csi.pv.provisioner", Version: "test", NodeID: "node1", Node: true}, nil)
+	go d.Run("unix://" + socket)
+	defer d.Stop()
+
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := csi.NewIdentityClient(conn)
+	var info *csi.GetPluginInfoResponse
+	//The socket might not be listened yet
+	for info == nil && ctx.Err() == nil {
+		info, err = client.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}, grpc.WaitForReady(true))
+	}
+	checkTestResults(t, "plugin info is served", nil, err)
+	checkTestResults(t, "plugin name", "csi.pv.provisioner", info.GetName())
+	probe, err := client.Probe(ctx, &csi.ProbeRequest{})
+	checkTestResults(t, "node service is ready", true, err == nil && probe.GetReady().GetValue())
+	capabilities, err := client.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+	checkTestResults(t, "node only driver has no controller service", 1, len(capabilities.GetCapabilities()))
+
+	_, err = csi.NewControllerClient(conn).CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-1"})
+	checkTestResults(t, "controller service is not served", codes.Unimplemented, codeOf(err))
+}
@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"context"
+
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+//identityServer is the identity service of the driver
+type identityServer struct {
+	csi.UnimplementedIdentityServer
+	driver *Driver
+}
+
+//GetPluginInfo is implementation of csi.IdentityServer.GetPluginInfo
+func (s *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: s.driver.options.Name, VendorVersion: s.driver.options.Version}, nil
+}
+
+//GetPluginCapabilities is implementation of csi.IdentityServer.GetPluginCapabilities
+func (s *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	capabilities := []*csi.PluginCapability{{
+		//The directories do not have size, so they are "expanded" without the node service
+		Type: &csi.PluginCapability_VolumeExpansion_{
+			VolumeExpansion: &csi.PluginCapability_VolumeExpansion{Type: csi.PluginCapability_VolumeExpansion_ONLINE},
+		},
+	}}
+	if s.driver.options.Controller {
+		capabilities = append(capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{Type: csi.PluginCapability_Service_CONTROLLER_SERVICE},
+			},
+		})
+	}
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: capabilities}, nil
+}
+
+//Probe is implementation of csi.IdentityServer.Probe, the controller service is ready when the storage asset roots are writable
+func (s *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if s.driver.options.Controller {
+		if err := storage.CheckStorageAssetRoots(); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(true)}, nil
+}
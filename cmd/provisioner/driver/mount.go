@@ -0,0 +1,99 @@
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Mounter mounts the volumes on the node
+type Mounter interface {
+	//Mount mounts the source to the target, empty fsType means the type is not passed to mount command
+	Mount(source, target, fsType string, options []string) error
+	//Unmount unmounts the target
+	Unmount(target string) error
+	//IsMountPoint reports whether something is mounted to the target
+	IsMountPoint(target string) (bool, error)
+}
+
+//runCommand runs the external command returning its combined output
+var runCommand = defaultRunCommand
+
+func defaultRunCommand(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+//mountInfoPath is the file listing the mounts of the driver, see proc(5)
+var mountInfoPath = "/proc/self/mountinfo"
+
+//commandMounter is the Mounter running mount and umount commands
+type commandMounter struct{}
+
+//Mount is implementation of Mounter.Mount
+func (commandMounter) Mount(source, target, fsType string, options []string) error {
+	args := make([]string, 0, 6)
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, source, target)
+	if output, err := runCommand("mount", args...); err != nil {
+		return fmt.Errorf("Mounting of: %v to: %v failed: %v: %v", source, target, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+//Unmount is implementation of Mounter.Unmount
+func (commandMounter) Unmount(target string) error {
+	if output, err := runCommand("umount", target); err != nil {
+		return fmt.Errorf("Unmounting of: %v failed: %v: %v", target, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+//IsMountPoint is implementation of Mounter.IsMountPoint
+func (commandMounter) IsMountPoint(target string) (bool, error) {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		//The fifth field is the mount point having the spaces and other special characters escaped as octal numbers
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && unescapeOctal(fields[4]) == target {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func unescapeOctal(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var result strings.Builder
+	for index := 0; index < len(value); index++ {
+		if value[index] == '\\' && index+3 < len(value) {
+			if code, err := strconv.ParseUint(value[index+1:index+4], 8, 8); err == nil {
+				result.WriteByte(byte(code))
+				index += 3
+				continue
+			}
+		}
+		result.WriteByte(value[index])
+	}
+	return result.String()
+}
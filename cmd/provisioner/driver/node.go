@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"context"
+	"os"
+
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//nodeServer is the node service of the driver mounting the volumes to the target paths of the pods
+type nodeServer struct {
+	csi.UnimplementedNodeServer
+	driver *Driver
+}
+
+/*NodePublishVolume is implementation of csi.NodeServer.NodePublishVolume. The hostPath volumes are bind-mounted from the node,
+the nfs ones are mounted from the server with the mount options of the storage class*/
+func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is required")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability is required")
+	}
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return nil, status.Error(codes.InvalidArgument, "Block volumes are not supported")
+	}
+	volumeContext := req.GetVolumeContext()
+	source := volumeContext[contextPath]
+	if source == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume context of volume: %v does not have: %v", req.GetVolumeId(), contextPath)
+	}
+
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	mounted, err := s.driver.mounter.IsMountPoint(target)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	//The volume published by the previous call is not mounted again
+	if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	log := s.driver.log.With(logging.Fields{"volumeId": req.GetVolumeId()}).WithAssetPath(source)
+	switch volumeContext[contextType] {
+	case config.VolumeTypeHostPath:
+		err = s.driver.mounter.Mount(source, target, "", []string{"bind"})
+		//The read only bind mount has to be remounted, the initial mount ignores the ro option
+		if err == nil && req.GetReadonly() {
+			if err = s.driver.mounter.Mount(source, target, "", []string{"remount", "bind", "ro"}); err != nil {
+				s.driver.mounter.Unmount(target)
+			}
+		}
+	case config.VolumeTypeNFS:
+		options := append([]string{}, req.GetVolumeCapability().GetMount().GetMountFlags()...)
+		if req.GetReadonly() {
+			options = append(options, "ro")
+		}
+		err = s.driver.mounter.Mount(volumeContext[contextServer]+":"+source, target, "nfs", options)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Volume type: %v of volume: %v is not supported", volumeContext[contextType], req.GetVolumeId())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.V(logging.LevelChange).Infof("Volume: %v was mounted to: %v", req.GetVolumeId(), target)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+//NodeUnpublishVolume is implementation of csi.NodeServer.NodeUnpublishVolume, the target path is removed after unmounting
+func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "Target path is required")
+	}
+
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	mounted, err := s.driver.mounter.IsMountPoint(target)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if mounted {
+		if err := s.driver.mounter.Unmount(target); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	s.driver.log.V(logging.LevelChange).Infof("Volume: %v was unmounted from: %v", req.GetVolumeId(), target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+//NodeGetCapabilities is implementation of csi.NodeServer.NodeGetCapabilities, the volumes are not staged
+func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+//NodeGetInfo is implementation of csi.NodeServer.NodeGetInfo
+func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: s.driver.options.NodeID}, nil
+}
@@ -0,0 +1,69 @@
+/*Package failures classifies the errors of handling PVCs and PVs. The permanent errors are not going to disappear by
+themselves therefore retrying them is useless. The postponed errors mean the handling could not be started at the moment and
+they are retried without spending the retry budget of the item. The waiting errors mean the item waits for a change of the
+cluster, e.g. for the budget, it is retried with growing delays without spending the retry budget as well. All other errors
+are transient*/
+package failures
+
+import (
+	"fmt"
+)
+
+type permanentError struct {
+	error
+}
+
+type postponedError struct {
+	error
+}
+
+type waitingError struct {
+	error
+}
+
+/*Permanent is the func wrapping the error in order to mark it as permanent one*/
+func Permanent(err error) error {
+	if err == nil || IsPermanent(err) {
+		return err
+	}
+	return permanentError{err}
+}
+
+/*Permanentf is the func returning new permanent error formatted like fmt.Errorf does*/
+func Permanentf(format string, args ...interface{}) error {
+	return permanentError{fmt.Errorf(format, args...)}
+}
+
+/*IsPermanent is the func returning true if the error is marked as permanent one*/
+func IsPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+/*Postponed is the func wrapping the error in order to mark it as postponed one*/
+func Postponed(err error) error {
+	if err == nil || IsPostponed(err) {
+		return err
+	}
+	return postponedError{err}
+}
+
+/*IsPostponed is the func returning true if the error is marked as postponed one*/
+func IsPostponed(err error) bool {
+	_, ok := err.(postponedError)
+	return ok
+}
+
+/*Waiting is the func wrapping the error in order to mark it as waiting one*/
+func Waiting(err error) error {
+	if err == nil || IsWaiting(err) {
+		return err
+	}
+	return waitingError{err}
+}
+
+/*IsWaiting is the func returning true if the error is marked as waiting one*/
+func IsWaiting(err error) bool {
+	_, ok := err.(waitingError)
+	return ok
+}
@@ -0,0 +1,26 @@
+package failures
+
+import (
+	"errors"
+	"testing"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+func TestClassification(t *testing.T) {
+	err := errors.New("some error")
+
+	checkTestResults(t, "plain error is not permanent", false, IsPermanent(err))
+	checkTestResults(t, "plain error is not postponed", false, IsPostponed(err))
+	checkTestResults(t, "permanent error", true, IsPermanent(Permanent(err)))
+	checkTestResults(t, "permanent error keeps message", "some error", Permanent(err).Error())
+	checkTestResults(t, "formatted permanent error", true, IsPermanent(Permanentf("some %v", "error")))
+	checkTestResults(t, "permanent error is wrapped once", Permanent(err), Permanent(Permanent(err)))
+	checkTestResults(t, "postponed error", true, IsPostponed(Postponed(err)))
+	checkTestResults(t, "postponed error is not permanent", false, IsPermanent(Postponed(err)))
+	checkTestResults(t, "nil is not wrapped", nil, Permanent(nil))
+}
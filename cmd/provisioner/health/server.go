@@ -0,0 +1,143 @@
+package health
+
+import (
+	"bytes"
+	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+//Check is the func returning nil if the checked part of the app is OK or the error describing the problem otherwise
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+/*Server is the HTTP server exposing /healthz and /readyz endpoints and optionally /debug/pprof ones*/
+type Server struct {
+	address         string
+	mux             *http.ServeMux
+	locker          sync.RWMutex
+	livenessChecks  []namedCheck
+	readinessChecks []namedCheck
+	log             *logging.Logger
+}
+
+/*NewServer is the func which is like a constructor. The pprof endpoints are registered only if enablePprof is true*/
+func NewServer(address string, enablePprof bool) *Server {
+	s := &Server{
+		address: address,
+		mux:     http.NewServeMux(),
+		log:     logging.New(logging.Fields{logging.KeyController: "HealthServer"}),
+	}
+
+	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, s.checks(false))
+	})
+	//The readiness checks might be excluded by name like in kube-apiserver, e.g. /readyz?exclude=leader
+	s.mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		s.serveChecks(w, excludeChecks(s.checks(true), r.URL.Query()["exclude"]))
+	})
+
+	if enablePprof {
+		s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+		s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return s
+}
+
+/*AddLivenessCheck is the method adding the check to /healthz endpoint*/
+func (s *Server) AddLivenessCheck(name string, check Check) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.livenessChecks = append(s.livenessChecks, namedCheck{name, check})
+}
+
+/*AddReadinessCheck is the method adding the check to /readyz endpoint*/
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.readinessChecks = append(s.readinessChecks, namedCheck{name, check})
+}
+
+/*Handle is the method registering additional handler on the server*/
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+/*Start is the method launching the server in background. The app exits if the server could not listen the address*/
+func (s *Server) Start() {
+	go func() {
+		s.log.Infof("Starting HTTP server on: %v", s.address)
+		if err := http.ListenAndServe(s.address, s.mux); err != nil {
+			s.log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+}
+
+/*checks returns the readiness checks including liveness ones, because the app which is not alive is not ready as well, or
+the liveness checks only*/
+func (s *Server) checks(readiness bool) []namedCheck {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+
+	result := make([]namedCheck, 0, len(s.livenessChecks)+len(s.readinessChecks))
+	result = append(result, s.livenessChecks...)
+	if readiness {
+		result = append(result, s.readinessChecks...)
+	}
+	return result
+}
+
+/*excludeChecks returns the checks except for the ones having the names*/
+func excludeChecks(checks []namedCheck, names []string) []namedCheck {
+	if len(names) == 0 {
+		return checks
+	}
+
+	result := make([]namedCheck, 0, len(checks))
+	for _, item := range checks {
+		excluded := false
+		for _, name := range names {
+			if item.name == name {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func (s *Server) serveChecks(w http.ResponseWriter, checks []namedCheck) {
+	body := new(bytes.Buffer)
+	failed := false
+
+	for _, item := range checks {
+		if err := item.check(); err != nil {
+			failed = true
+			fmt.Fprintf(body, "[-]%v failed: %v\n", item.name, err)
+			s.log.V(logging.LevelDecision).Infof("Check: %v failed: %v", item.name, err)
+		} else {
+			fmt.Fprintf(body, "[+]%v ok\n", item.name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if failed {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	body.WriteTo(w)
+}
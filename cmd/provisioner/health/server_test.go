@@ -0,0 +1,52 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+func request(s *Server, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func TestServer_checks(t *testing.T) {
+	ready := errors.New("informer is not synced")
+
+	s := NewServer(":0", false)
+	s.AddLivenessCheck("alive", func() error { return nil })
+	s.AddReadinessCheck("synced", func() error { return ready })
+
+	response := request(s, "/healthz")
+	checkTestResults(t, "/healthz status", http.StatusOK, response.Code)
+
+	response = request(s, "/readyz")
+	checkTestResults(t, "/readyz status when not ready", http.StatusInternalServerError, response.Code)
+	checkTestResults(t, "/readyz body has reason", true, strings.Contains(response.Body.String(), "[-]synced failed: informer is not synced"))
+	checkTestResults(t, "/readyz body has liveness check", true, strings.Contains(response.Body.String(), "[+]alive ok"))
+
+	response = request(s, "/readyz?exclude=synced")
+	checkTestResults(t, "/readyz status with excluded check", http.StatusOK, response.Code)
+	checkTestResults(t, "/readyz body has no excluded check", false, strings.Contains(response.Body.String(), "synced"))
+
+	ready = nil
+	response = request(s, "/readyz")
+	checkTestResults(t, "/readyz status when ready", http.StatusOK, response.Code)
+
+	response = request(s, "/debug/pprof/")
+	checkTestResults(t, "pprof is disabled", http.StatusNotFound, response.Code)
+
+	s = NewServer(":0", true)
+	response = request(s, "/debug/pprof/")
+	checkTestResults(t, "pprof is enabled", http.StatusOK, response.Code)
+}
@@ -0,0 +1,246 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+//Level is verbosity level of log line. The line is written only if the --v flag is equal or greater than its level
+type Level int
+
+const (
+	//LevelInfo is level of lines which are always written: start and stop of the app, provisioned and deleted objects
+	LevelInfo Level = iota
+	//LevelChange is level of lines about changes made by the provisioner on file system and in the cluster
+	LevelChange
+	//LevelDecision is level of lines explaining why an object is handled or skipped by the provisioner
+	LevelDecision
+	//LevelDebug is level of lines dumping whole objects and other details
+	LevelDebug
+)
+
+const (
+	//KeyController is the key of the controller name handling the object
+	KeyController = "controller"
+	//KeyNamespace is the key of the namespace of a PVC
+	KeyNamespace = "namespace"
+	//KeyPVC is the key of the name of a PVC
+	KeyPVC = "pvc"
+	//KeyPV is the key of the name of a PV
+	KeyPV = "pv"
+	//KeyStorageClass is the key of the storage class name of a PVC or a PV
+	KeyStorageClass = "storageClass"
+	//KeyAssetPath is the key of the path of a storage asset
+	KeyAssetPath = "assetPath"
+	//KeyOperationID is the key of the id which is unique for each handling of the object by a controller
+	KeyOperationID = "operationId"
+	//KeyObject is the key of the kind and the name of an object other than a PVC or a PV, e.g. the one an event is about
+	KeyObject = "object"
+	//KeyPlan is the key of the change which would be made by the provisioner if it did not run in the dry-run mode
+	KeyPlan = "plan"
+)
+
+const (
+	//FormatText is the format of log lines produced by klog with structured keys appended as key=value pairs
+	FormatText = "text"
+	//FormatJSON is the format of log lines where each line is JSON object having the structured keys
+	FormatJSON = "json"
+)
+
+type severity string
+
+const (
+	severityInfo    severity = "info"
+	severityWarning severity = "warning"
+	severityError   severity = "error"
+	severityFatal   severity = "fatal"
+)
+
+var (
+	format           = FormatText
+	jsonOutput       io.Writer = os.Stderr
+	jsonOutputLocker sync.Mutex
+)
+
+/*SetFormat is the func choosing format of log lines. It should be called once before any logging*/
+func SetFormat(value string) error {
+	switch value {
+	case FormatText, FormatJSON:
+		format = value
+		return nil
+	default:
+		return fmt.Errorf("Unknown log format: %v, supported ones are: %v, %v", value, FormatText, FormatJSON)
+	}
+}
+
+//Fields is the set of structured keys which is attached to each line written by a Logger
+type Fields map[string]string
+
+/*Logger is the logger having structured keys. The nil Logger is valid and writes lines without any keys*/
+type Logger struct {
+	fields Fields
+}
+
+/*New is the func creating Logger with the given fields*/
+func New(fields Fields) *Logger {
+	logger := &Logger{fields: make(Fields, len(fields))}
+	for key, value := range fields {
+		logger.fields[key] = value
+	}
+	return logger
+}
+
+/*With is the method returning new Logger having keys of the current one and the given fields. Empty values are skipped*/
+func (l *Logger) With(fields Fields) *Logger {
+	result := New(l.Fields())
+	for key, value := range fields {
+		if value != "" {
+			result.fields[key] = value
+		}
+	}
+	return result
+}
+
+/*Fields is the method returning copy of the structured keys of the logger*/
+func (l *Logger) Fields() Fields {
+	result := make(Fields)
+	if l == nil {
+		return result
+	}
+	for key, value := range l.fields {
+		result[key] = value
+	}
+	return result
+}
+
+/*NewOperationID is the func generating random id of an operation*/
+func NewOperationID() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", buf)
+}
+
+//Verbose is the logger writing lines only if the verbosity level is enabled
+type Verbose struct {
+	logger  *Logger
+	enabled bool
+}
+
+/*V is the method returning Verbose logger for the level*/
+func (l *Logger) V(level Level) Verbose {
+	return Verbose{logger: l, enabled: bool(klog.V(klog.Level(level)))}
+}
+
+/*Enabled is the method returning whether the lines of the level are written*/
+func (v Verbose) Enabled() bool {
+	return v.enabled
+}
+
+/*Infof is the method writing the info line if the level is enabled*/
+func (v Verbose) Infof(messageFmt string, args ...interface{}) {
+	if v.enabled {
+		v.logger.output(severityInfo, messageFmt, args...)
+	}
+}
+
+/*Infof is the method writing the info line*/
+func (l *Logger) Infof(messageFmt string, args ...interface{}) {
+	l.output(severityInfo, messageFmt, args...)
+}
+
+/*Warningf is the method writing the warning line*/
+func (l *Logger) Warningf(messageFmt string, args ...interface{}) {
+	l.output(severityWarning, messageFmt, args...)
+}
+
+/*Errorf is the method writing the error line*/
+func (l *Logger) Errorf(messageFmt string, args ...interface{}) {
+	l.output(severityError, messageFmt, args...)
+}
+
+/*Fatalf is the method writing the fatal line and exiting the app*/
+func (l *Logger) Fatalf(messageFmt string, args ...interface{}) {
+	l.output(severityFatal, messageFmt, args...)
+}
+
+//callerDepth is number of stack frames between the caller of the Logger and the output method
+const callerDepth = 2
+
+func (l *Logger) output(sev severity, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	fields := l.Fields()
+
+	if format == FormatJSON {
+		writeJSON(sev, message, fields)
+		if sev == severityFatal {
+			os.Exit(255)
+		}
+		return
+	}
+
+	line := message + formatFields(fields)
+	switch sev {
+	case severityInfo:
+		klog.InfoDepth(callerDepth, line)
+	case severityWarning:
+		klog.WarningDepth(callerDepth, line)
+	case severityError:
+		klog.ErrorDepth(callerDepth, line)
+	case severityFatal:
+		klog.FatalDepth(callerDepth, line)
+	}
+}
+
+/*formatFields returns the fields as sorted key=value pairs prepended by space*/
+func formatFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for index, key := range keys {
+		pairs[index] = fmt.Sprintf("%v=%q", key, fields[key])
+	}
+	return " " + strings.Join(pairs, " ")
+}
+
+func writeJSON(sev severity, message string, fields Fields) {
+	record := make(map[string]string, len(fields)+4)
+	for key, value := range fields {
+		record[key] = value
+	}
+	record["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	record["level"] = string(sev)
+	record["msg"] = message
+	if _, file, line, ok := runtime.Caller(callerDepth + 1); ok {
+		record["caller"] = fmt.Sprintf("%v:%v", filepath.Base(file), line)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	jsonOutputLocker.Lock()
+	defer jsonOutputLocker.Unlock()
+	jsonOutput.Write(append(data, '\n'))
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+func Test_formatFields(t *testing.T) {
+	checkTestResults(t, "empty fields", "", formatFields(Fields{}))
+	checkTestResults(t, "sorted fields", ` namespace="ns1" pvc="pvc1"`, formatFields(Fields{KeyPVC: "pvc1", KeyNamespace: "ns1"}))
+}
+
+func Test_With(t *testing.T) {
+	var nilLogger *Logger
+	log := nilLogger.With(Fields{KeyController: "PersistentVolumeClaim", KeyPV: ""})
+	checkTestResults(t, "value of the key", "PersistentVolumeClaim", log.Fields()[KeyController])
+	checkTestResults(t, "empty value is skipped", 1, len(log.Fields()))
+
+	storageClassName := "storageClass1"
+	pvc := new(core_v1.PersistentVolumeClaim)
+	pvc.Namespace = "ns1"
+	pvc.Name = "pvc1"
+	pvc.Spec.StorageClassName = &storageClassName
+
+	pvcLog := log.WithPVC(pvc)
+	checkTestResults(t, "controller key is inherited", "PersistentVolumeClaim", pvcLog.Fields()[KeyController])
+	checkTestResults(t, "namespace key", "ns1", pvcLog.Fields()[KeyNamespace])
+	checkTestResults(t, "storage class key", "storageClass1", pvcLog.Fields()[KeyStorageClass])
+	checkTestResults(t, "parent logger is not changed", 1, len(log.Fields()))
+}
+
+func Test_ObjectFields(t *testing.T) {
+	checkTestResults(t, "PVC keys", ` namespace="ns1" pvc="pvc1"`, formatFields(ObjectFields("PersistentVolumeClaim", "ns1", "pvc1")))
+	checkTestResults(t, "PV keys", ` pv="pv1"`, formatFields(ObjectFields("PersistentVolume", "", "pv1")))
+	checkTestResults(t, "other object keys", ` namespace="ns1" object="Pod/pod1"`, formatFields(ObjectFields("Pod", "ns1", "pod1")))
+}
+
+func Test_JSONFormat(t *testing.T) {
+	//The package globals are restored for the other tests regardless of their order
+	defer func(output io.Writer, previous string) {
+		jsonOutput, format = output, previous
+	}(jsonOutput, format)
+	buf := new(bytes.Buffer)
+	jsonOutput = buf
+	SetFormat(FormatJSON)
+
+	New(Fields{KeyPVC: "pvc1"}).Warningf("Some %v", "message")
+
+	record := make(map[string]string)
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Line is not valid JSON: %v", buf.String())
+	}
+	checkTestResults(t, "message", "Some message", record["msg"])
+	checkTestResults(t, "level", "warning", record["level"])
+	checkTestResults(t, "structured key", "pvc1", record[KeyPVC])
+	checkTestResults(t, "caller", true, strings.HasPrefix(record["caller"], "logging_test.go:"))
+
+	if err := SetFormat("xml"); err == nil {
+		t.Error("Unknown format must be refused")
+	}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	core_v1 "k8s.io/api/core/v1"
+)
+
+/*WithPVC is the method returning new Logger having keys of the PVC: namespace, name and storage class*/
+func (l *Logger) WithPVC(pvc *core_v1.PersistentVolumeClaim) *Logger {
+	fields := Fields{
+		KeyNamespace: pvc.Namespace,
+		KeyPVC:       pvc.Name,
+		KeyPV:        pvc.Spec.VolumeName,
+	}
+	if pvc.Spec.StorageClassName != nil {
+		fields[KeyStorageClass] = *pvc.Spec.StorageClassName
+	}
+	return l.With(fields)
+}
+
+/*WithPV is the method returning new Logger having keys of the PV: name, storage class and the claim it is bound to*/
+func (l *Logger) WithPV(pv *core_v1.PersistentVolume) *Logger {
+	fields := Fields{
+		KeyPV:           pv.Name,
+		KeyStorageClass: pv.Spec.StorageClassName,
+	}
+	if pv.Spec.ClaimRef != nil {
+		fields[KeyNamespace] = pv.Spec.ClaimRef.Namespace
+		fields[KeyPVC] = pv.Spec.ClaimRef.Name
+	}
+	return l.With(fields)
+}
+
+/*WithAssetPath is the method returning new Logger having the key of the storage asset path*/
+func (l *Logger) WithAssetPath(assetPath string) *Logger {
+	return l.With(Fields{KeyAssetPath: assetPath})
+}
+
+/*ObjectFields is the function returning the keys of the object of the kind, a PV and a PVC have their own keys, the others are named by the kind and the name*/
+func ObjectFields(kind, namespace, name string) Fields {
+	fields := Fields{}
+	if namespace != "" {
+		fields[KeyNamespace] = namespace
+	}
+	switch kind {
+	case "PersistentVolume":
+		fields[KeyPV] = name
+	case "PersistentVolumeClaim":
+		fields[KeyPVC] = name
+	default:
+		fields[KeyObject] = kind + "/" + name
+	}
+	return fields
+}
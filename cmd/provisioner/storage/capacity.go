@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+
+	"k8s-pv-provisioner/cmd/provisioner/config"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//insufficientCapacityError is returned by CheckCapacity if the claim does not fit into the free space of its storage class
+type insufficientCapacityError struct {
+	error
+}
+
+/*IsInsufficientCapacity is the func returning true if the error means the claim does not fit into the free space*/
+func IsInsufficientCapacity(err error) bool {
+	_, ok := err.(insufficientCapacityError)
+	return ok
+}
+
+/*NeedsCapacity is the func returning true if the storage requested by the PVC is checked against the allocatable capacity of its
+storage class. Nothing is checked unless the storage class has capacityAdmission parameter. The PVC reusing existing storage
+asset does not need any space*/
+func NeedsCapacity(pvc *core_v1.PersistentVolumeClaim) bool {
+	currentStorageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
+	if !currentStorageClass.Capacity.Enabled {
+		return false
+	}
+	if value, ok := pvc.Annotations[config.AnnotationUseExistingAsset]; ok && checkMatchTrueStr(value) {
+		if exists, _ := appConfig.Backend.Exists(path.Join(appConfig.StorageAssetRoot, currentStorageClass.Name, assetNameOf(pvc))); exists {
+			return false
+		}
+	}
+	return true
+}
+
+/*FileSystemCapacity is func returning the size and the available space in bytes of the file system of the storage class. The file
+system is the one of the directory of the storage class under the storage asset root, or of the root itself if the directory
+does not exist yet. The directory of the storage class served by agent must exist*/
+func FileSystemCapacity(className string) (int64, int64, error) {
+	currentStorageClass := appConfig.StorageClasses[className]
+	dir := path.Join(appConfig.StorageAssetRoot, className)
+	if exists, _ := appConfig.Backend.Exists(dir); !exists && currentStorageClass.AgentURL == "" {
+		dir = appConfig.StorageAssetRoot
+	}
+
+	total, available, err := appConfig.Backend.Capacity(dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not get capacity of storage class: %v: %v", className, err)
+	}
+	return total, available, nil
+}
+
+/*committedCapacity returns the func summing up the capacity of the PVs of the storage class whatever their phase is, their
+storage assets exist until the PVs are deleted. The func reads the cache of PVs, so it is called while the reservations are locked
+and the PV replacing the dropped reservation is not missed. The CSI driver has no cache, the PVs are listed by the API beforehand*/
+func committedCapacity(className string) (func() int64, error) {
+	if appConfig.PersistentVolumes != nil {
+		return func() int64 { return capacityOf(appConfig.PersistentVolumes.List(), className) }, nil
+	}
+	if appConfig.Clientset == nil {
+		return func() int64 { return 0 }, nil
+	}
+
+	list, err := appConfig.Clientset.CoreV1().PersistentVolumes().List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not list PVs of storage class: %v: %v", className, err)
+	}
+	objects := make([]interface{}, 0, len(list.Items))
+	for i := range list.Items {
+		objects = append(objects, &list.Items[i])
+	}
+	committed := capacityOf(objects, className)
+	return func() int64 { return committed }, nil
+}
+
+//capacityOf returns total capacity in bytes of the PVs of the storage class provisioned by its provisioner
+func capacityOf(objects []interface{}, className string) int64 {
+	var total int64
+	provisioner := appConfig.StorageClasses[className].Provisioner
+	for _, obj := range objects {
+		pv := obj.(*core_v1.PersistentVolume)
+		if pv.Spec.StorageClassName != className || pv.Annotations[config.AnnotationProvisionedBy] != provisioner {
+			continue
+		}
+		capacity := pv.Spec.Capacity[core_v1.ResourceStorage]
+		total += capacity.Value()
+	}
+	return total
+}
+
+/*AllocatableCapacity is func returning the storage in bytes which might be requested by new claims of the storage class. The
+storage committed by the PVs of the storage class and reserved for the PVCs which PVs are not in the cache yet is not allocatable*/
+func AllocatableCapacity(className string) (int64, error) {
+	total, available, err := FileSystemCapacity(className)
+	if err != nil {
+		return 0, err
+	}
+	committed, err := committedCapacity(className)
+	if err != nil {
+		return 0, err
+	}
+
+	reservations.Lock()
+	defer reservations.Unlock()
+
+	dropProvisionedReservations()
+	return appConfig.StorageClasses[className].Capacity.Allocatable(total, available, committed()+reservedStorage("", className, "")), nil
+}
+
+/*AdmitCapacity is func returning error if the storage requested by the PVC does not fit into the allocatable capacity of the file
+system of its storage class having the size and the available space, which are got by FileSystemCapacity before. The storage
+is reserved for the PVC if it fits, so the PVCs handled simultaneously do not exceed the capacity*/
+func AdmitCapacity(pvc *core_v1.PersistentVolumeClaim, total, available int64) error {
+	className := *pvc.Spec.StorageClassName
+	committed, err := committedCapacity(className)
+	if err != nil {
+		return err
+	}
+
+	return AdmitStorage(pvc, func(reserved func(namespace string) int64) error {
+		allocatable := appConfig.StorageClasses[className].Capacity.Allocatable(total, available, committed()+reserved(""))
+		requested := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+		if requested.Value() > allocatable {
+			return insufficientCapacityError{fmt.Errorf("PersistentVolumeClaim: %v requests: %v while storage class: %v has only: %v allocatable", pvc.Name, requested.String(), className, resource.NewQuantity(allocatable, resource.BinarySI).String())}
+		}
+		return nil
+	})
+}
+
+/*CheckCapacity is func returning error if the storage requested by the PVC does not fit into the allocatable capacity of its
+storage class, the storage is reserved for the PVC if it fits. The errors getting the capacity are not insufficient capacity*/
+func CheckCapacity(pvc *core_v1.PersistentVolumeClaim) error {
+	if !NeedsCapacity(pvc) {
+		return nil
+	}
+	total, available, err := FileSystemCapacity(*pvc.Spec.StorageClassName)
+	if err != nil {
+		return err
+	}
+	return AdmitCapacity(pvc, total, available)
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"io"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+/*assetOwner is uid and gid which copied items get instead of the ones of the source items*/
+type assetOwner struct {
+	uid int
+	gid int
+}
+
+/*copyTree copies content of the src directory to the dst directory keeping permissions and modification time of each copied item.
+The ownership of the source items is kept as well if the owner is nil. If the dst directory already exists its own attributes
+are left untouched. Every time a portion of data is copied, the progress func is called with number of bytes copied so far, the
+copying is stopped if it returns error*/
+func copyTree(log *logging.Logger, src, dst string, owner *assetOwner, progress func(copied int64) error) error {
+	var copied int64
+
+	type copiedDir struct {
+		path string
+		info os.FileInfo
+	}
+	dirs := make([]copiedDir, 0)
+
+	err := filepath.Walk(src, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		dstPath := filepath.Join(dst, relPath)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir() && relPath == "." && isExistingDir(dstPath):
+			return nil
+		case mode.IsDir():
+			//The directory must stay writable until its content is copied, the attributes are applied later
+			if err := os.Mkdir(dstPath, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, copiedDir{dstPath, info})
+			return nil
+		case mode.IsRegular():
+			written, err := copyFile(srcPath, dstPath, mode.Perm(), func(n int64) error {
+				copied += n
+				if progress != nil {
+					return progress(copied)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			log.V(logging.LevelDebug).Infof("Copied file: %v to %v (%v bytes)", srcPath, dstPath, written)
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(srcPath)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, dstPath); err != nil {
+				return err
+			}
+		default:
+			log.Warningf("Skipping copy of special file: %v", srcPath)
+			return nil
+		}
+
+		return copyAttributes(dstPath, info, owner)
+	})
+	if err != nil {
+		return err
+	}
+
+	//Nested directories go first in order to not change modification time of the parent ones afterwards
+	for index := len(dirs) - 1; index >= 0; index-- {
+		if err := copyAttributes(dirs[index].path, dirs[index].info, owner); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*copyFile copies the regular file keeping it sparse: the blocks of zeros are not written but skipped, so they stay holes in the
+copy like in the source, e.g. in the image storage assets. The copying is stopped if the progress func returns error*/
+func copyFile(src, dst string, perm os.FileMode, progress func(n int64) error) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	buf := make([]byte, 1024*1024)
+	for {
+		n, readErr := in.Read(buf)
+		if n > 0 {
+			if err := writeSparse(out, buf[:n]); err != nil {
+				out.Close()
+				return written, err
+			}
+			written += int64(n)
+			if err := progress(int64(n)); err != nil {
+				out.Close()
+				return written, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			out.Close()
+			return written, readErr
+		}
+	}
+
+	//The trailing hole is not written, the size is set explicitly
+	if err := out.Truncate(written); err != nil {
+		out.Close()
+		return written, err
+	}
+	return written, out.Close()
+}
+
+//sparseBlockSize is the size of the blocks of zeros which are skipped instead of being written
+const sparseBlockSize = 4096
+
+/*writeSparse writes the data at the current offset of the file, the blocks consisting of zeros are skipped by seeking*/
+func writeSparse(out *os.File, data []byte) error {
+	for len(data) > 0 {
+		size := sparseBlockSize
+		if size > len(data) {
+			size = len(data)
+		}
+		zero := isZero(data[:size])
+		//The adjacent blocks of the same kind are written or skipped at once
+		for size < len(data) {
+			next := size + sparseBlockSize
+			if next > len(data) {
+				next = len(data)
+			}
+			if isZero(data[size:next]) != zero {
+				break
+			}
+			size = next
+		}
+
+		if zero {
+			if _, err := out.Seek(int64(size), io.SeekCurrent); err != nil {
+				return err
+			}
+		} else if _, err := out.Write(data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func isZero(data []byte) bool {
+	for _, item := range data {
+		if item != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*copyAttributes sets ownership, permissions and modification time of the source item to the copied one. If the owner is not nil
+it is used instead of the ownership of the source item*/
+func copyAttributes(dstPath string, info os.FileInfo, owner *assetOwner) error {
+	if owner != nil {
+		if err := os.Lchown(dstPath, owner.uid, owner.gid); err != nil {
+			return err
+		}
+	} else if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dstPath, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+
+	//Symlinks do not have own permissions and modification time might not be set without following the link
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	//Chmod is needed after chown because the last one clears setuid and setgid bits
+	if err := os.Chmod(dstPath, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+
+	return os.Chtimes(dstPath, info.ModTime(), info.ModTime())
+}
+
+func isExistingDir(value string) bool {
+	info, err := os.Stat(value)
+	return err == nil && info.IsDir()
+}
+
+/*treeSize returns summary size of regular files under the root*/
+func treeSize(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
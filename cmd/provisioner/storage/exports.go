@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+/*exportOptionsOf returns the options of the export of the storage asset. The rw option is replaced by ro for the claim requesting
+read only access only*/
+func exportOptionsOf(options string, readOnly bool) string {
+	if !readOnly {
+		return options
+	}
+	items := strings.Split(options, ",")
+	result := []string{"ro"}
+	for _, item := range items {
+		if item != "rw" && item != "ro" && item != "" {
+			result = append(result, item)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+//ExportStorageAsset is func which exports the storage asset by the agent of the storage class of the PVC
+func ExportStorageAsset(log *logging.Logger, name, assetPath string, pvc *core_v1.PersistentVolumeClaim) error {
+	storageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
+
+	exporter, ok := appConfig.Backend.(backend.Exporter)
+	if !ok {
+		return fmt.Errorf("Storage asset: %v could not be exported by the backend", assetPath)
+	}
+	options := exportOptionsOf(storageClass.ExportOptions, isReadOnly(pvc.Spec.AccessModes))
+	if err := exporter.Export(name, assetPath, storageClass.ExportClients, options); err != nil {
+		return err
+	}
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully exported as: %v for: %v with options: %v", assetPath, name, strings.Join(storageClass.ExportClients, ","), options)
+	return nil
+}
+
+//UnexportStorageAsset is func which removes the export of the storage asset of the PV if it has been exported separately
+func UnexportStorageAsset(log *logging.Logger, pv *core_v1.PersistentVolume) error {
+	name, ok := pv.Annotations[config.AnnotationExport]
+	if !ok {
+		return nil
+	}
+
+	assetPath := AssetPathOf(pv)
+	exporter, ok := appConfig.Backend.(backend.Exporter)
+	if !ok {
+		return fmt.Errorf("Export: %v of storage asset: %v could not be removed by the backend", name, assetPath)
+	}
+	if err := exporter.Unexport(name, assetPath); err != nil {
+		return err
+	}
+	log.V(logging.LevelChange).Infof("Export: %v of storage asset: %v was successfully removed", name, assetPath)
+	return nil
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+//imageExtension is the suffix of the file name of the image storage asset
+const imageExtension = ".img"
+
+//runCommand runs the external command returning its combined output
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+/*mkfsCommand returns the command formatting the image file with the file system. The root directory of ext4 file system gets
+uid and gid as its owner, the one of xfs is owned by root*/
+func mkfsCommand(filesystem, imagePath string, uid, gid int) (string, []string) {
+	switch filesystem {
+	case "xfs":
+		return "mkfs.xfs", []string{"-q", imagePath}
+	default:
+		return "mkfs.ext4", []string{"-q", "-F", "-E", fmt.Sprintf("root_owner=%d:%d", uid, gid), imagePath}
+	}
+}
+
+/*CreateImageAsset is func which creates the sparse image file of the size in bytes and formats it with the file system unless
+it is ImageFilesystemNone. The image file is removed if the formatting failed*/
+func CreateImageAsset(log *logging.Logger, assetPath string, size int64, filesystem string, uid, gid int, reuseExisting bool) error {
+	if err := requireLocalBackend(assetPath); err != nil {
+		return err
+	}
+	if _, err := os.Stat(assetPath); err == nil {
+		if !reuseExisting {
+			return failures.Permanentf("Storage asset: %v already exists", assetPath)
+		}
+		log.V(logging.LevelChange).Infof("Storage asset: %v was successfully reused", assetPath)
+		return nil
+	}
+
+	if size <= 0 {
+		return failures.Permanentf("Storage asset: %v could not be created without requested storage size", assetPath)
+	}
+
+	file, err := os.OpenFile(assetPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	//The file gets its size without allocating blocks on the disk
+	err = file.Truncate(size)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chown(assetPath, uid, gid)
+	}
+	if err != nil {
+		os.Remove(assetPath)
+		return err
+	}
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully created with size: %v bytes and ownership: %v:%v", assetPath, size, uid, gid)
+
+	if filesystem == config.ImageFilesystemNone {
+		return nil
+	}
+
+	name, args := mkfsCommand(filesystem, assetPath, uid, gid)
+	if output, err := runCommand(name, args...); err != nil {
+		os.Remove(assetPath)
+		return fmt.Errorf("Formatting of storage asset: %v with %v failed: %v: %v", assetPath, filesystem, err, strings.TrimSpace(string(output)))
+	}
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully formatted with: %v", assetPath, filesystem)
+
+	return nil
+}
+
+const (
+	imageFilesystemExt4 = "ext4"
+	imageFilesystemXFS  = "xfs"
+)
+
+/*imageFilesystemOf returns the file system of the image file recognized by the magic number of its superblock, ImageFilesystemNone
+is returned for the unknown ones*/
+func imageFilesystemOf(imagePath string) (string, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	superblock := make([]byte, 1082)
+	n, err := io.ReadFull(file, superblock)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	superblock = superblock[:n]
+	switch {
+	case len(superblock) >= 4 && string(superblock[:4]) == "XFSB":
+		return imageFilesystemXFS, nil
+	case len(superblock) >= 1082 && binary.LittleEndian.Uint16(superblock[1080:]) == 0xEF53:
+		return imageFilesystemExt4, nil
+	}
+	return config.ImageFilesystemNone, nil
+}
+
+/*checkImageGrowable returns permanent error if the image file could not be grown to the size in bytes, the xfs file system
+could not be grown without mounting it*/
+func checkImageGrowable(imagePath string, size int64) error {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return err
+	}
+	if size <= info.Size() {
+		return nil
+	}
+	if filesystem, err := imageFilesystemOf(imagePath); err != nil {
+		return err
+	} else if filesystem == imageFilesystemXFS {
+		return failures.Permanentf("Image: %v with xfs file system could not be grown to: %v bytes, the requested size must not exceed the source one: %v bytes", imagePath, size, info.Size())
+	}
+	return nil
+}
+
+/*growImageAsset is func which extends the image file to the size in bytes if it is smaller, the ext4 file system of the image is
+resized to the whole image as well*/
+func growImageAsset(log *logging.Logger, imagePath string, size int64) error {
+	if err := checkImageGrowable(imagePath, size); err != nil {
+		return err
+	}
+	info, err := os.Stat(imagePath)
+	if err != nil || size <= info.Size() {
+		return err
+	}
+
+	filesystem, err := imageFilesystemOf(imagePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(imagePath, size); err != nil {
+		return err
+	}
+	if filesystem == imageFilesystemExt4 {
+		//resize2fs refuses the file system which has not been checked, the exit status 1 of e2fsck means the errors were fixed
+		if output, err := runCommand("e2fsck", "-f", "-p", imagePath); err != nil && !isExitStatus(err, 1) {
+			return fmt.Errorf("Check of image: %v failed: %v: %v", imagePath, err, strings.TrimSpace(string(output)))
+		}
+		if output, err := runCommand("resize2fs", imagePath); err != nil {
+			return fmt.Errorf("Resizing of file system of image: %v failed: %v: %v", imagePath, err, strings.TrimSpace(string(output)))
+		}
+	}
+	log.V(logging.LevelChange).Infof("Image: %v was grown from: %v to: %v bytes", imagePath, info.Size(), size)
+	return nil
+}
+
+func isExitStatus(err error, code int) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	return ok && exitErr.ExitCode() == code
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	snapshotAPIGroup = "snapshot.storage.k8s.io"
+	/*progressReportPeriod is how often the progress of population is reported by events*/
+	progressReportPeriod = 30 * time.Second
+)
+
+var (
+	volumeSnapshotResource        = schema.GroupVersionResource{Group: snapshotAPIGroup, Version: "v1", Resource: "volumesnapshots"}
+	volumeSnapshotContentResource = schema.GroupVersionResource{Group: snapshotAPIGroup, Version: "v1", Resource: "volumesnapshotcontents"}
+)
+
+/*ErrPopulationInProgress is returned by PreparePV while the storage asset is being populated from the data source in background*/
+var ErrPopulationInProgress = errors.New("population of storage asset is in progress")
+
+//errPopulationCanceled stops the copying of the storage asset which PVC has been deleted
+var errPopulationCanceled = errors.New("population of storage asset is canceled")
+
+/*PopulationFinished is called with the key of a PVC once background population of its storage asset is finished
+successfully or not. It allows to not wait for the next retry of the PVC handling*/
+var PopulationFinished func(pvcKey string)
+
+type population struct {
+	source string
+	//uid is the UID of the PVC which the storage asset is populated for, the PVC recreated with the same name does not get it
+	uid    types.UID
+	pvcKey string
+	//cancel is closed once the PVC is deleted, the copying is stopped then
+	cancel chan struct{}
+	//size is the requested size of the image which is grown to it once it is copied, it is 0 for directories
+	size   int64
+	copied int64
+	total  int64
+	done   bool
+	err    error
+}
+
+/*populations keeps track of background population of storage assets. The key is the path of the storage asset*/
+var populations = struct {
+	sync.Mutex
+	items map[string]*population
+}{items: make(map[string]*population)}
+
+/*stagingPathOf returns the path of the hidden directory where storage asset is populated before it gets its proper name.
+Thanks to that an interrupted population never leaves half-filled storage asset under the real name*/
+func stagingPathOf(assetPath string) string {
+	return path.Join(path.Dir(assetPath), fmt.Sprintf(".%v.populating", path.Base(assetPath)))
+}
+
+/*populateStorageAsset creates the storage asset by copying content of the PVC data source to it. Copying is performed in
+background therefore ErrPopulationInProgress is returned until it is finished. When the copying is done the storage asset
+gets its proper name and nil is returned*/
+func populateStorageAsset(log *logging.Logger, pvc *core_v1.PersistentVolumeClaim, assetPath string) error {
+	if err := requireLocalBackend(assetPath); err != nil {
+		return err
+	}
+	stagingPath := stagingPathOf(assetPath)
+	if finished, err := finishPopulation(log, pvc, assetPath, stagingPath); finished {
+		return err
+	}
+
+	if _, err := os.Stat(assetPath); err == nil {
+		return failures.Permanentf("Storage asset: %v already exists", assetPath)
+	}
+
+	//The data source is resolved by API calls which must not keep the other workers waiting for populations lock
+	source, err := resolveDataSource(pvc)
+	if err != nil {
+		return err
+	}
+
+	//The directory could not be copied to the image file and vice versa
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	isImage := appConfig.StorageClasses[*pvc.Spec.StorageClassName].AssetType == config.AssetTypeImage
+	if isImage == sourceInfo.IsDir() {
+		return failures.Permanentf("Data source: %v is not the same type of storage asset as storage class: %v provides", source, *pvc.Spec.StorageClassName)
+	}
+	//The image is grown to the requested size after copying, the ones which could not be grown are refused beforehand
+	var size int64
+	if isImage {
+		requested := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+		size = requested.Value()
+		if err := checkImageGrowable(source, size); err != nil {
+			return err
+		}
+	}
+
+	populations.Lock()
+	defer populations.Unlock()
+	if _, ok := populations.items[assetPath]; ok {
+		return ErrPopulationInProgress
+	}
+
+	//The leftovers of population interrupted by restart of the provisioner
+	if err := os.RemoveAll(stagingPath); err != nil {
+		return err
+	}
+
+	pvcKey, _ := cache.MetaNamespaceKeyFunc(pvc)
+	item := &population{source: source, uid: pvc.UID, pvcKey: pvcKey, cancel: make(chan struct{}), size: size}
+	populations.items[assetPath] = item
+
+	go runPopulation(log, pvc, item, assetPath)
+
+	appConfig.Event(pvc, core_v1.EventTypeNormal, "PopulationStarted", "Populating storage asset from %v", source)
+	return ErrPopulationInProgress
+}
+
+/*finishPopulation returns true along with the result if the population of the storage asset has been started already. The
+storage asset populated successfully gets its proper name. The population started for the deleted PVC of the same name is
+canceled, the storage asset is populated for the current PVC once it is forgotten*/
+func finishPopulation(log *logging.Logger, pvc *core_v1.PersistentVolumeClaim, assetPath, stagingPath string) (bool, error) {
+	populations.Lock()
+	defer populations.Unlock()
+
+	item, ok := populations.items[assetPath]
+	if !ok {
+		return false, nil
+	}
+	if item.uid != pvc.UID {
+		log.V(logging.LevelChange).Infof("PersistentVolumeClaim: %v storage asset: %v is populated for the deleted claim of the same name, the population is canceled", pvc.Name, assetPath)
+		if cancelPopulation(assetPath, item) {
+			return false, nil
+		}
+		return true, ErrPopulationInProgress
+	}
+	if !item.done {
+		log.V(logging.LevelDecision).Infof("PersistentVolumeClaim: %v storage asset: %v is still being populated from: %v (%v of %v bytes)", pvc.Name, assetPath, item.source, item.copied, item.total)
+		return true, ErrPopulationInProgress
+	}
+
+	delete(populations.items, assetPath)
+	if item.err != nil {
+		os.RemoveAll(stagingPath)
+		appConfig.Event(pvc, core_v1.EventTypeWarning, "PopulationFailed", "Population of storage asset from %v failed: %v", item.source, item.err)
+		return true, fmt.Errorf("Population of storage asset: %v from: %v failed: %v", assetPath, item.source, item.err)
+	}
+
+	if _, err := os.Stat(assetPath); err == nil {
+		return true, failures.Permanentf("Storage asset: %v already exists", assetPath)
+	}
+	if err := os.Rename(stagingPath, assetPath); err != nil {
+		return true, err
+	}
+	appConfig.Event(pvc, core_v1.EventTypeNormal, "PopulationSucceeded", "Storage asset was populated from %v (%v bytes)", item.source, item.total)
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully populated from: %v", assetPath, item.source)
+	return true, nil
+}
+
+/*CancelPopulation is the func stopping the population of the storage asset of the deleted PVC. Its staging directory is removed,
+so the PVC recreated with the same name does not get the content of the deleted one*/
+func CancelPopulation(pvcKey string) {
+	populations.Lock()
+	defer populations.Unlock()
+
+	for assetPath, item := range populations.items {
+		if item.pvcKey == pvcKey {
+			cancelPopulation(assetPath, item)
+		}
+	}
+}
+
+/*cancelPopulation returns true if the population is forgotten along with its staging directory at once, which happens if the
+copying is finished. Otherwise the copying is stopped and the population is forgotten by its goroutine, so the staging
+directory is not reused meanwhile. The populations must be locked*/
+func cancelPopulation(assetPath string, item *population) bool {
+	if item.done {
+		delete(populations.items, assetPath)
+		os.RemoveAll(stagingPathOf(assetPath))
+		return true
+	}
+	if !item.canceled() {
+		close(item.cancel)
+	}
+	return false
+}
+
+func (item *population) canceled() bool {
+	select {
+	case <-item.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func runPopulation(log *logging.Logger, pvc *core_v1.PersistentVolumeClaim, item *population, assetPath string) {
+	stagingPath := stagingPathOf(assetPath)
+	total, err := treeSize(item.source)
+
+	populations.Lock()
+	item.total = total
+	populations.Unlock()
+
+	if err == nil {
+		lastReport := time.Now()
+		err = copyTree(log, item.source, stagingPath, nil, func(copied int64) error {
+			populations.Lock()
+			item.copied = copied
+			canceled := item.canceled()
+			populations.Unlock()
+			if canceled {
+				return errPopulationCanceled
+			}
+
+			if time.Since(lastReport) >= progressReportPeriod {
+				lastReport = time.Now()
+				appConfig.Event(pvc, core_v1.EventTypeNormal, "PopulationProgress", "Populating storage asset from %v: %v%% (%v of %v bytes)", item.source, percentOf(copied, total), copied, total)
+			}
+			return nil
+		})
+	}
+	if err == nil && item.size > 0 && !item.canceled() {
+		err = growImageAsset(log, stagingPath, item.size)
+	}
+
+	populations.Lock()
+	item.done = true
+	item.err = err
+	if item.canceled() {
+		os.RemoveAll(stagingPath)
+		delete(populations.items, assetPath)
+		log.V(logging.LevelChange).Infof("Population of storage asset: %v from: %v is canceled", assetPath, item.source)
+	}
+	populations.Unlock()
+
+	if PopulationFinished != nil {
+		PopulationFinished(item.pvcKey)
+	}
+}
+
+func percentOf(value, total int64) int64 {
+	if total == 0 {
+		return 100
+	}
+	return value * 100 / total
+}
+
+/*resolveDataSource returns the path to the directory, as it is seen from container of the provisioner, which the content of
+new storage asset should be copied from*/
+func resolveDataSource(pvc *core_v1.PersistentVolumeClaim) (string, error) {
+	dataSource := pvc.Spec.DataSource
+
+	switch {
+	case dataSource.Kind == "PersistentVolumeClaim" && (dataSource.APIGroup == nil || *dataSource.APIGroup == ""):
+		return resolvePvcDataSource(pvc.Namespace, dataSource.Name)
+	case dataSource.Kind == "VolumeSnapshot" && dataSource.APIGroup != nil && *dataSource.APIGroup == snapshotAPIGroup:
+		return resolveSnapshotDataSource(pvc.Namespace, dataSource.Name)
+	default:
+		return "", failures.Permanentf("PersistentVolumeClaim: %v has unsupported data source kind: %v", pvc.Name, dataSource.Kind)
+	}
+}
+
+func resolvePvcDataSource(namespace, name string) (string, error) {
+	sourcePvc, err := appConfig.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if sourcePvc.Status.Phase != core_v1.ClaimBound || sourcePvc.Spec.VolumeName == "" {
+		return "", fmt.Errorf("Source persistentVolumeClaim: %v is not bound yet", name)
+	}
+
+	sourcePv, err := appConfig.Clientset.CoreV1().PersistentVolumes().Get(sourcePvc.Spec.VolumeName, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	sourceStorageClass, ok := appConfig.StorageClasses[sourcePv.Spec.StorageClassName]
+	if !ok || sourcePv.Annotations[config.AnnotationProvisionedBy] != sourceStorageClass.Provisioner || !appConfig.Owns(sourcePv.Annotations[config.AnnotationInstanceID]) {
+		return "", failures.Permanentf("Source persistentVolume: %v is not served by the provisioner", sourcePv.Name)
+	}
+	if sourceStorageClass.AgentURL != "" {
+		return "", failures.Permanentf("Source persistentVolume: %v is served by agent, its content is not reachable by the provisioner", sourcePv.Name)
+	}
+
+	return AssetPathOf(sourcePv), nil
+}
+
+/*resolveSnapshotDataSource finds the directory of VolumeSnapshot. The snapshot must be bound to VolumeSnapshotContent which
+driver is one of the provisioners served and which snapshotHandle is the path of the directory relative to the storage asset root*/
+func resolveSnapshotDataSource(namespace, name string) (string, error) {
+	if appConfig.DynamicClient == nil {
+		return "", failures.Permanentf("VolumeSnapshot data source: %v is not supported without dynamic client", name)
+	}
+
+	snapshot, err := appConfig.DynamicClient.Resource(volumeSnapshotResource).Namespace(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	contentName, found, err := unstructured.NestedString(snapshot.Object, "status", "boundVolumeSnapshotContentName")
+	if err != nil || !found || contentName == "" {
+		return "", fmt.Errorf("VolumeSnapshot: %v is not bound to any volumeSnapshotContent yet", name)
+	}
+
+	content, err := appConfig.DynamicClient.Resource(volumeSnapshotContentResource).Get(contentName, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	driver, _, _ := unstructured.NestedString(content.Object, "spec", "driver")
+	if !isServedProvisioner(driver) {
+		return "", failures.Permanentf("VolumeSnapshotContent: %v has driver: %v which is not served by the provisioner", contentName, driver)
+	}
+
+	handle, found, err := unstructured.NestedString(content.Object, "status", "snapshotHandle")
+	if err != nil || !found || handle == "" {
+		return "", fmt.Errorf("VolumeSnapshotContent: %v does not have snapshotHandle yet", contentName)
+	}
+
+	sourcePath := path.Join(appConfig.StorageAssetRoot, handle)
+	if !isUnderRoot(appConfig.StorageAssetRoot, sourcePath) {
+		return "", failures.Permanentf("VolumeSnapshotContent: %v has snapshotHandle pointing out of the storage asset root: %v", contentName, handle)
+	}
+
+	return sourcePath, nil
+}
+
+func isServedProvisioner(name string) bool {
+	for _, storageClass := range appConfig.StorageClasses {
+		if storageClass.Provisioner == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*isUnderRoot returns true if the cleaned path is placed strictly under the root*/
+func isUnderRoot(root, value string) bool {
+	prefix := strings.TrimSuffix(path.Clean(root), "/") + "/"
+	return strings.HasPrefix(path.Clean(value), prefix)
+}
@@ -3,13 +3,15 @@ package storage
 import (
 	"fmt"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"net"
 	"path"
 	"regexp"
 	"strings"
 
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/klog"
 )
 
 const hostnamePattern = `^([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])(\.([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]{0,61}[a-zA-Z0-9]))*$`
@@ -20,6 +22,9 @@ type pvArguments struct {
 	reclaimPolicy core_v1.PersistentVolumeReclaimPolicy
 	annotations   map[string]string
 	assetPath     string
+	hostPathType  core_v1.HostPathType
+	volume        config.VolumeSource
+	log           *logging.Logger
 }
 
 func checkMatchTrueStr(value string) bool {
@@ -36,7 +41,7 @@ func checkMatchDNSorIPV4(value string) bool {
 
 	re, err := regexp.Compile(hostnamePattern)
 	if err != nil {
-		klog.Errorf("Could not compile the pattern: %v", hostnamePattern)
+		logging.New(nil).Errorf("Could not compile the pattern: %v", hostnamePattern)
 		return false
 	}
 
@@ -44,23 +49,91 @@ func checkMatchDNSorIPV4(value string) bool {
 }
 
 /*PreparePV is function which creates storage asset(folder) and returns prepared PV structure to be created in cluster. Depending on presence colon sign in
-StorageAssetRoot field of currentStorageClass NFS or HostPath type of PV will be returned*/
-func PreparePV(pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolume, error) {
+StorageAssetRoot field of currentStorageClass NFS or HostPath type of PV will be returned. If the PVC has a data source, the storage asset is populated
+from it in background and ErrPopulationInProgress is returned until the population is finished. If the storage class has image assets,
+the storage asset is the image file and HostPath type of PV pointing to the file is returned. The PVC owned by a pod, i.e. generic
+ephemeral volume, gets its storage asset in the ephemeralAssetDir of the storage class and Delete reclaim policy unless the storage
+class inherits its own one*/
+func PreparePV(log *logging.Logger, pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolume, error) {
 	currentStorageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
 
 	uid, gid := ChooseAssetOwner(pvc)
-	storageAssetBaseName := ChooseBaseNameOfAsset(pvc.Namespace, pvc.Name)
+	//The ownership of the files on SMB share is defined by the mount options of the clients
+	if currentStorageClass.Volume.Type == config.VolumeTypeSMB {
+		uid, gid = -1, -1
+	}
+	storageAssetBaseName := baseNameOf(pvc)
+	isImage := currentStorageClass.AssetType == config.AssetTypeImage
+	storageAssetName := assetNameOf(pvc)
+	ownerPod := OwnerPodOf(pvc)
 
 	/*appStorageAssetPath is the full path to storage asset (folder) as it is seen or reachable from container of the provisioner*/
-	appStorageAssetPath := path.Join(appConfig.StorageAssetRoot, currentStorageClass.Name, storageAssetBaseName) // e.g. -> /pv-store/nfs-class1/sbx-namespace-some-app
+	appStorageAssetPath := path.Join(appConfig.StorageAssetRoot, currentStorageClass.Name, storageAssetName) // e.g. -> /pv-store/nfs-class1/sbx-namespace-some-app
 	/*pvStorageAssetPath is the full path to storage asset (folder) as it is seen or reachable from host OS i.e. out from of the provisioner*/
-	pvStorageAssetPath := path.Join(currentStorageClass.StorageAssetRoot, storageAssetBaseName) // e.g. -> /mnt/nfs/sbx-namespace-some-app
+	pvStorageAssetPath := joinAssetRoot(currentStorageClass.StorageAssetRoot, storageAssetName) // e.g. -> /mnt/nfs/sbx-namespace-some-app
+	log = log.WithAssetPath(appStorageAssetPath)
+	if ownerPod != "" {
+		log.V(logging.LevelDecision).Infof("PersistentVolumeClaim: %v is owned by pod: %v, its storage asset is ephemeral", pvc.Name, ownerPod)
+	}
 
 	var reuseExistingAsset bool
 	if value, ok := pvc.Annotations[config.AnnotationUseExistingAsset]; ok && checkMatchTrueStr(value) {
 		reuseExistingAsset = true
 	}
-	err := CreateStorageAsset(appStorageAssetPath, uid, gid, reuseExistingAsset)
+	exists, _ := appConfig.Backend.Exists(appStorageAssetPath)
+	reusedAsset := reuseExistingAsset && exists
+
+	template, err := ChooseAssetTemplate(pvc)
+	if err != nil {
+		return nil, err
+	}
+
+	//The agent manages the directories only, the content is copied by the provisioner from its local file system
+	if currentStorageClass.AgentURL != "" && (pvc.Spec.DataSource != nil || template != "") && !reusedAsset {
+		return nil, failures.Permanentf("Templates and data sources could not be used for storage class: %v served by agent", currentStorageClass.Name)
+	}
+
+	//The images and the populated storage assets do not create their parent, unlike the directories
+	if ownerPod != "" && currentStorageClass.EphemeralAssetDir != "" {
+		ephemeralDir := path.Dir(appStorageAssetPath)
+		if exists, err := appConfig.Backend.Exists(ephemeralDir); err != nil {
+			return nil, err
+		} else if !exists {
+			if err := appConfig.Backend.MkdirAll(ephemeralDir, 0755); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if appConfig.DryRun && (isImage || pvc.Spec.DataSource != nil || template != "") && !reusedAsset {
+		//The content of the storage asset is not planned in detail, the copying or formatting is only mentioned
+		log.With(logging.Fields{logging.KeyPlan: "prepareContent"}).Infof("Storage asset: %v would be created with its content", appStorageAssetPath)
+	} else if pvc.Spec.DataSource != nil && !reusedAsset {
+		//The populated storage asset keeps ownership and permissions of the data source
+		err = populateStorageAsset(log, pvc, appStorageAssetPath)
+	} else if isImage {
+		if template != "" {
+			err = failures.Permanentf("Template: %v could not be used for storage class: %v having image assets", template, currentStorageClass.Name)
+		} else {
+			size := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+			err = CreateImageAsset(log, appStorageAssetPath, size.Value(), currentStorageClass.ImageFilesystem, uid, gid, reuseExistingAsset)
+		}
+	} else {
+		err = CreateStorageAsset(log, appStorageAssetPath, uid, gid, reuseExistingAsset)
+		//Reused storage asset already has its content therefore only new one is seeded
+		if err == nil && template != "" && !reusedAsset {
+			if err = SeedStorageAsset(log, appStorageAssetPath, template, uid, gid); err != nil {
+				appConfig.Event(pvc, core_v1.EventTypeWarning, "SeedingFailed", "Seeding of storage asset from template %v failed: %v", template, err)
+				DeleteStorageAsset(log, appStorageAssetPath)
+			}
+		}
+		//The export of the reused storage asset is replaced in order to get the current clients and options
+		if err == nil && currentStorageClass.PerVolumeExports {
+			if err = ExportStorageAsset(log, storageAssetBaseName, appStorageAssetPath, pvc); err != nil && !reusedAsset {
+				DeleteStorageAsset(log, appStorageAssetPath)
+			}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -68,6 +141,13 @@ func PreparePV(pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolume, e
 	annotations := make(map[string]string)
 	annotations[config.AnnotationProvisionedBy] = currentStorageClass.Provisioner
 	annotations[config.AnnotationStorageClass] = currentStorageClass.Name
+	annotations[config.AnnotationAssetPath] = path.Join(currentStorageClass.Name, storageAssetName)
+	if appConfig.InstanceID != "" {
+		annotations[config.AnnotationInstanceID] = appConfig.InstanceID
+	}
+	if currentStorageClass.PerVolumeExports {
+		annotations[config.AnnotationExport] = storageAssetBaseName
+	}
 
 	var reclaimPolicy core_v1.PersistentVolumeReclaimPolicy
 	if value, ok := pvc.Annotations[config.AnnotationReclaimPolicy]; ok {
@@ -75,13 +155,23 @@ func PreparePV(pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolume, e
 	} else {
 		reclaimPolicy = *currentStorageClass.ReclaimPolicy
 	}
+	//Nobody is going to claim the scratch data of the pod again, it is deleted along with the claim no matter what
+	if ownerPod != "" && currentStorageClass.EphemeralReclaim == config.EphemeralReclaimDelete {
+		reclaimPolicy = core_v1.PersistentVolumeReclaimDelete
+	}
 
 	pvArgs := new(pvArguments)
 	pvArgs.name = storageAssetBaseName
 	pvArgs.assetPath = pvStorageAssetPath
+	pvArgs.volume = currentStorageClass.Volume
+	pvArgs.hostPathType = core_v1.HostPathDirectory
+	if isImage {
+		pvArgs.hostPathType = core_v1.HostPathFile
+	}
 	pvArgs.annotations = annotations
 	pvArgs.reclaimPolicy = reclaimPolicy
 	pvArgs.pvc = pvc
+	pvArgs.log = log
 
 	pv := fillPV(pvArgs)
 
@@ -91,18 +181,41 @@ func PreparePV(pvc *core_v1.PersistentVolumeClaim) (*core_v1.PersistentVolume, e
 			/*If we are here and reuseExistingAsset == false than we can be sure that storage asset was created by us a few lines earlier.
 			On next iteration when the provioner will try to provision this PV it will face with issue that the storage asset already exists.
 			Therefore because of this issue we must delete created storage asset in current iteration when the panic occured*/
-			DeleteStorageAsset(appStorageAssetPath)
+			DeleteStorageAsset(log, appStorageAssetPath)
 		}
 
-		return pv, fmt.Errorf("Could not prepare new PV")
+		return pv, failures.Permanentf("Could not prepare new PV")
 	}
 
 	return pv, nil
 }
 
-func getHostPathPersistentVolumeSource(assetPath string) core_v1.PersistentVolumeSource {
+/*baseNameOf returns the base name of the storage asset and the PV of the claim. The claims of the volumes created by the CSI driver
+might have no namespace, their names are unique by themselves*/
+func baseNameOf(pvc *core_v1.PersistentVolumeClaim) string {
+	if pvc.Namespace == "" {
+		return ChooseBaseNameOfAsset(pvc.Name)
+	}
+	return ChooseBaseNameOfAsset(pvc.Namespace, pvc.Name)
+}
+
+/*assetNameOf returns the path of the storage asset of the PVC relative to the directory of its storage class. The scratch data of
+the claims owned by pods is kept apart from the other storage assets if the storage class wants so*/
+func assetNameOf(pvc *core_v1.PersistentVolumeClaim) string {
+	currentStorageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
+	name := baseNameOf(pvc)
+	if currentStorageClass.AssetType == config.AssetTypeImage {
+		name += imageExtension
+	}
+	if OwnerPodOf(pvc) != "" {
+		name = path.Join(currentStorageClass.EphemeralAssetDir, name)
+	}
+	return name
+}
+
+func getHostPathPersistentVolumeSource(assetPath string, pathType core_v1.HostPathType) core_v1.PersistentVolumeSource {
 	hostPathType := new(core_v1.HostPathType)
-	*hostPathType = core_v1.HostPathDirectory
+	*hostPathType = pathType
 
 	return core_v1.PersistentVolumeSource{
 		HostPath: &core_v1.HostPathVolumeSource{
@@ -111,21 +224,96 @@ func getHostPathPersistentVolumeSource(assetPath string) core_v1.PersistentVolum
 		},
 	}
 }
-func getNfsPersistentVolumeSource(assetPath string) core_v1.PersistentVolumeSource {
-	nfsAssetPath := strings.Split(assetPath, ":")
-	if len(nfsAssetPath) > 2 {
-		panic(fmt.Sprintf("A storage class assetRoot must contain only 1 colon sign if NFS-like path usage is assumed. Got value: %v", assetPath))
+
+/*isReadOnly returns true if the claim requests read only access to the volume only*/
+func isReadOnly(accessModes []core_v1.PersistentVolumeAccessMode) bool {
+	for _, mode := range accessModes {
+		if mode != core_v1.ReadOnlyMany {
+			return false
+		}
+	}
+	return len(accessModes) > 0
+}
+
+func getNfsPersistentVolumeSource(assetPath string, readOnly bool) core_v1.PersistentVolumeSource {
+	server, exportPath, ok := config.SplitNFSAssetRoot(assetPath)
+	if !ok {
+		panic(fmt.Sprintf("A storage class assetRoot must have <server>:<path> form if NFS-like path usage is assumed. Got value: %v", assetPath))
 	}
 
-	if checkMatchDNSorIPV4(nfsAssetPath[0]) == false {
-		panic(fmt.Sprintf("Server name does not match the pattern: '%v'. Got value: %v", hostnamePattern, assetPath))
+	if checkMatchDNSorIPV4(server) == false && net.ParseIP(server) == nil {
+		panic(fmt.Sprintf("Server name does not match the pattern: '%v' and is not IP address. Got value: %v", hostnamePattern, assetPath))
 	}
 
 	return core_v1.PersistentVolumeSource{
 		NFS: &core_v1.NFSVolumeSource{
-			Server:   nfsAssetPath[0],
-			Path:     nfsAssetPath[1],
-			ReadOnly: false,
+			Server:   server,
+			Path:     exportPath,
+			ReadOnly: readOnly,
+		},
+	}
+}
+
+/*joinAssetRoot returns the path of the storage asset in the assetRoot of the storage class keeping the leading double slash of
+//<server>/<share> form*/
+func joinAssetRoot(assetRoot, name string) string {
+	if strings.HasPrefix(assetRoot, "//") {
+		return "/" + path.Join(assetRoot, name)
+	}
+	return path.Join(assetRoot, name)
+}
+
+/*csiVolumeAttributesOf returns the volume attributes of the storage class with the additional ones, which take precedence*/
+func csiVolumeAttributesOf(volume config.VolumeSource, additional map[string]string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range volume.CSIVolumeAttributes {
+		result[key] = value
+	}
+	for key, value := range additional {
+		result[key] = value
+	}
+	return result
+}
+
+/*getNfsCsiPersistentVolumeSource returns the csi volume source of the NFS CSI driver for the directory of the NFS server. The share
+is the parent directory of the storage asset and the subDir is its name*/
+func getNfsCsiPersistentVolumeSource(server, assetPath string, volume config.VolumeSource, readOnly bool) core_v1.PersistentVolumeSource {
+	share, subDir := path.Dir(assetPath), path.Base(assetPath)
+	return core_v1.PersistentVolumeSource{
+		CSI: &core_v1.CSIPersistentVolumeSource{
+			Driver:             volume.CSIDriver,
+			VolumeHandle:       server + "#" + share + "#" + subDir + "#",
+			ReadOnly:           readOnly,
+			VolumeAttributes:   csiVolumeAttributesOf(volume, map[string]string{"server": server, "share": share, "subDir": subDir}),
+			NodeStageSecretRef: volume.NodeStageSecretRef,
+		},
+	}
+}
+
+/*getSmbPersistentVolumeSource returns the csi volume source of the SMB CSI driver. The assetPath has //<server>/<share>/<path> form
+and is passed to the driver as the source attribute*/
+func getSmbPersistentVolumeSource(assetPath string, volume config.VolumeSource, readOnly bool) core_v1.PersistentVolumeSource {
+	return core_v1.PersistentVolumeSource{
+		CSI: &core_v1.CSIPersistentVolumeSource{
+			Driver:             volume.CSIDriver,
+			VolumeHandle:       strings.TrimPrefix(assetPath, "//"),
+			ReadOnly:           readOnly,
+			VolumeAttributes:   csiVolumeAttributesOf(volume, map[string]string{"source": assetPath}),
+			NodeStageSecretRef: volume.NodeStageSecretRef,
+		},
+	}
+}
+
+/*getCsiPersistentVolumeSource returns the csi volume source of the driver of the storage class, the driver gets the assetPath as
+the volume handle*/
+func getCsiPersistentVolumeSource(assetPath string, volume config.VolumeSource, readOnly bool) core_v1.PersistentVolumeSource {
+	return core_v1.PersistentVolumeSource{
+		CSI: &core_v1.CSIPersistentVolumeSource{
+			Driver:             volume.CSIDriver,
+			VolumeHandle:       assetPath,
+			ReadOnly:           readOnly,
+			VolumeAttributes:   csiVolumeAttributesOf(volume, nil),
+			NodeStageSecretRef: volume.NodeStageSecretRef,
 		},
 	}
 }
@@ -133,17 +321,35 @@ func getNfsPersistentVolumeSource(assetPath string) core_v1.PersistentVolumeSour
 func fillPV(args *pvArguments) *core_v1.PersistentVolume {
 	defer func() {
 		if err := recover(); err != nil {
-			klog.Error(err)
+			args.log.Errorf("%v", err)
 		}
 	}()
 
 	var persistentVolumeSource core_v1.PersistentVolumeSource
-	if strings.Contains(args.assetPath, ":") {
-		persistentVolumeSource = getNfsPersistentVolumeSource(args.assetPath)
-	} else {
-		persistentVolumeSource = getHostPathPersistentVolumeSource(args.assetPath)
+	var mountOptions []string
+	readOnly := isReadOnly(args.pvc.Spec.AccessModes)
+	switch args.volume.Type {
+	case config.VolumeTypeNFS:
+		persistentVolumeSource = getNfsPersistentVolumeSource(args.assetPath, readOnly)
+		//The in-tree NFS volume source is replaced by the one of the CSI driver if the storage class has it
+		if args.volume.CSIDriver != "" {
+			nfs := persistentVolumeSource.NFS
+			persistentVolumeSource = getNfsCsiPersistentVolumeSource(nfs.Server, nfs.Path, args.volume, readOnly)
+		}
+		mountOptions = args.volume.MountOptions
+	case config.VolumeTypeSMB:
+		persistentVolumeSource = getSmbPersistentVolumeSource(args.assetPath, args.volume, readOnly)
+		mountOptions = args.volume.MountOptions
+	case config.VolumeTypeCSI:
+		persistentVolumeSource = getCsiPersistentVolumeSource(args.assetPath, args.volume, readOnly)
+		mountOptions = args.volume.MountOptions
+	default:
+		persistentVolumeSource = getHostPathPersistentVolumeSource(args.assetPath, args.hostPathType)
 	}
 
+	//The PVC requesting Block volume mode never reaches here, the mode is set explicitly to not rely on the defaulting of API server
+	volumeMode := core_v1.PersistentVolumeFilesystem
+
 	return &core_v1.PersistentVolume{
 		TypeMeta: meta_v1.TypeMeta{
 			Kind:       "PersistentVolume",
@@ -157,8 +363,9 @@ func fillPV(args *pvArguments) *core_v1.PersistentVolume {
 			StorageClassName:              *args.pvc.Spec.StorageClassName,
 			AccessModes:                   args.pvc.Spec.AccessModes,
 			Capacity:                      args.pvc.Spec.Resources.Requests,
-			VolumeMode:                    args.pvc.Spec.VolumeMode,
+			VolumeMode:                    &volumeMode,
 			PersistentVolumeReclaimPolicy: args.reclaimPolicy,
+			MountOptions:                  mountOptions,
 			ClaimRef: &core_v1.ObjectReference{
 				Kind:      args.pvc.Kind,
 				Name:      args.pvc.Name,
@@ -170,3 +377,27 @@ func fillPV(args *pvArguments) *core_v1.PersistentVolume {
 		Status: core_v1.PersistentVolumeStatus{},
 	}
 }
+
+/*ConvertNfsToCsi is func returning the copy of the PV having in-tree NFS volume source with the equivalent csi volume source of
+the driver instead. The copy is ready to be created in place of the PV*/
+func ConvertNfsToCsi(pv *core_v1.PersistentVolume, driver string) (*core_v1.PersistentVolume, error) {
+	if pv.Spec.NFS == nil {
+		return nil, fmt.Errorf("PersistentVolume: %v does not have NFS volume source", pv.Name)
+	}
+
+	result := pv.DeepCopy()
+	result.ObjectMeta = meta_v1.ObjectMeta{
+		Name:        pv.Name,
+		Labels:      pv.Labels,
+		Annotations: make(map[string]string),
+	}
+	for key, value := range pv.Annotations {
+		result.Annotations[key] = value
+	}
+	result.Annotations[config.AnnotationMigratedFrom] = config.VolumeTypeNFS
+	result.Status = core_v1.PersistentVolumeStatus{}
+
+	nfs := pv.Spec.NFS
+	result.Spec.PersistentVolumeSource = getNfsCsiPersistentVolumeSource(nfs.Server, path.Clean(nfs.Path), config.VolumeSource{CSIDriver: driver}, nfs.ReadOnly)
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"sync"
+
+	"k8s-pv-provisioner/cmd/provisioner/config"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+//reservation is the storage admitted for the PVC which PV is not in the cache of PVs yet
+type reservation struct {
+	namespace string
+	claim     string
+	className string
+	size      int64
+}
+
+/*reservations keeps the storage admitted for the PVCs by their keys. The PVCs handled simultaneously or the PVs which have
+not reached the cache yet would exceed the limits otherwise*/
+var reservations = struct {
+	sync.Mutex
+	items map[string]reservation
+}{items: make(map[string]reservation)}
+
+/*AdmitStorage is the func running the admission check of the storage requested by the PVC while no other PVC is admitted. The
+check gets the func returning the storage in bytes reserved by the other PVCs of the storage class in the namespace or in all
+namespaces for empty one. The storage is reserved for the PVC if the check passes, the reservation lasts until the PV of the PVC
+appears in the cache of PVs or ReleaseStorage is called*/
+func AdmitStorage(pvc *core_v1.PersistentVolumeClaim, check func(reserved func(namespace string) int64) error) error {
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		return err
+	}
+	className := *pvc.Spec.StorageClassName
+
+	reservations.Lock()
+	defer reservations.Unlock()
+
+	dropProvisionedReservations()
+	reserved := func(namespace string) int64 {
+		return reservedStorage(key, className, namespace)
+	}
+	if err := check(reserved); err != nil {
+		return err
+	}
+
+	requested := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]
+	reservations.items[key] = reservation{namespace: pvc.Namespace, claim: pvc.Name, className: className, size: requested.Value()}
+	return nil
+}
+
+/*ReleaseStorage is the func forgetting the storage reserved for the PVC, e.g. its provisioning has failed*/
+func ReleaseStorage(pvcKey string) {
+	reservations.Lock()
+	defer reservations.Unlock()
+	delete(reservations.items, pvcKey)
+}
+
+/*reservedStorage returns the storage in bytes reserved for the PVCs of the storage class in the namespace or in all namespaces
+for empty one, except for the PVC of the key. The reservations must be locked*/
+func reservedStorage(key, className, namespace string) int64 {
+	var total int64
+	for item, r := range reservations.items {
+		if item != key && r.className == className && (namespace == "" || r.namespace == namespace) {
+			total += r.size
+		}
+	}
+	return total
+}
+
+/*dropProvisionedReservations forgets the reservations of the PVCs which PVs are in the cache of PVs, their storage is counted
+by the PVs from now on*/
+func dropProvisionedReservations() {
+	if appConfig.PersistentVolumes == nil {
+		return
+	}
+	for key, r := range reservations.items {
+		objects, err := appConfig.PersistentVolumes.ByIndex(config.IndexByClaimNamespace, r.namespace)
+		if err != nil {
+			continue
+		}
+		for _, obj := range objects {
+			if pv := obj.(*core_v1.PersistentVolume); pv.Spec.ClaimRef.Name == r.claim {
+				delete(reservations.items, key)
+				break
+			}
+		}
+	}
+}
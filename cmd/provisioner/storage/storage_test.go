@@ -1,12 +1,33 @@
 package storage
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"k8s-pv-provisioner/cmd/provisioner/backend"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	core_v1 "k8s.io/api/core/v1"
 	storage_v1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 var _appConfig *config.AppConfig
@@ -55,6 +76,13 @@ func Test_chooseBaseNameOfAsset(t *testing.T) {
 	checkTestResults(t, "", "some-namespace-some-pvc-vol", ChooseBaseNameOfAsset("some-namespace", "some-pvc"))
 	checkTestResults(t, "", "some-namespace-some-pvc-vol", ChooseBaseNameOfAsset("some", "namespace", "some", "pvc"))
 	checkTestResults(t, "", "some-namespace-some-pvc-claim-vol", ChooseBaseNameOfAsset("some-namespace", "some-pvc-claim"))
+	checkTestResults(t, "", "-pvc-1-vol", ChooseBaseNameOfAsset("", "pvc-1"))
+
+	pvc := new(core_v1.PersistentVolumeClaim)
+	pvc.Name = "pvc-1"
+	checkTestResults(t, "claim without namespace", "pvc-1-vol", baseNameOf(pvc))
+	pvc.Namespace = "ns1"
+	checkTestResults(t, "claim with namespace", "ns1-pvc-1-vol", baseNameOf(pvc))
 }
 
 func Test_castToInt(t *testing.T) {
@@ -120,3 +148,893 @@ func Test_chooseAsset_Owner(t *testing.T) {
 	checkTestResults(t, fmt.Sprintf("UID was gotten from PVC annotation: %v", config.AnnotationOwnerNewAssetUID1), 4000, uid)
 	checkTestResults(t, fmt.Sprintf("GID was gotten from PVC annotation: %v", config.AnnotationOwnerNewAssetGID), 2000, gid)
 }
+
+func Test_copyTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "copy-tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	src := filepath.Join(root, "src")
+	dst := filepath.Join(root, "dst")
+
+	os.MkdirAll(filepath.Join(src, "nested"), 0750)
+	ioutil.WriteFile(filepath.Join(src, "file1"), []byte("content1"), 0640)
+	ioutil.WriteFile(filepath.Join(src, "nested", "file2"), []byte("content22"), 0600)
+	os.Symlink("file1", filepath.Join(src, "link1"))
+	os.Chmod(filepath.Join(src, "nested"), 0550)
+	defer os.Chmod(filepath.Join(src, "nested"), 0750)
+
+	var progress int64
+	if err := copyTree(nil, src, dst, nil, func(copied int64) error { progress = copied; return nil }); err != nil {
+		t.Fatalf("Copying failed: %v", err)
+	}
+	defer os.Chmod(filepath.Join(dst, "nested"), 0750)
+
+	checkTestResults(t, "Copied bytes", int64(17), progress)
+
+	content, _ := ioutil.ReadFile(filepath.Join(dst, "nested", "file2"))
+	checkTestResults(t, "Content of nested file", "content22", string(content))
+
+	info, _ := os.Stat(filepath.Join(dst, "file1"))
+	checkTestResults(t, "Permissions of file", os.FileMode(0640), info.Mode().Perm())
+
+	info, _ = os.Stat(filepath.Join(dst, "nested"))
+	checkTestResults(t, "Permissions of directory", os.FileMode(0550), info.Mode().Perm())
+
+	target, _ := os.Readlink(filepath.Join(dst, "link1"))
+	checkTestResults(t, "Target of symlink", "file1", target)
+
+	size, _ := treeSize(src)
+	checkTestResults(t, "Size of tree", int64(17), size)
+}
+
+func Test_isUnderRoot(t *testing.T) {
+	checkTestResults(t, "/root/class/snapshot", true, isUnderRoot("/root", "/root/class/snapshot"))
+	checkTestResults(t, "/root/../etc", false, isUnderRoot("/root", "/root/../etc"))
+	checkTestResults(t, "/root", false, isUnderRoot("/root/", "/root"))
+	checkTestResults(t, "/rootless", false, isUnderRoot("/root", "/rootless"))
+	checkTestResults(t, "/ as root", true, isUnderRoot("/", "/class"))
+}
+
+func Test_chooseAssetTemplate(t *testing.T) {
+	sc := _appConfig.StorageClasses[_storageClassName]
+	sc.AssetTemplate = "templates/skeleton"
+	_appConfig.StorageClasses[_storageClassName] = sc
+	defer initAppConfig()
+
+	annotations := map[string]string{}
+	pvc1 := getPvcForTests(annotations, _storageClassName)
+	template, err := ChooseAssetTemplate(pvc1)
+	checkTestResults(t, "Template was gotten from storage class params", "templates/skeleton", template)
+	checkTestResults(t, "Template of storage class is accepted", nil, err)
+
+	annotations[config.AnnotationAssetTemplate] = "fixtures.tar.gz"
+	pvc2 := getPvcForTests(annotations, _storageClassName)
+	_, err = ChooseAssetTemplate(pvc2)
+	checkTestResults(t, "Template annotation is refused without templatesDir", true, failures.IsPermanent(err))
+
+	sc.TemplatesDir = "templates"
+	_appConfig.StorageClasses[_storageClassName] = sc
+	template, err = ChooseAssetTemplate(pvc2)
+	checkTestResults(t, fmt.Sprintf("Template was gotten from PVC annotation: %v", config.AnnotationAssetTemplate), "templates/fixtures.tar.gz", template)
+	checkTestResults(t, "Template annotation is accepted", nil, err)
+
+	for _, value := range []string{"../class/ns1-victim-vol", "/etc", "sub/../../class"} {
+		annotations[config.AnnotationAssetTemplate] = value
+		_, err = ChooseAssetTemplate(getPvcForTests(annotations, _storageClassName))
+		checkTestResults(t, fmt.Sprintf("Template annotation out of templatesDir: %v", value), true, failures.IsPermanent(err))
+	}
+}
+
+func Test_seedStorageAsset(t *testing.T) {
+	root, err := ioutil.TempDir("", "seed-asset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	_appConfig.StorageAssetRoot = root
+	defer initAppConfig()
+
+	os.MkdirAll(filepath.Join(root, "templates", "skeleton", "conf"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "templates", "skeleton", "conf", "app.conf"), []byte("key=value"), 0640)
+
+	archive, _ := os.Create(filepath.Join(root, "templates", "fixtures.tar.gz"))
+	gzipWriter := gzip.NewWriter(archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	tarWriter.WriteHeader(&tar.Header{Name: "fixtures/", Typeflag: tar.TypeDir, Mode: 0750})
+	tarWriter.WriteHeader(&tar.Header{Name: "fixtures/data.sql", Typeflag: tar.TypeReg, Mode: 0600, Size: 6})
+	tarWriter.Write([]byte("SELECT"))
+	tarWriter.Close()
+	gzipWriter.Close()
+	archive.Close()
+
+	uid, gid := os.Getuid(), os.Getgid()
+
+	asset1 := filepath.Join(root, "class", "asset1")
+	os.MkdirAll(asset1, 0755)
+	if err := SeedStorageAsset(nil, asset1, "templates/skeleton", uid, gid); err != nil {
+		t.Fatalf("Seeding from directory failed: %v", err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(asset1, "conf", "app.conf"))
+	checkTestResults(t, "Content of seeded file from directory", "key=value", string(content))
+
+	asset2 := filepath.Join(root, "class", "asset2")
+	os.MkdirAll(asset2, 0755)
+	if err := SeedStorageAsset(nil, asset2, "templates/fixtures.tar.gz", uid, gid); err != nil {
+		t.Fatalf("Seeding from tarball failed: %v", err)
+	}
+	content, _ = ioutil.ReadFile(filepath.Join(asset2, "fixtures", "data.sql"))
+	checkTestResults(t, "Content of seeded file from tarball", "SELECT", string(content))
+	info, _ := os.Stat(filepath.Join(asset2, "fixtures"))
+	checkTestResults(t, "Permissions of seeded directory from tarball", os.FileMode(0750), info.Mode().Perm())
+
+	if err := SeedStorageAsset(nil, asset2, "../outside", uid, gid); err == nil {
+		t.Error("Template out of the storage asset root must be refused")
+	}
+
+	//The entries following a symlink of the archive would be written out of the storage asset
+	for _, link := range []*tar.Header{
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc", Mode: 0777},
+		{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "/etc/passwd", Mode: 0644},
+	} {
+		archive, _ := os.Create(filepath.Join(root, "templates", "links.tar"))
+		tarWriter := tar.NewWriter(archive)
+		tarWriter.WriteHeader(link)
+		tarWriter.WriteHeader(&tar.Header{Name: "escape/file", Typeflag: tar.TypeReg, Mode: 0600, Size: 1})
+		tarWriter.Write([]byte("x"))
+		tarWriter.Close()
+		archive.Close()
+
+		asset3 := filepath.Join(root, "class", "asset3")
+		os.RemoveAll(asset3)
+		os.MkdirAll(asset3, 0755)
+		err := SeedStorageAsset(nil, asset3, "templates/links.tar", uid, gid)
+		checkTestResults(t, fmt.Sprintf("Link entry: %v is refused", link.Name), true, failures.IsPermanent(err))
+		_, err = os.Lstat(filepath.Join(asset3, link.Name))
+		checkTestResults(t, fmt.Sprintf("Link entry: %v is not created", link.Name), true, os.IsNotExist(err))
+	}
+}
+
+func Test_checkStorageAssetRoots(t *testing.T) {
+	root, err := ioutil.TempDir("", "asset-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	_appConfig.StorageAssetRoot = root
+	defer initAppConfig()
+
+	if err := CheckStorageAssetRoots(); err != nil {
+		t.Errorf("Writable storage asset root must pass the check: %v", err)
+	}
+
+	_appConfig.StorageAssetRoot = filepath.Join(root, "absent")
+	if err := CheckStorageAssetRoots(); err == nil {
+		t.Error("Absent storage asset root must not pass the check")
+	}
+}
+
+func Test_isReadOnly(t *testing.T) {
+	checkTestResults(t, "no access modes", false, isReadOnly(nil))
+	checkTestResults(t, "ReadOnlyMany only", true, isReadOnly([]core_v1.PersistentVolumeAccessMode{core_v1.ReadOnlyMany}))
+	checkTestResults(t, "ReadOnlyMany and ReadWriteOnce", false, isReadOnly([]core_v1.PersistentVolumeAccessMode{core_v1.ReadOnlyMany, core_v1.ReadWriteOnce}))
+
+	source := getNfsPersistentVolumeSource("nfs-server:/some/path", true)
+	checkTestResults(t, "read only NFS source", true, source.NFS.ReadOnly)
+}
+
+func Test_nfsServers(t *testing.T) {
+	source := getNfsPersistentVolumeSource("[fd00::1]:/export/asset", false)
+	checkTestResults(t, "IPv6 server in brackets", "fd00::1", source.NFS.Server)
+	checkTestResults(t, "path of IPv6 server", "/export/asset", source.NFS.Path)
+	source = getNfsPersistentVolumeSource("fd00::1:/export/asset", false)
+	checkTestResults(t, "bare IPv6 server", "fd00::1", source.NFS.Server)
+	source = getNfsPersistentVolumeSource("10.0.0.1:/export/asset", false)
+	checkTestResults(t, "IPv4 server", "10.0.0.1", source.NFS.Server)
+
+	for _, assetRoot := range []string{"/export", "server:", ":/export", "fd00:zz::1:/export", "[server]:/export"} {
+		_, _, ok := config.SplitNFSAssetRoot(assetRoot)
+		checkTestResults(t, "invalid NFS assetRoot: "+assetRoot, false, ok)
+	}
+}
+
+func Test_createImageAsset(t *testing.T) {
+	root, err := ioutil.TempDir("", "image-asset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var commands []string
+	originalRunCommand := runCommand
+	defer func() {
+		runCommand = originalRunCommand
+	}()
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name)
+		if name == "mkfs.xfs" {
+			return []byte("some output"), fmt.Errorf("exit status 1")
+		}
+		return nil, nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+
+	image1 := filepath.Join(root, "image1.img")
+	if err := CreateImageAsset(nil, image1, 1<<30, "ext4", uid, gid, false); err != nil {
+		t.Fatalf("Image must be created: %v", err)
+	}
+	info, err := os.Stat(image1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestResults(t, "size of image", int64(1<<30), info.Size())
+	checkTestResults(t, "formatted with ext4", "mkfs.ext4", commands[0])
+
+	err = CreateImageAsset(nil, image1, 1<<30, "ext4", uid, gid, false)
+	checkTestResults(t, "existing image is not overwritten", true, failures.IsPermanent(err))
+	checkTestResults(t, "existing image is reused", nil, CreateImageAsset(nil, image1, 1<<30, "ext4", uid, gid, true))
+
+	image2 := filepath.Join(root, "image2.img")
+	if err := CreateImageAsset(nil, image2, 1<<20, "xfs", uid, gid, false); err == nil {
+		t.Error("Failed formatting must be returned")
+	}
+	_, err = os.Stat(image2)
+	checkTestResults(t, "image is removed when formatting failed", true, os.IsNotExist(err))
+
+	image3 := filepath.Join(root, "image3.img")
+	checkTestResults(t, "raw image", nil, CreateImageAsset(nil, image3, 1<<20, config.ImageFilesystemNone, uid, gid, false))
+	checkTestResults(t, "raw image is not formatted", 2, len(commands))
+
+	err = CreateImageAsset(nil, filepath.Join(root, "image4.img"), 0, "ext4", uid, gid, false)
+	checkTestResults(t, "image without size", true, failures.IsPermanent(err))
+
+	appConfig.Backend = backend.NewMemory()
+	defer func() { appConfig.Backend = backend.Local{} }()
+	err = CreateImageAsset(nil, filepath.Join(root, "image5.img"), 1<<20, "ext4", uid, gid, false)
+	checkTestResults(t, "image of backend without local file system", true, failures.IsPermanent(err))
+	err = SeedStorageAsset(nil, filepath.Join(root, "asset"), "template", uid, gid)
+	checkTestResults(t, "template of backend without local file system", true, failures.IsPermanent(err))
+}
+
+func Test_assetPathOf(t *testing.T) {
+	pv := new(core_v1.PersistentVolume)
+	pv.Name = "pv1"
+	pv.Spec.StorageClassName = _storageClassName
+	checkTestResults(t, "PV without annotation", "/some/path/storageClass1/pv1", AssetPathOf(pv))
+
+	pv.Annotations = map[string]string{config.AnnotationAssetPath: "storageClass1/pv1.img"}
+	checkTestResults(t, "PV with annotation", "/some/path/storageClass1/pv1.img", AssetPathOf(pv))
+
+	pv.Annotations[config.AnnotationAssetPath] = "../../etc"
+	checkTestResults(t, "annotation pointing out of the root", "/some/path/storageClass1/pv1", AssetPathOf(pv))
+}
+
+func Test_dryRunStorageAsset(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = backend.NewDryRun(memory)
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	checkTestResults(t, "planned creation", nil, CreateStorageAsset(log, "/root/class/asset", 1000, 1000, false))
+	exists, _ := memory.Exists("/root/class/asset")
+	checkTestResults(t, "asset is not created", false, exists)
+
+	memory.MkdirAll("/root/class/existing", 0755)
+	checkTestResults(t, "existing asset is read through", true, failures.IsPermanent(CreateStorageAsset(log, "/root/class/existing", 1000, 1000, false)))
+	checkTestResults(t, "planned deletion", nil, DeleteStorageAsset(log, "/root/class/existing"))
+	exists, _ = memory.Exists("/root/class/existing")
+	checkTestResults(t, "asset is not deleted", true, exists)
+}
+
+func Test_preparePV(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	pvc := getPvcForTests(map[string]string{config.AnnotationOwnerNewAssetUID1: "2000"}, _storageClassName)
+	pvc.Namespace = "ns1"
+	pv, err := PreparePV(log, pvc)
+	checkTestResults(t, "PV is prepared", nil, err)
+	assetPath := "/some/path/storageClass1/ns1-test-pvc-vol"
+	checkTestResults(t, "host path of PV", "/some/path/ns1-test-pvc-vol", pv.Spec.HostPath.Path)
+	uid, gid, err := memory.Owner(assetPath)
+	checkTestResults(t, "storage asset is created", nil, err)
+	checkTestResults(t, "uid of storage asset", 2000, uid)
+	checkTestResults(t, "gid of storage asset", 1000, gid)
+
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "existing storage asset", true, failures.IsPermanent(err))
+
+	pvc.Annotations[config.AnnotationUseExistingAsset] = "true"
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "reused storage asset", nil, err)
+
+	checkTestResults(t, "storage asset is deleted", nil, DeleteStorageAsset(log, AssetPathOf(pv)))
+	exists, _ := memory.Exists(assetPath)
+	checkTestResults(t, "storage asset does not exist", false, exists)
+}
+
+func Test_exportOptionsOf(t *testing.T) {
+	checkTestResults(t, "read write", "rw,sync,no_subtree_check", exportOptionsOf("rw,sync,no_subtree_check", false))
+	checkTestResults(t, "read only", "ro,sync,no_subtree_check", exportOptionsOf("rw,sync,no_subtree_check", true))
+	checkTestResults(t, "read only without rw", "ro,sync", exportOptionsOf("sync", true))
+}
+
+func Test_perVolumeExports(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	class := new(storage_v1.StorageClass)
+	class.Name = "exportedClass"
+	class.Provisioner = "some-vendor/some-provisioner1"
+	class.ReclaimPolicy = &retainPolicy
+	class.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "fileserver:/export/exported",
+		"agentUrl":             "http://fileserver:8090",
+		"perVolumeExports":     "true",
+		"exportClients":        "10.0.0.0/24"}
+	appConfig.ParseStorageClass(class)
+	defer delete(appConfig.StorageClasses, class.Name)
+
+	pvc := getPvcForTests(map[string]string{}, class.Name)
+	pvc.Namespace = "ns1"
+	pv, err := PreparePV(log, pvc)
+	checkTestResults(t, "PV is prepared", nil, err)
+	checkTestResults(t, "export annotation", "ns1-test-pvc-vol", pv.Annotations[config.AnnotationExport])
+	checkTestResults(t, "NFS path references the export", "/export/exported/ns1-test-pvc-vol", pv.Spec.NFS.Path)
+	checkTestResults(t, "storage asset is exported", "/some/path/exportedClass/ns1-test-pvc-vol", memory.ExportOf("ns1-test-pvc-vol"))
+
+	checkTestResults(t, "export is removed", nil, UnexportStorageAsset(log, pv))
+	checkTestResults(t, "storage asset is not exported", "", memory.ExportOf("ns1-test-pvc-vol"))
+}
+
+func Test_joinAssetRoot(t *testing.T) {
+	checkTestResults(t, "hostPath", "/mnt/pv/asset", joinAssetRoot("/mnt/pv/", "asset"))
+	checkTestResults(t, "nfs", "server:/export/asset", joinAssetRoot("server:/export", "asset"))
+	checkTestResults(t, "smb", "//server/share/dir/asset", joinAssetRoot("//server/share/dir/", "asset"))
+}
+
+func Test_volumeTypes(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	smbClass := new(storage_v1.StorageClass)
+	smbClass.Name = "smbClass"
+	smbClass.Provisioner = "some-vendor/some-provisioner1"
+	smbClass.ReclaimPolicy = &retainPolicy
+	smbClass.MountOptions = []string{"uid=1000", "gid=1000"}
+	smbClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid":                           "1000",
+		"defaultOwnerAssetGid":                           "1000",
+		"assetRoot":                                      "//fileserver/share/pv",
+		"volumeType":                                     "smb",
+		"csi.storage.k8s.io/node-stage-secret-name":      "smbcreds",
+		"csi.storage.k8s.io/node-stage-secret-namespace": "storage"}
+	appConfig.ParseStorageClass(smbClass)
+	defer delete(appConfig.StorageClasses, smbClass.Name)
+
+	pvc := getPvcForTests(map[string]string{}, smbClass.Name)
+	pvc.Namespace = "ns1"
+	pvc.Spec.AccessModes = []core_v1.PersistentVolumeAccessMode{core_v1.ReadWriteMany}
+	pv, err := PreparePV(log, pvc)
+	checkTestResults(t, "smb PV is prepared", nil, err)
+	checkTestResults(t, "smb driver", "smb.csi.k8s.io", pv.Spec.CSI.Driver)
+	checkTestResults(t, "smb source", "//fileserver/share/pv/ns1-test-pvc-vol", pv.Spec.CSI.VolumeAttributes["source"])
+	checkTestResults(t, "smb volume handle", "fileserver/share/pv/ns1-test-pvc-vol", pv.Spec.CSI.VolumeHandle)
+	checkTestResults(t, "smb secret", "storage/smbcreds", pv.Spec.CSI.NodeStageSecretRef.Namespace+"/"+pv.Spec.CSI.NodeStageSecretRef.Name)
+	checkTestResults(t, "smb mount options", "uid=1000,gid=1000", strings.Join(pv.Spec.MountOptions, ","))
+	uid, gid, _ := memory.Owner("/some/path/smbClass/ns1-test-pvc-vol")
+	checkTestResults(t, "smb ownership is not changed", "0:0", fmt.Sprintf("%v:%v", uid, gid))
+
+	csiClass := smbClass.DeepCopy()
+	csiClass.Name = "csiClass"
+	csiClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/export/pv",
+		"volumeType":           "csi",
+		"csiDriver":            "example.csi.k8s.io",
+		"csiVolumeAttributes":  "tier=gold, zone=a"}
+	appConfig.ParseStorageClass(csiClass)
+	defer delete(appConfig.StorageClasses, csiClass.Name)
+
+	pvc = getPvcForTests(map[string]string{}, csiClass.Name)
+	pvc.Namespace = "ns1"
+	pv, err = PreparePV(log, pvc)
+	checkTestResults(t, "csi PV is prepared", nil, err)
+	checkTestResults(t, "csi driver", "example.csi.k8s.io", pv.Spec.CSI.Driver)
+	checkTestResults(t, "csi volume handle", "/export/pv/ns1-test-pvc-vol", pv.Spec.CSI.VolumeHandle)
+	checkTestResults(t, "csi attributes", "gold/a", pv.Spec.CSI.VolumeAttributes["tier"]+"/"+pv.Spec.CSI.VolumeAttributes["zone"])
+	checkTestResults(t, "csi without secret", true, pv.Spec.CSI.NodeStageSecretRef == nil)
+
+	pvc = getPvcForTests(map[string]string{}, _storageClassName)
+	pvc.Namespace = "ns2"
+	pv, err = PreparePV(log, pvc)
+	checkTestResults(t, "hostPath PV is prepared", nil, err)
+	checkTestResults(t, "hostPath without mount options", 0, len(pv.Spec.MountOptions))
+}
+
+func Test_nfsCsi(t *testing.T) {
+	appConfig.Backend = backend.NewMemory()
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	nfsClass := new(storage_v1.StorageClass)
+	nfsClass.Name = "nfsCsiClass"
+	nfsClass.Provisioner = "some-vendor/some-provisioner1"
+	nfsClass.ReclaimPolicy = &retainPolicy
+	nfsClass.MountOptions = []string{"nfsvers=4.1"}
+	nfsClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "nfs1:/exports/pv",
+		"csiDriver":            "nfs.csi.k8s.io"}
+	appConfig.ParseStorageClass(nfsClass)
+	defer delete(appConfig.StorageClasses, nfsClass.Name)
+
+	pvc := getPvcForTests(map[string]string{}, nfsClass.Name)
+	pvc.Namespace = "ns1"
+	pv, err := PreparePV(log, pvc)
+	checkTestResults(t, "nfs csi PV is prepared", nil, err)
+	checkTestResults(t, "nfs csi driver", "nfs.csi.k8s.io", pv.Spec.CSI.Driver)
+	checkTestResults(t, "nfs csi server", "nfs1", pv.Spec.CSI.VolumeAttributes["server"])
+	checkTestResults(t, "nfs csi share", "/exports/pv", pv.Spec.CSI.VolumeAttributes["share"])
+	checkTestResults(t, "nfs csi subDir", "ns1-test-pvc-vol", pv.Spec.CSI.VolumeAttributes["subDir"])
+	checkTestResults(t, "nfs csi volume handle", "nfs1#/exports/pv#ns1-test-pvc-vol#", pv.Spec.CSI.VolumeHandle)
+	checkTestResults(t, "nfs csi mount options", "nfsvers=4.1", strings.Join(pv.Spec.MountOptions, ","))
+
+	inTree := pv.DeepCopy()
+	inTree.ResourceVersion = "42"
+	inTree.Finalizers = []string{"kubernetes.io/pv-protection"}
+	inTree.Status.Phase = core_v1.VolumeBound
+	inTree.Spec.PersistentVolumeSource = core_v1.PersistentVolumeSource{
+		NFS: &core_v1.NFSVolumeSource{Server: "nfs1", Path: "/exports/pv/ns1-test-pvc-vol/", ReadOnly: true}}
+	converted, err := ConvertNfsToCsi(inTree, "nfs.csi.k8s.io")
+	checkTestResults(t, "nfs PV is converted", nil, err)
+	checkTestResults(t, "converted PV has the same handle", pv.Spec.CSI.VolumeHandle, converted.Spec.CSI.VolumeHandle)
+	checkTestResults(t, "converted PV is read-only", true, converted.Spec.CSI.ReadOnly)
+	checkTestResults(t, "converted PV is marked", "nfs", converted.Annotations[config.AnnotationMigratedFrom])
+	checkTestResults(t, "converted PV is new object", "", converted.ResourceVersion)
+	checkTestResults(t, "converted PV has no finalizers", 0, len(converted.Finalizers))
+	checkTestResults(t, "converted PV has no status", core_v1.PersistentVolumePhase(""), converted.Status.Phase)
+	checkTestResults(t, "in-tree PV is not changed", true, inTree.Spec.NFS != nil && inTree.Annotations[config.AnnotationMigratedFrom] == "")
+
+	_, err = ConvertNfsToCsi(converted, "nfs.csi.k8s.io")
+	checkTestResults(t, "csi PV is not converted", true, err != nil)
+}
+
+func Test_ephemeralClaims(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	defer func() { appConfig.Backend = backend.Local{} }()
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	scratchClass := new(storage_v1.StorageClass)
+	scratchClass.Name = "scratchClass"
+	scratchClass.Provisioner = "some-vendor/some-provisioner1"
+	scratchClass.ReclaimPolicy = &retainPolicy
+	scratchClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/mnt/pv",
+		"ephemeralAssetDir":    "scratch",
+		"ephemeralReclaim":     config.EphemeralReclaimDelete}
+	appConfig.ParseStorageClass(scratchClass)
+	defer delete(appConfig.StorageClasses, scratchClass.Name)
+
+	isController := true
+	owner := meta_v1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "job-1", Controller: &isController}
+
+	pvc := getPvcForTests(map[string]string{config.AnnotationReclaimPolicy: "Retain"}, scratchClass.Name)
+	pvc.Namespace = "ns1"
+	pvc.Name = "job-1-scratch"
+	checkTestResults(t, "claim without owner", "", OwnerPodOf(pvc))
+	pvc.OwnerReferences = []meta_v1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "job", Controller: &isController}}
+	checkTestResults(t, "claim owned by job", "", OwnerPodOf(pvc))
+	pvc.OwnerReferences = append(pvc.OwnerReferences, owner)
+	checkTestResults(t, "claim owned by pod", "job-1", OwnerPodOf(pvc))
+
+	pv, err := PreparePV(log, pvc)
+	checkTestResults(t, "ephemeral PV is prepared", nil, err)
+	checkTestResults(t, "ephemeral PV is deleted regardless of class and annotation", core_v1.PersistentVolumeReclaimDelete, pv.Spec.PersistentVolumeReclaimPolicy)
+	checkTestResults(t, "ephemeral PV name", "ns1-job-1-scratch-vol", pv.Name)
+	checkTestResults(t, "host path of ephemeral PV", "/mnt/pv/scratch/ns1-job-1-scratch-vol", pv.Spec.HostPath.Path)
+	checkTestResults(t, "asset path of ephemeral PV", "/some/path/scratchClass/scratch/ns1-job-1-scratch-vol", AssetPathOf(pv))
+	exists, _ := memory.Exists(AssetPathOf(pv))
+	checkTestResults(t, "scratch storage asset is created", true, exists)
+
+	pvc = getPvcForTests(map[string]string{}, scratchClass.Name)
+	pvc.Namespace = "ns1"
+	pv, err = PreparePV(log, pvc)
+	checkTestResults(t, "PV is prepared", nil, err)
+	checkTestResults(t, "PV keeps reclaim policy of class", core_v1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+	checkTestResults(t, "host path of PV", "/mnt/pv/ns1-test-pvc-vol", pv.Spec.HostPath.Path)
+
+	delete(scratchClass.Parameters, "ephemeralReclaim")
+	appConfig.ParseStorageClass(scratchClass)
+	checkTestResults(t, "ephemeral reclaim policy is inherited by default", config.EphemeralReclaimInherit, appConfig.StorageClasses[scratchClass.Name].EphemeralReclaim)
+	pvc.Name = "job-2-scratch"
+	pvc.OwnerReferences = []meta_v1.OwnerReference{owner}
+	pv, err = PreparePV(log, pvc)
+	checkTestResults(t, "inheriting PV is prepared", nil, err)
+	checkTestResults(t, "inheriting PV keeps reclaim policy of class", core_v1.PersistentVolumeReclaimRetain, pv.Spec.PersistentVolumeReclaimPolicy)
+}
+
+func Test_ephemeralContent(t *testing.T) {
+	root, err := ioutil.TempDir("", "ephemeral-content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(original string) { appConfig.StorageAssetRoot = original }(appConfig.StorageAssetRoot)
+	appConfig.StorageAssetRoot = root
+	defer func(original func(string, ...string) ([]byte, error)) { runCommand = original }(runCommand)
+	runCommand = func(name string, args ...string) ([]byte, error) { return nil, nil }
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	newClass := func(name string, parameters map[string]string) *storage_v1.StorageClass {
+		class := new(storage_v1.StorageClass)
+		class.Name = name
+		class.Provisioner = "some-vendor/some-provisioner1"
+		class.ReclaimPolicy = &retainPolicy
+		class.Parameters = map[string]string{
+			"defaultOwnerAssetUid": strconv.Itoa(os.Getuid()),
+			"defaultOwnerAssetGid": strconv.Itoa(os.Getgid()),
+			"assetRoot":            "/mnt/" + name,
+			"ephemeralAssetDir":    "scratch"}
+		for key, value := range parameters {
+			class.Parameters[key] = value
+		}
+		appConfig.ParseStorageClass(class)
+		return class
+	}
+	imageClass := newClass("imageClass", map[string]string{"assetType": config.AssetTypeImage})
+	defer delete(appConfig.StorageClasses, imageClass.Name)
+	copyClass := newClass("copyClass", nil)
+	defer delete(appConfig.StorageClasses, copyClass.Name)
+
+	isController := true
+	owner := meta_v1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "job-1", Controller: &isController}
+	newClaim := func(className string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(map[string]string{}, className)
+		pvc.Namespace = "ns1"
+		pvc.Name = "job-1-scratch"
+		pvc.OwnerReferences = []meta_v1.OwnerReference{owner}
+		pvc.Spec.Resources.Requests = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("1Mi")}
+		return pvc
+	}
+
+	pv, err := PreparePV(log, newClaim(imageClass.Name))
+	if err != nil {
+		t.Fatalf("Ephemeral image PV must be prepared: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(root, "imageClass", "scratch", "ns1-job-1-scratch-vol.img"))
+	checkTestResults(t, "ephemeral image is created in new directory", true, err == nil && info.Size() == 1<<20)
+	checkTestResults(t, "host path of ephemeral image", "/mnt/imageClass/scratch/ns1-job-1-scratch-vol.img", pv.Spec.HostPath.Path)
+
+	sourceDir := filepath.Join(root, "copyClass", "source")
+	os.MkdirAll(sourceDir, 0755)
+	ioutil.WriteFile(filepath.Join(sourceDir, "data"), []byte("content"), 0644)
+	sourcePvc := &core_v1.PersistentVolumeClaim{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: "source"}}
+	sourcePvc.Spec.VolumeName = "source-pv"
+	sourcePvc.Status.Phase = core_v1.ClaimBound
+	sourcePv := &core_v1.PersistentVolume{ObjectMeta: meta_v1.ObjectMeta{Name: "source-pv", Annotations: map[string]string{
+		config.AnnotationProvisionedBy: copyClass.Provisioner,
+		config.AnnotationAssetPath:     "copyClass/source"}}}
+	sourcePv.Spec.StorageClassName = copyClass.Name
+	appConfig.Clientset = fake.NewSimpleClientset(sourcePvc, sourcePv)
+	defer func() { appConfig.Clientset = nil }()
+	finished := make(chan string, 1)
+	defer func(original func(string)) { PopulationFinished = original }(PopulationFinished)
+	PopulationFinished = func(key string) { finished <- key }
+
+	pvc := newClaim(copyClass.Name)
+	pvc.Spec.DataSource = &core_v1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "source"}
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "ephemeral population is started", ErrPopulationInProgress, err)
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Population is not finished")
+	}
+	pv, err = PreparePV(log, pvc)
+	checkTestResults(t, "ephemeral populated PV is prepared", nil, err)
+	content, _ := ioutil.ReadFile(filepath.Join(root, "copyClass", "scratch", "ns1-job-1-scratch-vol", "data"))
+	checkTestResults(t, "ephemeral storage asset is populated in new directory", "content", string(content))
+}
+
+func Test_populationOfDeletedClaim(t *testing.T) {
+	root, err := ioutil.TempDir("", "deleted-population")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(original string) { appConfig.StorageAssetRoot = original }(appConfig.StorageAssetRoot)
+	appConfig.StorageAssetRoot = root
+	finished := make(chan string, 1)
+	defer func(original func(string)) { PopulationFinished = original }(PopulationFinished)
+	PopulationFinished = func(key string) { finished <- key }
+	log := logging.New(nil)
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	class := new(storage_v1.StorageClass)
+	class.Name = "copyClass"
+	class.Provisioner = "some-vendor/some-provisioner1"
+	class.ReclaimPolicy = &retainPolicy
+	class.Parameters = map[string]string{
+		"defaultOwnerAssetUid": strconv.Itoa(os.Getuid()),
+		"defaultOwnerAssetGid": strconv.Itoa(os.Getgid()),
+		"assetRoot":            "/mnt/copyClass"}
+	appConfig.ParseStorageClass(class)
+	defer delete(appConfig.StorageClasses, class.Name)
+
+	objects := make([]runtime.Object, 0)
+	for _, name := range []string{"source-a", "source-b"} {
+		os.MkdirAll(filepath.Join(root, class.Name, name), 0755)
+		ioutil.WriteFile(filepath.Join(root, class.Name, name, "data"), []byte(name), 0644)
+		sourcePvc := &core_v1.PersistentVolumeClaim{ObjectMeta: meta_v1.ObjectMeta{Namespace: "ns1", Name: name}}
+		sourcePvc.Spec.VolumeName = name + "-pv"
+		sourcePvc.Status.Phase = core_v1.ClaimBound
+		sourcePv := &core_v1.PersistentVolume{ObjectMeta: meta_v1.ObjectMeta{Name: name + "-pv", Annotations: map[string]string{
+			config.AnnotationProvisionedBy: class.Provisioner,
+			config.AnnotationAssetPath:     class.Name + "/" + name}}}
+		sourcePv.Spec.StorageClassName = class.Name
+		objects = append(objects, sourcePvc, sourcePv)
+	}
+	appConfig.Clientset = fake.NewSimpleClientset(objects...)
+	defer func() { appConfig.Clientset = nil }()
+
+	assetPath := filepath.Join(root, class.Name, "ns1-test-pvc-vol")
+	newClaim := func(uid, source string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(map[string]string{}, class.Name)
+		pvc.Namespace = "ns1"
+		pvc.UID = types.UID(uid)
+		pvc.Spec.DataSource = &core_v1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: source}
+		return pvc
+	}
+	waitPopulation := func() {
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Population is not finished")
+		}
+	}
+	checkContent := func(description, expected string) {
+		content, _ := ioutil.ReadFile(filepath.Join(assetPath, "data"))
+		checkTestResults(t, description, expected, string(content))
+	}
+
+	//The population of the deleted PVC is forgotten along with its staging directory
+	_, err = PreparePV(log, newClaim("uid-1", "source-a"))
+	checkTestResults(t, "population is started", ErrPopulationInProgress, err)
+	CancelPopulation("ns1/test-pvc")
+	waitPopulation()
+	populations.Lock()
+	_, ok := populations.items[assetPath]
+	populations.Unlock()
+	checkTestResults(t, "population of deleted claim is forgotten", false, ok)
+	_, err = os.Stat(stagingPathOf(assetPath))
+	checkTestResults(t, "staging directory of deleted claim is removed", true, os.IsNotExist(err))
+
+	pvc := newClaim("uid-2", "source-b")
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "population of recreated claim is started", ErrPopulationInProgress, err)
+	waitPopulation()
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "PV of recreated claim is prepared", nil, err)
+	checkContent("recreated claim gets its own data source", "source-b")
+
+	//The PVC recreated before its deletion is handled does not get the storage asset populated for the deleted one
+	os.RemoveAll(assetPath)
+	_, err = PreparePV(log, newClaim("uid-3", "source-a"))
+	checkTestResults(t, "population is started again", ErrPopulationInProgress, err)
+	waitPopulation()
+	pvc = newClaim("uid-4", "source-b")
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "population of deleted claim is replaced", ErrPopulationInProgress, err)
+	waitPopulation()
+	_, err = PreparePV(log, pvc)
+	checkTestResults(t, "PV of claim recreated unnoticed is prepared", nil, err)
+	checkContent("claim recreated unnoticed gets its own data source", "source-b")
+}
+
+func Test_checkCapacity(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	defer func() { appConfig.Backend = backend.Local{} }()
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	capacityClass := new(storage_v1.StorageClass)
+	capacityClass.Name = "capacityClass"
+	capacityClass.Provisioner = "some-vendor/some-provisioner1"
+	capacityClass.ReclaimPolicy = &retainPolicy
+	capacityClass.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/mnt/pv",
+		"capacityAdmission":    "true",
+		"capacityHeadroom":     "10%"}
+	appConfig.ParseStorageClass(capacityClass)
+	defer delete(appConfig.StorageClasses, capacityClass.Name)
+
+	memory.MkdirAll("/some/path", 0755)
+	memory.SetCapacity(100 << 30)
+	memory.AddFile("/some/path/data", 50<<30, 0644)
+
+	newPvc := func(size string) *core_v1.PersistentVolumeClaim {
+		pvc := getPvcForTests(map[string]string{}, capacityClass.Name)
+		pvc.Namespace = "ns1"
+		pvc.Spec.Resources.Requests = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse(size)}
+		return pvc
+	}
+
+	allocatable, err := AllocatableCapacity(capacityClass.Name)
+	checkTestResults(t, "capacity of root is used until class directory exists", nil, err)
+	checkTestResults(t, "allocatable is available minus headroom percent", int64(40<<30), allocatable)
+	checkTestResults(t, "claim fits", nil, CheckCapacity(newPvc("40Gi")))
+	err = CheckCapacity(newPvc("41Gi"))
+	checkTestResults(t, "claim does not fit", true, IsInsufficientCapacity(err))
+
+	pvc := newPvc("41Gi")
+	pvc.Annotations[config.AnnotationUseExistingAsset] = "true"
+	checkTestResults(t, "missing asset to reuse needs space", true, IsInsufficientCapacity(CheckCapacity(pvc)))
+	memory.MkdirAll("/some/path/capacityClass/ns1-test-pvc-vol", 0755)
+	checkTestResults(t, "existing asset is reused without space", nil, CheckCapacity(pvc))
+
+	capacityClass.Parameters["capacityHeadroom"] = "60Gi"
+	appConfig.ParseStorageClass(capacityClass)
+	allocatable, _ = AllocatableCapacity(capacityClass.Name)
+	checkTestResults(t, "nothing is allocatable within headroom", int64(0), allocatable)
+
+	capacityClass.Parameters["capacityHeadroom"] = "10Gi"
+	capacityClass.Parameters["capacityOvercommit"] = "1.5"
+	appConfig.ParseStorageClass(capacityClass)
+	allocatable, _ = AllocatableCapacity(capacityClass.Name)
+	checkTestResults(t, "allocatable is overcommitted", int64(60<<30), allocatable)
+	checkTestResults(t, "overcommitted claim fits", nil, CheckCapacity(newPvc("60Gi")))
+	ReleaseStorage("ns1/test-pvc")
+
+	//The PVs of the storage class commit the storage whatever is used by them
+	appConfig.PersistentVolumes = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{
+		config.IndexByClaimNamespace: func(obj interface{}) ([]string, error) {
+			return []string{obj.(*core_v1.PersistentVolume).Spec.ClaimRef.Namespace}, nil
+		},
+	})
+	defer func() { appConfig.PersistentVolumes = nil }()
+	pv := new(core_v1.PersistentVolume)
+	pv.Name = "pv-1"
+	pv.Annotations = map[string]string{config.AnnotationProvisionedBy: capacityClass.Provisioner}
+	pv.Spec.StorageClassName = capacityClass.Name
+	pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "pvc-1"}
+	pv.Spec.Capacity = core_v1.ResourceList{core_v1.ResourceStorage: resource.MustParse("100Gi")}
+	appConfig.PersistentVolumes.Add(pv)
+	allocatable, _ = AllocatableCapacity(capacityClass.Name)
+	checkTestResults(t, "committed storage is not allocatable", int64(35<<30), allocatable)
+	other := newPvc("10Gi")
+	other.Name = "other-pvc"
+	checkTestResults(t, "claim fits beside committed storage", nil, CheckCapacity(other))
+	allocatable, _ = AllocatableCapacity(capacityClass.Name)
+	checkTestResults(t, "reserved storage is not allocatable", int64(25<<30), allocatable)
+	checkTestResults(t, "claim does not fit beside committed and reserved storage", true, IsInsufficientCapacity(CheckCapacity(newPvc("26Gi"))))
+	ReleaseStorage("ns1/other-pvc")
+	appConfig.PersistentVolumes.Delete(pv)
+
+	memory.SetCapacity(0)
+	checkTestResults(t, "unknown capacity", true, CheckCapacity(newPvc("1Gi")) != nil && !IsInsufficientCapacity(CheckCapacity(newPvc("1Gi"))))
+
+	capacityClass.Parameters["capacityAdmission"] = "false"
+	appConfig.ParseStorageClass(capacityClass)
+	checkTestResults(t, "claim is not checked without admission", nil, CheckCapacity(newPvc("1Ti")))
+}
+
+func Test_populateStorageAsset_resolvesWithoutLock(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var locked bool
+	clientset.PrependReactor("get", "persistentvolumeclaims", func(action k8s_testing.Action) (bool, runtime.Object, error) {
+		if locked = !populations.TryLock(); !locked {
+			populations.Unlock()
+		}
+		return true, nil, errors.New("source is not reachable")
+	})
+	appConfig.Clientset = clientset
+	defer func() { appConfig.Clientset = nil }()
+
+	pvc := getPvcForTests(map[string]string{}, _storageClassName)
+	pvc.Namespace = "ns1"
+	pvc.Spec.DataSource = &core_v1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "source"}
+	err := populateStorageAsset(logging.New(nil), pvc, "/some/path/storageClass1/ns1-test-pvc-vol")
+	checkTestResults(t, "error of data source", "source is not reachable", fmt.Sprint(err))
+	checkTestResults(t, "populations are not locked during API calls", false, locked)
+}
+
+func Test_copyFileSparse(t *testing.T) {
+	root, err := ioutil.TempDir("", "sparse-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	//The source has data in the middle of holes
+	src := filepath.Join(root, "src.img")
+	file, _ := os.Create(src)
+	file.WriteAt([]byte("data"), 8<<20)
+	file.Truncate(16 << 20)
+	file.Close()
+
+	dst := filepath.Join(root, "dst.img")
+	written, err := copyFile(src, dst, 0600, func(int64) error { return nil })
+	checkTestResults(t, "sparse file is copied", nil, err)
+	checkTestResults(t, "bytes copied", int64(16<<20), written)
+
+	content, _ := ioutil.ReadFile(dst)
+	original, _ := ioutil.ReadFile(src)
+	checkTestResults(t, "content of copy", true, bytes.Equal(original, content))
+	info, _ := os.Stat(dst)
+	checkTestResults(t, "holes are kept", true, info.Sys().(*syscall.Stat_t).Blocks*512 < 1<<20)
+}
+
+func Test_growImageAsset(t *testing.T) {
+	root, err := ioutil.TempDir("", "grow-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	var commands []string
+	defer func(original func(string, ...string) ([]byte, error)) { runCommand = original }(runCommand)
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil, nil
+	}
+
+	newImage := func(name string, offset int64, magic []byte) string {
+		imagePath := filepath.Join(root, name)
+		file, _ := os.Create(imagePath)
+		file.WriteAt(magic, offset)
+		file.Truncate(1 << 20)
+		file.Close()
+		return imagePath
+	}
+	ext4 := newImage("ext4.img", 1080, []byte{0x53, 0xEF})
+	xfs := newImage("xfs.img", 0, []byte("XFSB"))
+	raw := newImage("raw.img", 0, []byte("raw"))
+
+	filesystem, _ := imageFilesystemOf(ext4)
+	checkTestResults(t, "ext4 is recognized", "ext4", filesystem)
+	filesystem, _ = imageFilesystemOf(xfs)
+	checkTestResults(t, "xfs is recognized", "xfs", filesystem)
+	filesystem, _ = imageFilesystemOf(raw)
+	checkTestResults(t, "unknown file system", config.ImageFilesystemNone, filesystem)
+
+	log := logging.New(nil)
+	checkTestResults(t, "ext4 image is grown", nil, growImageAsset(log, ext4, 2<<20))
+	info, _ := os.Stat(ext4)
+	checkTestResults(t, "size of grown image", int64(2<<20), info.Size())
+	checkTestResults(t, "file system is resized", "e2fsck -f -p "+ext4+", resize2fs "+ext4, strings.Join(commands, ", "))
+
+	commands = nil
+	checkTestResults(t, "raw image is grown", nil, growImageAsset(log, raw, 2<<20))
+	checkTestResults(t, "raw image is not resized", 0, len(commands))
+	checkTestResults(t, "image is not shrunk", nil, growImageAsset(log, raw, 1<<20))
+	info, _ = os.Stat(raw)
+	checkTestResults(t, "size of image which is not shrunk", int64(2<<20), info.Size())
+
+	checkTestResults(t, "xfs image of the same size", nil, checkImageGrowable(xfs, 1<<20))
+	checkTestResults(t, "xfs image is not grown", true, failures.IsPermanent(growImageAsset(log, xfs, 2<<20)))
+}
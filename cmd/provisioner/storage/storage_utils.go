@@ -2,14 +2,19 @@ package storage
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 
+	"k8s-pv-provisioner/cmd/provisioner/backend"
 	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
 
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/klog"
 )
 
 var appConfig = config.GetInstance()
@@ -26,6 +31,17 @@ func ChooseBaseNameOfAsset(args ...string) string {
 	return strings.Join(result, "-") //-> arg1-arg2...-argN-vol
 }
 
+/*OwnerPodOf is func returning the name of the pod controlling the PVC or empty string. Such PVC is created by kubernetes for the
+generic ephemeral volume of the pod and it is deleted along with the pod*/
+func OwnerPodOf(pvc *v1.PersistentVolumeClaim) string {
+	for _, owner := range pvc.OwnerReferences {
+		if owner.Kind == "Pod" && owner.APIVersion == "v1" && owner.Controller != nil && *owner.Controller {
+			return owner.Name
+		}
+	}
+	return ""
+}
+
 func processOwnerAnnotation(pvc *v1.PersistentVolumeClaim, value string, defaultValue int) int {
 	var result int
 	var err error
@@ -34,7 +50,7 @@ func processOwnerAnnotation(pvc *v1.PersistentVolumeClaim, value string, default
 	if ok {
 		result, err = castToInt(value)
 		if err != nil {
-			klog.Warningf("PersistentVolumeClaim: %v annotation: %v could not parse value: %v: %v", pvc.Name, config.AnnotationOwnerNewAssetUID, value, err)
+			logging.New(nil).WithPVC(pvc).Warningf("PersistentVolumeClaim: %v annotation: %v could not parse value: %v: %v", pvc.Name, config.AnnotationOwnerNewAssetUID, value, err)
 			result = defaultValue
 		}
 		return result
@@ -71,13 +87,13 @@ func castToInt(value string) (int, error) {
 }
 
 //CreateStorageAsset is func which creates the storage asset
-func CreateStorageAsset(assetPath string, uid, gid int, reuseExisting bool) error {
+func CreateStorageAsset(log *logging.Logger, assetPath string, uid, gid int, reuseExisting bool) error {
 
-	if _, err := os.Stat(assetPath); err == nil && !reuseExisting {
-		return fmt.Errorf("Storage asset: %v already exists", assetPath)
+	if exists, _ := appConfig.Backend.Exists(assetPath); exists && !reuseExisting {
+		return failures.Permanentf("Storage asset: %v already exists", assetPath)
 	}
-	//If assetPath already exists os.MkdirAll does nothing
-	if err := os.MkdirAll(assetPath, 0755); os.IsPermission(err) {
+	//If assetPath already exists MkdirAll does nothing
+	if err := appConfig.Backend.MkdirAll(assetPath, 0755); os.IsPermission(err) {
 		return err
 	}
 
@@ -87,25 +103,83 @@ func CreateStorageAsset(assetPath string, uid, gid int, reuseExisting bool) erro
 	} else {
 		action = "created"
 	}
-	klog.Infof("Storage asset: %v was successfully %v", assetPath, action)
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully %v", assetPath, action)
 
-	if err := os.Chown(assetPath, uid, gid); err != nil {
+	//Negative uid and gid mean the ownership is not managed by the provisioner
+	if uid < 0 && gid < 0 {
+		return nil
+	}
+	if err := appConfig.Backend.Chown(assetPath, uid, gid); err != nil {
 		return err
 	}
-	klog.Infof("Storage asset: %v ownership was set as %v:%v", assetPath, uid, gid)
+	log.V(logging.LevelChange).Infof("Storage asset: %v ownership was set as %v:%v", assetPath, uid, gid)
+
+	return nil
+}
 
+/*requireLocalBackend is func refusing to fill the storage asset served by a backend other than the local file system, because
+the images, templates and data sources are written by os functions of the provisioner directly*/
+func requireLocalBackend(assetPath string) error {
+	if !backend.IsLocal(appConfig.Backend, assetPath) {
+		return failures.Permanentf("Content of storage asset: %v could not be created, its backend does not work with the local file system", assetPath)
+	}
 	return nil
 }
 
-//DeleteStorageAsset is func deleting the storage asset
-func DeleteStorageAsset(assetPath string) error {
+/*AssetPathOf is func returning the path to the storage asset of the PV as it is seen from container of the provisioner. The
+PVs provisioned by the earlier versions do not have the annotation of the path, their storage assets are named after them*/
+func AssetPathOf(pv *v1.PersistentVolume) string {
+	if value, ok := pv.Annotations[config.AnnotationAssetPath]; ok {
+		assetPath := path.Join(appConfig.StorageAssetRoot, value)
+		if isUnderRoot(appConfig.StorageAssetRoot, assetPath) {
+			return assetPath
+		}
+	}
+	return path.Join(appConfig.StorageAssetRoot, pv.Spec.StorageClassName, pv.Name)
+}
+
+//DeleteStorageAsset is func deleting the storage asset which is either a directory or an image file
+func DeleteStorageAsset(log *logging.Logger, assetPath string) error {
 
-	err := os.RemoveAll(assetPath)
+	err := appConfig.Backend.RemoveAll(assetPath)
 	if err != nil {
 		return err
 	}
 
-	klog.Infof("Storage asset: %v was successfully deleted", assetPath)
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully deleted", assetPath)
+
+	return nil
+}
+
+/*CheckStorageAssetRoots is func returning error if directory of any served storage class under the storage asset root is not
+writable. If the directory of a storage class does not exist yet the storage asset root itself is checked. The root directory
+of the agent serving a storage class must exist*/
+func CheckStorageAssetRoots() error {
+	names := make([]string, 0, len(appConfig.StorageClasses))
+	for name := range appConfig.StorageClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dir := path.Join(appConfig.StorageAssetRoot, name)
+		if agentURL := appConfig.StorageClasses[name].AgentURL; agentURL != "" {
+			if exists, err := appConfig.Backend.Exists(dir); !exists {
+				return fmt.Errorf("Root directory of agent: %v serving storage class: %v is not available: %v", agentURL, name, err)
+			}
+			continue
+		}
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			dir = appConfig.StorageAssetRoot
+		}
+
+		probe, err := ioutil.TempFile(dir, ".provisioner-probe-")
+		if err != nil {
+			return fmt.Errorf("Storage asset directory: %v of storage class: %v is not writable: %v", dir, name, err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
 
 	return nil
 }
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/failures"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+/*ChooseAssetTemplate is func which decides what template the new asset should be seeded from, the path is relative to the storage
+asset root. The PVC annotation has more precedence than the parameter of the storage class, but it might only pick a template from
+templatesDir of the storage class, otherwise the claims could copy the storage assets of each other. Empty string means that the
+asset should not be seeded*/
+func ChooseAssetTemplate(pvc *core_v1.PersistentVolumeClaim) (string, error) {
+	currentStorageClass := appConfig.StorageClasses[*pvc.Spec.StorageClassName]
+	value, ok := pvc.Annotations[config.AnnotationAssetTemplate]
+	if !ok {
+		return currentStorageClass.AssetTemplate, nil
+	}
+
+	if currentStorageClass.TemplatesDir == "" {
+		return "", failures.Permanentf("Template annotation could not be used for storage class: %v without templatesDir parameter", currentStorageClass.Name)
+	}
+	template := path.Join(currentStorageClass.TemplatesDir, value)
+	if path.IsAbs(value) || !isUnderRoot(currentStorageClass.TemplatesDir, template) {
+		return "", failures.Permanentf("Template: %v points out of templatesDir of storage class: %v", value, currentStorageClass.Name)
+	}
+	return template, nil
+}
+
+func isTarball(value string) bool {
+	return strings.HasSuffix(value, ".tar") || isGzippedTarball(value)
+}
+
+func isGzippedTarball(value string) bool {
+	return strings.HasSuffix(value, ".tar.gz") || strings.HasSuffix(value, ".tgz")
+}
+
+/*SeedStorageAsset is func which fills the storage asset with content of the template. The template is path relative to the
+storage asset root pointing to a directory or to a tar(.gz) file. All seeded items get uid and gid as their owner*/
+func SeedStorageAsset(log *logging.Logger, assetPath, template string, uid, gid int) error {
+	if err := requireLocalBackend(assetPath); err != nil {
+		return err
+	}
+	templatePath := path.Join(appConfig.StorageAssetRoot, template)
+	if !isUnderRoot(appConfig.StorageAssetRoot, templatePath) {
+		return failures.Permanentf("Template: %v points out of the storage asset root", template)
+	}
+	if _, err := os.Stat(templatePath); os.IsNotExist(err) {
+		return failures.Permanentf("Template: %v does not exist", template)
+	}
+
+	owner := &assetOwner{uid: uid, gid: gid}
+
+	var err error
+	if isTarball(templatePath) {
+		err = extractTarball(log, templatePath, assetPath, owner)
+	} else {
+		err = copyTree(log, templatePath, assetPath, owner, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.V(logging.LevelChange).Infof("Storage asset: %v was successfully seeded from template: %v", assetPath, template)
+	return nil
+}
+
+/*extractTarball unpacks the archive to the dst directory. Entries pointing out of the dst directory are refused as well as the
+links, because the entries following a link would be written wherever it points to*/
+func extractTarball(log *logging.Logger, archive, dst string, owner *assetOwner) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if isGzippedTarball(archive) {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		dstPath := filepath.Join(dst, header.Name)
+		if dstPath != filepath.Clean(dst) && !isUnderRoot(dst, dstPath) {
+			return failures.Permanentf("Archive: %v entry: %v points out of the destination", archive, header.Name)
+		}
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if dstPath == filepath.Clean(dst) {
+				continue
+			}
+			if err := os.MkdirAll(dstPath, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tarReader); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return failures.Permanentf("Archive: %v entry: %v is a link which is not supported", archive, header.Name)
+		default:
+			log.Warningf("Skipping extraction of unsupported entry: %v from archive: %v", header.Name, archive)
+			continue
+		}
+
+		if err := os.Lchown(dstPath, owner.uid, owner.gid); err != nil {
+			return err
+		}
+		//Chmod is needed after chown because the last one clears setuid and setgid bits
+		if err := os.Chmod(dstPath, mode); err != nil {
+			return err
+		}
+	}
+}
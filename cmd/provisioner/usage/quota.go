@@ -0,0 +1,69 @@
+package usage
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+//runCommand runs the external command returning its combined output
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+/*projectOf returns the XFS project ID of the directory, 0 means the directory does not belong to any project. The output of
+xfs_io looks like: projid = 42*/
+func projectOf(dir string) (uint64, error) {
+	output, err := runCommand("xfs_io", "-r", "-c", "lsproj", dir)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read project of: %v: %v: %s", dir, err, output)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 3 || fields[0] != "projid" || fields[1] != "=" {
+		return 0, fmt.Errorf("Unexpected output of lsproj for: %v: %s", dir, output)
+	}
+	return strconv.ParseUint(fields[2], 10, 32)
+}
+
+/*quotaUsage returns the usage in bytes accounted to the project of the storage asset by XFS quota. The false is returned if the
+storage asset does not belong to any project. The report of xfs_quota has a line per mounted file system:
+<device> <used KiB> <soft limit> <hard limit> <warnings> <grace> <mount point>, the one of the longest mount point containing the
+storage asset is used*/
+func quotaUsage(assetPath string) (int64, bool, error) {
+	project, err := projectOf(assetPath)
+	if err != nil || project == 0 {
+		return 0, false, err
+	}
+
+	output, err := runCommand("xfs_quota", "-x", "-c", fmt.Sprintf("quota -p -N -b -n %d", project))
+	if err != nil {
+		return 0, false, fmt.Errorf("Could not read quota of project: %v: %v: %s", project, err, output)
+	}
+	var used int64
+	var mountPoint string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		current := fields[len(fields)-1]
+		if !isUnder(current, assetPath) || len(current) <= len(mountPoint) {
+			continue
+		}
+		kibibytes, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("Unexpected quota report of project: %v: %v", project, line)
+		}
+		used, mountPoint = kibibytes*1024, current
+	}
+	if mountPoint == "" {
+		return 0, false, fmt.Errorf("Quota of project: %v is not reported for the file system of: %v", project, assetPath)
+	}
+	return used, true, nil
+}
+
+//isUnder returns true if the path is the directory itself or any path inside it
+func isUnder(dir, path string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
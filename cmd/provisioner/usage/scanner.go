@@ -0,0 +1,217 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+	"k8s-pv-provisioner/cmd/provisioner/logging"
+	"k8s-pv-provisioner/cmd/provisioner/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	//SourceWalk is the source of usage summing up the sizes of the files of the storage asset
+	SourceWalk = "walk"
+	//SourceXFSQuota is the source of usage reading the XFS project quota of the storage asset
+	SourceXFSQuota = "xfs-quota"
+)
+
+var appConfig = config.GetInstance()
+
+/*UsedBytes is the gauge of the disk usage of the storage assets of the provisioned PVs. The PVs which have been deleted or have
+not been scanned successfully do not have it*/
+var UsedBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "provisioner_volume_used_bytes",
+	Help: "Disk usage of the storage asset of the provisioned PV in bytes",
+}, []string{"pv", "namespace", "pvc", "class"})
+
+func init() {
+	prometheus.MustRegister(UsedBytes)
+}
+
+/*Options is the set of parameters of the scanner*/
+type Options struct {
+	//Interval is the pause between the end of a scan and the start of the next one
+	Interval time.Duration
+	//QPS is maximal number of storage assets scanned per second, the assets are scanned one by one anyway
+	QPS float64
+	//WalkQPS is maximal number of files and directories read per second by the walks of the storage assets, 0 means no limit
+	WalkQPS float64
+	//Source is either SourceWalk or SourceXFSQuota
+	Source string
+}
+
+/*Scanner measures the disk usage of the storage assets of the provisioned PVs in background. The usage is published by
+UsedBytes gauge and by the annotations of the PVs*/
+type Scanner struct {
+	options Options
+	limiter *rate.Limiter
+	//walkLimiter throttles the walk of each storage asset, so a huge tree does not saturate the file server either
+	walkLimiter *rate.Limiter
+	//labels are the labels of UsedBytes of the PVs scanned by the previous scan
+	labels map[string]prometheus.Labels
+	log    *logging.Logger
+}
+
+/*NewScanner is the func which is like a constructor. The error is returned if the source of usage is unknown*/
+func NewScanner(options Options) (*Scanner, error) {
+	if options.Source != SourceWalk && options.Source != SourceXFSQuota {
+		return nil, fmt.Errorf("Unknown source of usage: %v", options.Source)
+	}
+	if options.QPS <= 0 {
+		return nil, fmt.Errorf("Number of storage assets scanned per second must be positive: %v", options.QPS)
+	}
+	if options.WalkQPS < 0 {
+		return nil, fmt.Errorf("Number of files read per second must not be negative: %v", options.WalkQPS)
+	}
+	return &Scanner{
+		options:     options,
+		limiter:     rate.NewLimiter(rate.Limit(options.QPS), 1),
+		walkLimiter: backend.NewWalkLimiter(options.WalkQPS),
+		labels:      make(map[string]prometheus.Labels),
+		log:         logging.New(logging.Fields{logging.KeyController: "UsageScanner"}),
+	}, nil
+}
+
+/*Run is the method scanning the storage assets repeatedly until the stop channel is closed*/
+func (s *Scanner) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	s.log.Infof("Starting usage scanner with interval: %v, source: %v", s.options.Interval, s.options.Source)
+	wait.Until(func() { s.Scan(ctx) }, s.options.Interval, stop)
+	s.log.Infof("Usage scanner is stopped")
+}
+
+/*Scan is the method measuring the usage of the storage asset of each PV provisioned by the current instance. The scan is
+interrupted once the context is done*/
+func (s *Scanner) Scan(ctx context.Context) {
+	started := time.Now()
+	pvs := scannedPVs()
+	scanned := make(map[string]prometheus.Labels)
+	var failed int
+	for _, pv := range pvs {
+		if err := s.limiter.Wait(ctx); err != nil {
+			s.log.V(logging.LevelDecision).Infof("Usage scan is interrupted: %v", err)
+			return
+		}
+		labels, err := s.scanPV(ctx, pv)
+		if ctx.Err() != nil {
+			s.log.V(logging.LevelDecision).Infof("Usage scan is interrupted: %v", ctx.Err())
+			return
+		}
+		if err != nil {
+			s.log.WithPV(pv).Warningf("Usage of PersistentVolume: %v could not be scanned: %v", pv.Name, err)
+			failed++
+			continue
+		}
+		scanned[pv.Name] = labels
+	}
+
+	//The series of the deleted PVs and of the ones failed to scan are removed
+	for name, labels := range s.labels {
+		if current, ok := scanned[name]; !ok || !equalLabels(current, labels) {
+			UsedBytes.Delete(labels)
+		}
+	}
+	s.labels = scanned
+	s.log.V(logging.LevelChange).Infof("Usage of: %v PVs was scanned in: %v, failed PVs: %v", len(scanned), time.Since(started), failed)
+}
+
+/*scanPV measures the usage of the storage asset of the PV, sets the gauge and stamps the usage on the PV. The labels of the
+gauge are returned*/
+func (s *Scanner) scanPV(ctx context.Context, pv *core_v1.PersistentVolume) (prometheus.Labels, error) {
+	assetPath := storage.AssetPathOf(pv)
+	used, err := s.usageOf(ctx, pv, assetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := prometheus.Labels{"pv": pv.Name, "namespace": "", "pvc": "", "class": pv.Spec.StorageClassName}
+	if claim := pv.Spec.ClaimRef; claim != nil {
+		labels["namespace"], labels["pvc"] = claim.Namespace, claim.Name
+	}
+	UsedBytes.With(labels).Set(float64(used))
+
+	//The PV is patched only when the usage has changed, so the unchanged storage assets do not cause writes and PV events
+	if usedBytes := strconv.FormatInt(used, 10); pv.Annotations[config.AnnotationUsedBytes] != usedBytes {
+		patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"annotations": map[string]string{
+			config.AnnotationUsedBytes:    usedBytes,
+			config.AnnotationLastScanned: time.Now().UTC().Format(time.RFC3339),
+		}}})
+		if err == nil {
+			_, err = appConfig.Clientset.CoreV1().PersistentVolumes().Patch(pv.Name, types.MergePatchType, patch)
+		}
+		if err != nil {
+			//The gauge is kept, the annotations are written by the next scan
+			s.log.WithPV(pv).Warningf("PersistentVolume: %v could not be annotated with usage: %v", pv.Name, err)
+		}
+	}
+	s.log.WithPV(pv).WithAssetPath(assetPath).V(logging.LevelDebug).Infof("Storage asset: %v uses: %v bytes", assetPath, used)
+	return labels, nil
+}
+
+/*usageOf returns the usage of the storage asset in bytes. The storage assets served by agents are always walked by them, the
+local ones without project quota are walked as well*/
+func (s *Scanner) usageOf(ctx context.Context, pv *core_v1.PersistentVolume, assetPath string) (int64, error) {
+	if s.options.Source == SourceXFSQuota && appConfig.StorageClasses[pv.Spec.StorageClassName].AgentURL == "" {
+		used, ok, err := quotaUsage(assetPath)
+		if err != nil || ok {
+			return used, err
+		}
+	}
+	if exists, err := appConfig.Backend.Exists(assetPath); !exists {
+		return 0, fmt.Errorf("Storage asset: %v does not exist: %v", assetPath, err)
+	}
+	return backend.WalkUsage(appConfig.Backend, assetPath, func() error {
+		return s.walkLimiter.Wait(ctx)
+	})
+}
+
+/*scannedPVs returns the PVs from the cache which have been provisioned for the served storage classes and belong to the
+current instance, sorted by name*/
+func scannedPVs() []*core_v1.PersistentVolume {
+	result := make([]*core_v1.PersistentVolume, 0)
+	if appConfig.PersistentVolumes == nil {
+		return result
+	}
+	for _, item := range appConfig.PersistentVolumes.List() {
+		pv, ok := item.(*core_v1.PersistentVolume)
+		if !ok || pv.DeletionTimestamp != nil {
+			continue
+		}
+		class, ok := appConfig.StorageClasses[pv.Spec.StorageClassName]
+		if !ok || pv.Annotations[config.AnnotationProvisionedBy] != class.Provisioner || !appConfig.Owns(pv.Annotations[config.AnnotationInstanceID]) {
+			continue
+		}
+		result = append(result, pv)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func equalLabels(a, b prometheus.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if b[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s-pv-provisioner/cmd/provisioner/backend"
+	"k8s-pv-provisioner/cmd/provisioner/config"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	core_v1 "k8s.io/api/core/v1"
+	storage_v1 "k8s.io/api/storage/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8s_testing "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func checkTestResults(t *testing.T, description string, expected, actual interface{}) {
+	if expected != actual {
+		t.Errorf("Description: '%v', Expected value: %v but actual: %v", description, expected, actual)
+	}
+}
+
+func provisionedPV(name, className, provisioner string) *core_v1.PersistentVolume {
+	pv := new(core_v1.PersistentVolume)
+	pv.Name = name
+	pv.Annotations = map[string]string{
+		config.AnnotationProvisionedBy: provisioner,
+		config.AnnotationAssetPath:     className + "/" + name,
+	}
+	pv.Spec.StorageClassName = className
+	pv.Spec.ClaimRef = &core_v1.ObjectReference{Namespace: "ns1", Name: "claim-" + name}
+	return pv
+}
+
+func Test_scan(t *testing.T) {
+	memory := backend.NewMemory()
+	appConfig.Backend = memory
+	appConfig.StorageAssetRoot = "/pv-store"
+	defer func() { appConfig.Backend = backend.Local{} }()
+
+	var retainPolicy = core_v1.PersistentVolumeReclaimRetain
+	class := new(storage_v1.StorageClass)
+	class.Name = "usageClass"
+	class.Provisioner = "some-vendor/some-provisioner1"
+	class.ReclaimPolicy = &retainPolicy
+	class.Parameters = map[string]string{
+		"defaultOwnerAssetUid": "1000",
+		"defaultOwnerAssetGid": "1000",
+		"assetRoot":            "/mnt/pv"}
+	appConfig.ParseStorageClass(class)
+	defer delete(appConfig.StorageClasses, class.Name)
+
+	memory.MkdirAll("/pv-store/usageClass/pv1/data", 0755)
+	memory.AddFile("/pv-store/usageClass/pv1/a", 1000, 0644)
+	memory.AddFile("/pv-store/usageClass/pv1/data/b", 24, 0644)
+	memory.MkdirAll("/pv-store/usageClass/pv2", 0755)
+
+	pv1 := provisionedPV("pv1", class.Name, class.Provisioner)
+	pv2 := provisionedPV("pv2", class.Name, class.Provisioner)
+	foreign := provisionedPV("foreign", class.Name, "other-provisioner")
+	missing := provisionedPV("missing", class.Name, class.Provisioner)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	clientset := fake.NewSimpleClientset()
+	for _, pv := range []*core_v1.PersistentVolume{pv1, pv2, foreign, missing} {
+		indexer.Add(pv)
+		clientset.CoreV1().PersistentVolumes().Create(pv)
+	}
+	appConfig.PersistentVolumes = indexer
+	appConfig.Clientset = clientset
+	defer func() { appConfig.PersistentVolumes, appConfig.Clientset = nil, nil }()
+
+	_, err := NewScanner(Options{Interval: time.Minute, QPS: 1, Source: "du"})
+	checkTestResults(t, "unknown source", true, err != nil)
+	_, err = NewScanner(Options{Interval: time.Minute, QPS: 1, WalkQPS: -1, Source: SourceWalk})
+	checkTestResults(t, "negative walk rate", true, err != nil)
+	scanner, err := NewScanner(Options{Interval: time.Minute, QPS: 1000, Source: SourceWalk})
+	checkTestResults(t, "scanner is created", nil, err)
+
+	scanner.Scan(context.Background())
+	checkTestResults(t, "usage of pv1", float64(1024), testutil.ToFloat64(UsedBytes.WithLabelValues("pv1", "ns1", "claim-pv1", class.Name)))
+	checkTestResults(t, "usage of pv2", float64(0), testutil.ToFloat64(UsedBytes.WithLabelValues("pv2", "ns1", "claim-pv2", class.Name)))
+	checkTestResults(t, "scanned PVs", 2, len(scanner.labels))
+
+	annotated, _ := clientset.CoreV1().PersistentVolumes().Get("pv1", meta_v1.GetOptions{})
+	checkTestResults(t, "used bytes annotation", "1024", annotated.Annotations[config.AnnotationUsedBytes])
+	_, err = time.Parse(time.RFC3339, annotated.Annotations[config.AnnotationLastScanned])
+	checkTestResults(t, "last scanned annotation", nil, err)
+	notAnnotated, _ := clientset.CoreV1().PersistentVolumes().Get("foreign", meta_v1.GetOptions{})
+	checkTestResults(t, "foreign PV is not annotated", "", notAnnotated.Annotations[config.AnnotationUsedBytes])
+
+	//The PV having the same usage is not patched again
+	indexer.Update(annotated)
+	clientset.ClearActions()
+	scanner.Scan(context.Background())
+	var patched []string
+	for _, action := range clientset.Actions() {
+		if patch, ok := action.(k8s_testing.PatchAction); ok {
+			patched = append(patched, patch.GetName())
+		}
+	}
+	checkTestResults(t, "only PVs with changed usage are patched", "pv2", strings.Join(patched, ","))
+
+	//The series of the deleted PV is removed by the next scan
+	indexer.Delete(pv2)
+	scanner.Scan(context.Background())
+	checkTestResults(t, "series of deleted PV", 1, testutil.CollectAndCount(UsedBytes))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	scanner.Scan(ctx)
+	checkTestResults(t, "interrupted scan keeps series", 1, testutil.CollectAndCount(UsedBytes))
+}
+
+func Test_quotaUsage(t *testing.T) {
+	defer func(original func(string, ...string) ([]byte, error)) { runCommand = original }(runCommand)
+	var commands []string
+	outputs := map[string]string{
+		"xfs_io":    "projid = 42\n",
+		"xfs_quota": "/dev/sdb1 2048 0 4096 00 [--------] /srv\n/dev/sdc1 3 0 0 00 [--------] /srv/pv-store\n/dev/sdd1 7 0 0 00 [--------] /other\n",
+	}
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return []byte(outputs[name]), nil
+	}
+
+	used, ok, err := quotaUsage("/srv/pv-store/class1/pv1")
+	checkTestResults(t, "quota is read", nil, err)
+	checkTestResults(t, "asset has project", true, ok)
+	checkTestResults(t, "usage of the longest mount point", int64(3*1024), used)
+	checkTestResults(t, "quota command", "xfs_quota -x -c quota -p -N -b -n 42", commands[1])
+
+	outputs["xfs_io"] = "projid = 0\n"
+	_, ok, err = quotaUsage("/srv/pv-store/class1/pv1")
+	checkTestResults(t, "asset without project", false, ok || err != nil)
+
+	outputs["xfs_io"] = "projid = 42\n"
+	_, _, err = quotaUsage("/mnt/elsewhere/pv1")
+	checkTestResults(t, "file system is not reported", true, err != nil)
+
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		return []byte("foreign filesystem"), fmt.Errorf("exit status 1")
+	}
+	_, _, err = quotaUsage("/srv/pv-store/class1/pv1")
+	checkTestResults(t, "xfs_io fails", true, err != nil)
+}
+
+func Test_isUnder(t *testing.T) {
+	checkTestResults(t, "root", true, isUnder("/", "/srv"))
+	checkTestResults(t, "same path", true, isUnder("/srv", "/srv"))
+	checkTestResults(t, "child", true, isUnder("/srv/", "/srv/pv"))
+	checkTestResults(t, "sibling with the same prefix", false, isUnder("/srv", "/srv2/pv"))
+}
@@ -66,10 +66,30 @@ Create the name of the service account to use
 {{- end -}}
 {{- end -}}
 
+{{/*
+The storage classes served by the provisioner, the ones having the CSI driver as provisioner are served by the driver
+*/}}
 {{- define "nfs-pv-provision.storageClassesList" -}}
 {{- $classNames := list  -}}
+{{- $csi := .Values.csi -}}
 {{- range .Values.storageClasses -}}
+{{- if not (and $csi.enabled (eq .provisionerName $csi.driverName)) -}}
 {{- $classNames = append $classNames .name -}}
 {{- end -}}
+{{- end -}}
+{{ $classNames | join "," }}
+{{- end -}}
+
+{{/*
+The storage classes served by the CSI driver
+*/}}
+{{- define "nfs-pv-provision.csiStorageClassesList" -}}
+{{- $classNames := list  -}}
+{{- $csi := .Values.csi -}}
+{{- range .Values.storageClasses -}}
+{{- if eq .provisionerName $csi.driverName -}}
+{{- $classNames = append $classNames .name -}}
+{{- end -}}
+{{- end -}}
 {{ $classNames | join "," }}
 {{- end -}}